@@ -0,0 +1,269 @@
+/*
+ * Copyright 2014 The starfruit Authors. All rights reserved.
+ * Use of this source code is governed by a BSD-style
+ * license that can be found in the LICENSE file.
+ */
+
+package account
+
+import (
+	"errors"
+	"github.com/flatpeach/starfruit/casemapping"
+	"golang.org/x/crypto/bcrypt"
+	"strings"
+	"time"
+)
+
+var (
+	ErrNotFound = errors.New("Account not found")
+	ErrExists   = errors.New("Account already registered")
+)
+
+type Account struct {
+	Name      string   `json:"name"`
+	Password  string   `json:"password"` // Salted hash of the password
+	Certfps   []string `json:"certfps"`  // SHA-256 fingerprints of client certificates
+	CreatedAt int64    `json:"created"`
+	Email     string   `json:"email,omitempty"`
+	Pending   string   `json:"pending,omitempty"` // Salted hash of the code verifying the account
+	Nicks     []string `json:"nicks,omitempty"`   // Nicks grouped with the account besides its name
+
+	PendingExpiry int64  `json:"pending_expiry,omitempty"` // When the verification code expires, never if 0
+	Reset         string `json:"reset,omitempty"`          // Salted hash of the code resetting the password
+	ResetExpiry   int64  `json:"reset_expiry,omitempty"`   // When the reset code expires
+
+	Metadata map[string]string `json:"metadata,omitempty"` // Restored on every log in
+	Memos    []*Memo           `json:"memos,omitempty"`    // Messages left by other accounts
+}
+
+// Memo is a message left to an account, read once its owner logged in
+type Memo struct {
+	From   string `json:"from"` // Account which sent the memo
+	Text   string `json:"text"`
+	SentAt int64  `json:"sent"`
+	Read   bool   `json:"read,omitempty"`
+}
+
+// Store keeps the accounts of this server, account names are case insensitive
+type Store interface {
+	Get(name string) (*Account, error)
+	GetByCertfp(fp string) (*Account, error)
+	GetByNick(nick string) (*Account, error)
+	Save(a *Account) error
+	Delete(name string) error
+
+	// Create saves a new account unless its name is a nick owned by another
+	// account, accounts which weren't verified in time don't own it anymore.
+	Create(a *Account) error
+
+	// Update runs f on an account and saves the changes unless f fails, no
+	// other change to the account happens meanwhile.
+	Update(name string, f func(a *Account) error) (*Account, error)
+}
+
+// Copy returns a deep copy of the account, the store only hands out copies
+// so accounts are only ever changed by saving them.
+func (a *Account) Copy() *Account {
+	c := *a
+
+	c.Certfps = append([]string(nil), a.Certfps...)
+	c.Nicks = append([]string(nil), a.Nicks...)
+
+	if a.Metadata != nil {
+		c.Metadata = make(map[string]string, len(a.Metadata))
+		for k, v := range a.Metadata {
+			c.Metadata[k] = v
+		}
+	}
+
+	c.Memos = nil
+	for _, m := range a.Memos {
+		memo := *m
+		c.Memos = append(c.Memos, &memo)
+	}
+
+	return &c
+}
+
+func (a *Account) SetPassword(password string) error {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return err
+	}
+
+	a.Password = string(hash)
+
+	return nil
+}
+
+func (a *Account) CheckPassword(password string) bool {
+	if a.Password == "" {
+		return false
+	}
+
+	err := bcrypt.CompareHashAndPassword([]byte(a.Password), []byte(password))
+
+	return err == nil
+}
+
+// SetVerificationCode keeps the account unverified until the code is given
+func (a *Account) SetVerificationCode(code string) error {
+	hash, err := bcrypt.GenerateFromPassword([]byte(code), bcrypt.DefaultCost)
+	if err != nil {
+		return err
+	}
+
+	a.Pending = string(hash)
+
+	return nil
+}
+
+// Verify checks the code sent to verify the account, the account is verified
+// once the right one is given.
+func (a *Account) Verify(code string) bool {
+	if a.Pending == "" || a.VerificationExpired() {
+		return false
+	}
+
+	err := bcrypt.CompareHashAndPassword([]byte(a.Pending), []byte(code))
+	if err != nil {
+		return false
+	}
+
+	a.Pending = ""
+	a.PendingExpiry = 0
+
+	return true
+}
+
+// VerificationExpired tells whether the account wasn't verified in time,
+// its name may be registered again.
+func (a *Account) VerificationExpired() bool {
+	return a.Pending != "" && expired(a.PendingExpiry)
+}
+
+// SetResetCode lets the password be reset with a code until it expires
+func (a *Account) SetResetCode(code string, expiry int64) error {
+	hash, err := bcrypt.GenerateFromPassword([]byte(code), bcrypt.DefaultCost)
+	if err != nil {
+		return err
+	}
+
+	a.Reset = string(hash)
+	a.ResetExpiry = expiry
+
+	return nil
+}
+
+// UseResetCode checks the code resetting the password, it may only be used
+// once.
+func (a *Account) UseResetCode(code string) bool {
+	if a.Reset == "" || expired(a.ResetExpiry) {
+		return false
+	}
+
+	err := bcrypt.CompareHashAndPassword([]byte(a.Reset), []byte(code))
+	if err != nil {
+		return false
+	}
+
+	a.Reset = ""
+	a.ResetExpiry = 0
+
+	return true
+}
+
+func expired(expiry int64) bool {
+	return expiry != 0 && time.Now().Unix() > expiry
+}
+
+// IsVerified tells whether an account may be logged in
+func (a *Account) IsVerified() bool {
+	return a.Pending == ""
+}
+
+// OwnsNick tells whether a nick is the name of the account or one of its
+// grouped nicks.
+func (a *Account) OwnsNick(nick string) bool {
+	if Key(a.Name) == Key(nick) {
+		return true
+	}
+
+	for _, grouped := range a.Nicks {
+		if Key(grouped) == Key(nick) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// GroupNick adds a nick to the ones owned by the account
+func (a *Account) GroupNick(nick string) {
+	if !a.OwnsNick(nick) {
+		a.Nicks = append(a.Nicks, nick)
+	}
+}
+
+// UngroupNick releases a grouped nick, the name of the account can't be
+func (a *Account) UngroupNick(nick string) bool {
+	for idx, grouped := range a.Nicks {
+		if Key(grouped) == Key(nick) {
+			a.Nicks = append(a.Nicks[:idx], a.Nicks[idx+1:]...)
+			return true
+		}
+	}
+
+	return false
+}
+
+func (a *Account) HasCertfp(fp string) bool {
+	for _, certfp := range a.Certfps {
+		if strings.EqualFold(certfp, fp) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Key returns the name accounts are indexed with
+func Key(name string) string {
+	return casemapping.Fold(name)
+}
+
+// AddMemo leaves a memo to the account, unless it already holds the most
+// memos it may.
+func (a *Account) AddMemo(m *Memo, max int) bool {
+	if max > 0 && len(a.Memos) >= max {
+		return false
+	}
+
+	a.Memos = append(a.Memos, m)
+
+	return true
+}
+
+// UnreadMemos counts the memos the account didn't read yet
+func (a *Account) UnreadMemos() int {
+	var n int
+
+	for _, m := range a.Memos {
+		if !m.Read {
+			n++
+		}
+	}
+
+	return n
+}
+
+// DeleteMemo removes a memo by its number, starting at 1
+func (a *Account) DeleteMemo(n int) bool {
+	if n < 1 || n > len(a.Memos) {
+		return false
+	}
+
+	a.Memos = append(a.Memos[:n-1], a.Memos[n:]...)
+
+	return true
+}
@@ -0,0 +1,127 @@
+package account
+
+import (
+	"testing"
+	"time"
+)
+
+func TestVerify(t *testing.T) {
+	a := &Account{Name: "RockLee"}
+
+	if !a.IsVerified() {
+		t.Fatal("accounts should be verified unless a code is pending")
+	}
+
+	if err := a.SetVerificationCode("ABCD1234"); err != nil {
+		t.Fatal(err)
+	}
+
+	if a.IsVerified() {
+		t.Fatal("account should wait for its code")
+	}
+
+	if a.Verify("abcd1234") {
+		t.Error("wrong code should be refused")
+	}
+
+	if !a.Verify("ABCD1234") || !a.IsVerified() {
+		t.Error("account should be verified with its code")
+	}
+
+	if a.Verify("ABCD1234") {
+		t.Error("code should only be used once")
+	}
+}
+
+func TestVerificationExpiry(t *testing.T) {
+	a := &Account{Name: "RockLee"}
+
+	if err := a.SetVerificationCode("ABCD1234"); err != nil {
+		t.Fatal(err)
+	}
+	a.PendingExpiry = time.Now().Add(-time.Minute).Unix()
+
+	if !a.VerificationExpired() {
+		t.Fatal("verification should be expired")
+	}
+
+	if a.Verify("ABCD1234") || a.IsVerified() {
+		t.Error("expired code should be refused")
+	}
+}
+
+func TestResetCode(t *testing.T) {
+	a := &Account{Name: "RockLee"}
+
+	if a.UseResetCode("") {
+		t.Fatal("no code should be accepted unless one was set")
+	}
+
+	if err := a.SetResetCode("WXYZ5678", time.Now().Add(time.Hour).Unix()); err != nil {
+		t.Fatal(err)
+	}
+
+	if a.UseResetCode("WXYZ0000") {
+		t.Error("wrong code should be refused")
+	}
+
+	if !a.UseResetCode("WXYZ5678") || a.UseResetCode("WXYZ5678") {
+		t.Error("code should be accepted only once")
+	}
+
+	if err := a.SetResetCode("WXYZ5678", time.Now().Add(-time.Minute).Unix()); err != nil {
+		t.Fatal(err)
+	}
+
+	if a.UseResetCode("WXYZ5678") {
+		t.Error("expired code should be refused")
+	}
+}
+
+func TestGroupNick(t *testing.T) {
+	a := &Account{Name: "RockLee"}
+
+	a.GroupNick("Lee")
+	a.GroupNick("lee")
+
+	if len(a.Nicks) != 1 {
+		t.Fatal("nicks should only be grouped once")
+	}
+
+	if !a.OwnsNick("rocklee") || !a.OwnsNick("LEE") || a.OwnsNick("Gai") {
+		t.Error("account should own its name and grouped nicks only")
+	}
+
+	if a.UngroupNick("RockLee") {
+		t.Error("name of the account can't be ungrouped")
+	}
+
+	if !a.UngroupNick("Lee") || a.OwnsNick("Lee") {
+		t.Error("grouped nick should be released")
+	}
+}
+
+func TestMemos(t *testing.T) {
+	a := &Account{Name: "RockLee"}
+
+	if !a.AddMemo(&Memo{From: "Gai", Text: "Train"}, 2) || !a.AddMemo(&Memo{From: "Neji", Text: "Spar"}, 2) {
+		t.Fatal("memos should be added until the quota is reached")
+	}
+
+	if a.AddMemo(&Memo{From: "Tenten", Text: "Lunch"}, 2) {
+		t.Error("memos over the quota should be refused")
+	}
+
+	a.Memos[0].Read = true
+	if a.UnreadMemos() != 1 {
+		t.Error("unread memos counted error")
+	}
+
+	if a.DeleteMemo(0) || a.DeleteMemo(3) {
+		t.Error("memos are numbered from 1")
+	}
+
+	if !a.DeleteMemo(1) || len(a.Memos) != 1 || a.Memos[0].From != "Neji" {
+		t.Error("memo should be deleted")
+	}
+}
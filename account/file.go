@@ -0,0 +1,182 @@
+/*
+ * Copyright 2014 The starfruit Authors. All rights reserved.
+ * Use of this source code is governed by a BSD-style
+ * license that can be found in the LICENSE file.
+ */
+
+package account
+
+import (
+	"github.com/flatpeach/starfruit/jsonfile"
+	"sync"
+)
+
+// FileStore keeps all accounts in memory and writes them to a JSON file on
+// every change, accounts are only kept in memory without a file name.
+type FileStore struct {
+	file     string
+	accounts map[string]*Account
+	mutex    sync.Mutex
+}
+
+func NewFileStore(file string) (*FileStore, error) {
+	fs := &FileStore{
+		file:     file,
+		accounts: make(map[string]*Account),
+	}
+
+	var accounts []*Account
+
+	err := jsonfile.Load(file, &accounts)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, a := range accounts {
+		fs.accounts[Key(a.Name)] = a
+	}
+
+	return fs, nil
+}
+
+func (fs *FileStore) Get(name string) (*Account, error) {
+	fs.mutex.Lock()
+	defer fs.mutex.Unlock()
+
+	a, exists := fs.accounts[Key(name)]
+	if !exists {
+		return nil, ErrNotFound
+	}
+
+	return a.Copy(), nil
+}
+
+func (fs *FileStore) GetByCertfp(fp string) (*Account, error) {
+	fs.mutex.Lock()
+	defer fs.mutex.Unlock()
+
+	for _, a := range fs.accounts {
+		if a.HasCertfp(fp) {
+			return a.Copy(), nil
+		}
+	}
+
+	return nil, ErrNotFound
+}
+
+// GetByNick returns the account owning a nick, by name or grouped nick
+func (fs *FileStore) GetByNick(nick string) (*Account, error) {
+	fs.mutex.Lock()
+	defer fs.mutex.Unlock()
+
+	a := fs.byNick(nick)
+	if a == nil {
+		return nil, ErrNotFound
+	}
+
+	return a.Copy(), nil
+}
+
+func (fs *FileStore) byNick(nick string) *Account {
+	if a, exists := fs.accounts[Key(nick)]; exists {
+		return a
+	}
+
+	for _, a := range fs.accounts {
+		if a.OwnsNick(nick) {
+			return a
+		}
+	}
+
+	return nil
+}
+
+func (fs *FileStore) Save(a *Account) error {
+	fs.mutex.Lock()
+	defer fs.mutex.Unlock()
+
+	return fs.save(a.Copy())
+}
+
+func (fs *FileStore) Create(a *Account) error {
+	fs.mutex.Lock()
+	defer fs.mutex.Unlock()
+
+	if owner := fs.byNick(a.Name); owner != nil && !owner.VerificationExpired() {
+		return ErrExists
+	}
+
+	return fs.save(a.Copy())
+}
+
+func (fs *FileStore) Update(name string, f func(a *Account) error) (*Account, error) {
+	fs.mutex.Lock()
+	defer fs.mutex.Unlock()
+
+	a, exists := fs.accounts[Key(name)]
+	if !exists {
+		return nil, ErrNotFound
+	}
+
+	a = a.Copy()
+
+	err := f(a)
+	if err != nil {
+		return nil, err
+	}
+
+	err = fs.save(a)
+	if err != nil {
+		return nil, err
+	}
+
+	return a.Copy(), nil
+}
+
+func (fs *FileStore) Delete(name string) error {
+	fs.mutex.Lock()
+	defer fs.mutex.Unlock()
+
+	a, exists := fs.accounts[Key(name)]
+	if !exists {
+		return ErrNotFound
+	}
+
+	delete(fs.accounts, Key(name))
+
+	err := fs.flush()
+	if err != nil {
+		fs.accounts[Key(name)] = a
+	}
+
+	return err
+}
+
+// save puts an account in the store, which is left as it was if the file
+// can't be written.
+func (fs *FileStore) save(a *Account) error {
+	key := Key(a.Name)
+	old, existed := fs.accounts[key]
+
+	fs.accounts[key] = a
+
+	err := fs.flush()
+	if err != nil {
+		if existed {
+			fs.accounts[key] = old
+		} else {
+			delete(fs.accounts, key)
+		}
+	}
+
+	return err
+}
+
+func (fs *FileStore) flush() error {
+	var accounts []*Account
+	for _, a := range fs.accounts {
+		accounts = append(accounts, a)
+	}
+
+	return jsonfile.Save(fs.file, accounts)
+}
@@ -0,0 +1,99 @@
+package account
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+)
+
+func TestFileStore(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "accounts.json")
+
+	fs, err := NewFileStore(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	a := &Account{Name: "RockLee", Certfps: []string{"ABCDEF"}}
+	if err := a.SetPassword("secret"); err != nil {
+		t.Fatal(err)
+	}
+
+	if a.Password == "secret" {
+		t.Error("password should never be stored in plain text")
+	}
+
+	if err := fs.Save(a); err != nil {
+		t.Fatal(err)
+	}
+
+	fs, err = NewFileStore(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	a, err = fs.Get("rocklee")
+	if err != nil {
+		t.Fatal("account names should be case insensitive")
+	}
+
+	if !a.CheckPassword("secret") || a.CheckPassword("Secret") {
+		t.Error("password checked error")
+	}
+
+	if _, err := fs.GetByCertfp("abcdef"); err != nil {
+		t.Error("account should be found by its certificate fingerprint")
+	}
+
+	a.GroupNick("Lee")
+	if err := fs.Save(a); err != nil {
+		t.Fatal(err)
+	}
+
+	if b, err := fs.GetByNick("lee"); err != nil || b.Name != "RockLee" {
+		t.Error("account should be found by its grouped nicks")
+	}
+
+	b, _ := fs.Get("RockLee")
+	b.Nicks = nil
+	if a, _ := fs.Get("RockLee"); len(a.Nicks) != 1 {
+		t.Error("accounts should only be changed by saving them")
+	}
+
+	fail := errors.New("fail")
+	_, err = fs.Update("RockLee", func(a *Account) error {
+		a.UngroupNick("Lee")
+		return fail
+	})
+	if a, _ := fs.Get("RockLee"); err != fail || len(a.Nicks) != 1 {
+		t.Error("failed update should leave the account as it was")
+	}
+
+	a, err = fs.Update("RockLee", func(a *Account) error {
+		a.AddMemo(&Memo{From: "Gai", Text: "Youth"}, 0)
+		return nil
+	})
+	if err != nil || len(a.Memos) != 1 {
+		t.Error("update should return the account changed")
+	}
+
+	if err := fs.Create(&Account{Name: "LEE"}); err != ErrExists {
+		t.Error("nicks owned by an account should not be registered again")
+	}
+
+	if err := fs.Create(&Account{Name: "Neji", Pending: "code", PendingExpiry: 1}); err != nil {
+		t.Error("failed to create the account")
+	}
+
+	if err := fs.Create(&Account{Name: "neji"}); err != nil {
+		t.Error("accounts not verified in time should be registered again")
+	}
+
+	if err := fs.Delete("RockLee"); err != nil {
+		t.Error("failed to delete the account")
+	}
+
+	if _, err := fs.Get("RockLee"); err != ErrNotFound {
+		t.Error("account should be deleted")
+	}
+}
@@ -0,0 +1,30 @@
+/*
+ * Copyright 2014 The starfruit Authors. All rights reserved.
+ * Use of this source code is governed by a BSD-style
+ * license that can be found in the LICENSE file.
+ */
+
+package auth
+
+import (
+	"github.com/flatpeach/starfruit/account"
+)
+
+// Accounts checks passwords against the accounts registered on this server,
+// accounts not verified yet are refused.
+type Accounts struct {
+	Store account.Store
+}
+
+func (as *Accounts) Authenticate(user string, password string) (string, error) {
+	a, err := as.Store.Get(user)
+	if err != nil {
+		return "", ErrInvalidCredentials
+	}
+
+	if !a.CheckPassword(password) || !a.IsVerified() {
+		return "", ErrInvalidCredentials
+	}
+
+	return a.Name, nil
+}
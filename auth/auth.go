@@ -0,0 +1,28 @@
+/*
+ * Copyright 2014 The starfruit Authors. All rights reserved.
+ * Use of this source code is governed by a BSD-style
+ * license that can be found in the LICENSE file.
+ */
+
+package auth
+
+import (
+	"errors"
+)
+
+var ErrInvalidCredentials = errors.New("Invalid credentials")
+
+// Names of the backends, as given in the configuration
+const (
+	BackendNone     = "none"
+	BackendStatic   = "static"
+	BackendAccounts = "accounts"
+	BackendHtpasswd = "htpasswd"
+	BackendExec     = "exec"
+)
+
+// Authenticator checks the credentials of a user, and returns the name of
+// the account they belong to, empty if they don't name any.
+type Authenticator interface {
+	Authenticate(user string, password string) (string, error)
+}
@@ -0,0 +1,62 @@
+/*
+ * Copyright 2014 The starfruit Authors. All rights reserved.
+ * Use of this source code is governed by a BSD-style
+ * license that can be found in the LICENSE file.
+ */
+
+package auth
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// Exec runs a command which is given the user and the password on their own
+// lines of its standard input. The credentials are valid if it exits with 0,
+// the first line it prints names the account, the user name if none.
+type Exec struct {
+	Args    []string // Command and its arguments
+	Timeout time.Duration
+}
+
+func NewExec(command string, timeout time.Duration) *Exec {
+	return &Exec{
+		Args:    strings.Fields(command),
+		Timeout: timeout,
+	}
+}
+
+func (ex *Exec) Authenticate(user string, password string) (string, error) {
+	if len(ex.Args) == 0 || strings.ContainsAny(user+password, "\r\n") {
+		return "", ErrInvalidCredentials
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), ex.Timeout)
+	defer cancel()
+
+	var stdout bytes.Buffer
+
+	cmd := exec.CommandContext(ctx, ex.Args[0], ex.Args[1:]...)
+	cmd.Stdin = strings.NewReader(user + "\n" + password + "\n")
+	cmd.Stdout = &stdout
+
+	err := cmd.Run()
+	if err != nil {
+		return "", ErrInvalidCredentials
+	}
+
+	name := user
+
+	scanner := bufio.NewScanner(&stdout)
+	if scanner.Scan() {
+		if line := strings.TrimSpace(scanner.Text()); line != "" {
+			name = line
+		}
+	}
+
+	return name, nil
+}
@@ -0,0 +1,53 @@
+package auth
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestExec(t *testing.T) {
+	dir, err := ioutil.TempDir("", "starfruit")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	script := filepath.Join(dir, "check")
+	data := `#!/bin/sh
+read user
+read password
+[ "$password" = secret ] || exit 1
+[ "$user" = lee ] && echo RockLee
+exit 0
+`
+
+	if err := ioutil.WriteFile(script, []byte(data), 0700); err != nil {
+		t.Fatal(err)
+	}
+
+	ex := NewExec(script, time.Second)
+
+	if name, err := ex.Authenticate("lee", "secret"); err != nil || name != "RockLee" {
+		t.Error("account printed by the command should be returned")
+	}
+
+	if name, err := ex.Authenticate("neji", "secret"); err != nil || name != "neji" {
+		t.Error("user should be the account when nothing is printed")
+	}
+
+	if _, err := ex.Authenticate("lee", "wrong"); err == nil {
+		t.Error("failure of the command should refuse the credentials")
+	}
+
+	if _, err := ex.Authenticate("lee\nneji", "secret"); err == nil {
+		t.Error("credentials spanning lines should be refused")
+	}
+
+	slow := NewExec("sleep 5", 50*time.Millisecond)
+	if _, err := slow.Authenticate("lee", "secret"); err == nil {
+		t.Error("command running too long should be killed")
+	}
+}
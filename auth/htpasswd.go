@@ -0,0 +1,61 @@
+/*
+ * Copyright 2014 The starfruit Authors. All rights reserved.
+ * Use of this source code is governed by a BSD-style
+ * license that can be found in the LICENSE file.
+ */
+
+package auth
+
+import (
+	"bufio"
+	"golang.org/x/crypto/bcrypt"
+	"os"
+	"strings"
+)
+
+// Htpasswd checks passwords against a file of "user:hash" lines as written
+// by htpasswd -B, only bcrypt hashes are supported.
+type Htpasswd struct {
+	hashes map[string]string
+}
+
+func NewHtpasswd(file string) (*Htpasswd, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	ht := &Htpasswd{hashes: make(map[string]string)}
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		fields := strings.SplitN(line, ":", 2)
+		if len(fields) != 2 {
+			continue
+		}
+
+		ht.hashes[fields[0]] = fields[1]
+	}
+
+	return ht, scanner.Err()
+}
+
+func (ht *Htpasswd) Authenticate(user string, password string) (string, error) {
+	hash, exists := ht.hashes[user]
+	if !exists {
+		return "", ErrInvalidCredentials
+	}
+
+	err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(password))
+	if err != nil {
+		return "", ErrInvalidCredentials
+	}
+
+	return user, nil
+}
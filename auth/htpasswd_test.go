@@ -0,0 +1,54 @@
+package auth
+
+import (
+	"golang.org/x/crypto/bcrypt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestHtpasswd(t *testing.T) {
+	dir, err := ioutil.TempDir("", "starfruit")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	hash, err := bcrypt.GenerateFromPassword([]byte("secret"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	file := filepath.Join(dir, "htpasswd")
+	data := "# users\nrocklee:" + string(hash) + "\nneji:{SHA}W6ph5Mm5Pz8GgiULbPgzG37mj9g=\n"
+
+	if err := ioutil.WriteFile(file, []byte(data), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	ht, err := NewHtpasswd(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if name, err := ht.Authenticate("rocklee", "secret"); err != nil || name != "rocklee" {
+		t.Error("right password should be accepted")
+	}
+
+	if _, err := ht.Authenticate("rocklee", "wrong"); err == nil {
+		t.Error("wrong password should be refused")
+	}
+
+	if _, err := ht.Authenticate("neji", "password"); err == nil {
+		t.Error("hashes other than bcrypt should be refused")
+	}
+
+	if _, err := ht.Authenticate("gai", "secret"); err == nil {
+		t.Error("unknown user should be refused")
+	}
+
+	if _, err := NewHtpasswd(filepath.Join(dir, "missing")); err == nil {
+		t.Error("missing file should be an error")
+	}
+}
@@ -0,0 +1,197 @@
+/*
+ * Copyright 2014 The starfruit Authors. All rights reserved.
+ * Use of this source code is governed by a BSD-style
+ * license that can be found in the LICENSE file.
+ */
+
+package auth
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	_ "crypto/sha256"
+	_ "crypto/sha512"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"strings"
+	"time"
+)
+
+var ErrInvalidToken = errors.New("Invalid token")
+
+// Clocks of the issuer and the server may disagree this much
+const jwtLeeway = 30 * time.Second
+
+// Signature algorithms accepted, "none" and shared secrets never are
+var jwtAlgorithms = map[string]crypto.Hash{
+	"RS256": crypto.SHA256,
+	"RS384": crypto.SHA384,
+	"RS512": crypto.SHA512,
+	"PS256": crypto.SHA256,
+	"PS384": crypto.SHA384,
+	"PS512": crypto.SHA512,
+	"ES256": crypto.SHA256,
+	"ES384": crypto.SHA384,
+	"ES512": crypto.SHA512,
+}
+
+// JWT validates the JSON Web Tokens of a single-sign-on, signed by one of the
+// keys of a local JWKS file, and finds the account in one of their claims.
+type JWT struct {
+	Issuer   string // Required "iss", anybody if empty
+	Audience string // Required "aud", anybody if empty
+	Claim    string // Claim holding the name of the account
+
+	keys map[string]*jwksKey
+	now  func() time.Time
+}
+
+func NewJWT(jwksFile string, issuer string, audience string, claim string) (*JWT, error) {
+	keys, err := loadJwks(jwksFile)
+	if err != nil {
+		return nil, err
+	}
+
+	if claim == "" {
+		claim = "sub"
+	}
+
+	return &JWT{
+		Issuer:   issuer,
+		Audience: audience,
+		Claim:    claim,
+		keys:     keys,
+		now:      time.Now,
+	}, nil
+}
+
+// Validate checks the signature and the claims of a token, and returns the
+// name of the account it was issued for.
+func (j *JWT) Validate(token string) (string, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return "", ErrInvalidToken
+	}
+
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+
+	if !decodeSegment(parts[0], &header) {
+		return "", ErrInvalidToken
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return "", ErrInvalidToken
+	}
+
+	if !j.verify(header.Alg, header.Kid, parts[0]+"."+parts[1], signature) {
+		return "", ErrInvalidToken
+	}
+
+	var claims map[string]interface{}
+
+	if !decodeSegment(parts[1], &claims) || !j.checkClaims(claims) {
+		return "", ErrInvalidToken
+	}
+
+	name, ok := claims[j.Claim].(string)
+	if !ok || name == "" {
+		return "", ErrInvalidToken
+	}
+
+	return name, nil
+}
+
+func (j *JWT) verify(alg string, kid string, signed string, signature []byte) bool {
+	hash, supported := jwtAlgorithms[alg]
+	if !supported {
+		return false
+	}
+
+	key, exists := j.keys[kid]
+	if !exists && kid == "" && len(j.keys) == 1 {
+		// Tokens may omit the key id when there is only one key
+		for _, k := range j.keys {
+			key, exists = k, true
+		}
+	}
+
+	if !exists || (key.Alg != "" && key.Alg != alg) {
+		return false
+	}
+
+	h := hash.New()
+	h.Write([]byte(signed))
+	digest := h.Sum(nil)
+
+	switch pub := key.Key.(type) {
+	case *rsa.PublicKey:
+		if alg[0] == 'P' {
+			return rsa.VerifyPSS(pub, hash, digest, signature, nil) == nil
+		}
+
+		return alg[0] == 'R' && rsa.VerifyPKCS1v15(pub, hash, digest, signature) == nil
+
+	case *ecdsa.PublicKey:
+		size := (pub.Curve.Params().BitSize + 7) / 8
+		if alg[0] != 'E' || len(signature) != 2*size {
+			return false
+		}
+
+		r := new(big.Int).SetBytes(signature[:size])
+		s := new(big.Int).SetBytes(signature[size:])
+
+		return ecdsa.Verify(pub, digest, r, s)
+	}
+
+	return false
+}
+
+func (j *JWT) checkClaims(claims map[string]interface{}) bool {
+	now := j.now()
+
+	exp, ok := claims["exp"].(float64)
+	if !ok || now.After(time.Unix(int64(exp), 0).Add(jwtLeeway)) {
+		return false
+	}
+
+	if nbf, ok := claims["nbf"].(float64); ok && now.Add(jwtLeeway).Before(time.Unix(int64(nbf), 0)) {
+		return false
+	}
+
+	if j.Issuer != "" && claims["iss"] != j.Issuer {
+		return false
+	}
+
+	if j.Audience != "" {
+		switch aud := claims["aud"].(type) {
+		case string:
+			return aud == j.Audience
+		case []interface{}:
+			for _, a := range aud {
+				if a == j.Audience {
+					return true
+				}
+			}
+		}
+
+		return false
+	}
+
+	return true
+}
+
+func decodeSegment(segment string, v interface{}) bool {
+	data, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return false
+	}
+
+	return json.Unmarshal(data, v) == nil
+}
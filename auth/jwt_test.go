@@ -0,0 +1,171 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func b64(data []byte) string {
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func signToken(t *testing.T, key crypto.Signer, alg string, kid string, claims map[string]interface{}) string {
+	header, _ := json.Marshal(map[string]string{"alg": alg, "kid": kid, "typ": "JWT"})
+	payload, _ := json.Marshal(claims)
+
+	signed := b64(header) + "." + b64(payload)
+
+	h := crypto.SHA256.New()
+	h.Write([]byte(signed))
+	digest := h.Sum(nil)
+
+	var signature []byte
+
+	switch k := key.(type) {
+	case *rsa.PrivateKey:
+		sig, err := rsa.SignPKCS1v15(rand.Reader, k, crypto.SHA256, digest)
+		if err != nil {
+			t.Fatal(err)
+		}
+		signature = sig
+
+	case *ecdsa.PrivateKey:
+		r, s, err := ecdsa.Sign(rand.Reader, k, digest)
+		if err != nil {
+			t.Fatal(err)
+		}
+		signature = append(r.FillBytes(make([]byte, 32)), s.FillBytes(make([]byte, 32))...)
+	}
+
+	return signed + "." + b64(signature)
+}
+
+func TestJWT(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	otherKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir, err := ioutil.TempDir("", "starfruit")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	jwks, _ := json.Marshal(map[string]interface{}{
+		"keys": []map[string]string{
+			{
+				"kty": "RSA",
+				"kid": "rsa",
+				"alg": "RS256",
+				"n":   b64(rsaKey.N.Bytes()),
+				"e":   b64(big.NewInt(int64(rsaKey.E)).Bytes()),
+			},
+			{
+				"kty": "EC",
+				"kid": "ec",
+				"crv": "P-256",
+				"x":   b64(ecKey.X.Bytes()),
+				"y":   b64(ecKey.Y.Bytes()),
+			},
+			{
+				"kty": "oct",
+				"kid": "secret",
+				"k":   "c2VjcmV0",
+			},
+		},
+	})
+
+	file := filepath.Join(dir, "jwks.json")
+	if err := ioutil.WriteFile(file, jwks, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	j, err := NewJWT(file, "https://sso.konoha.org", "irc", "preferred_username")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Unix(1400000000, 0)
+	j.now = func() time.Time { return now }
+
+	claims := func(changes map[string]interface{}) map[string]interface{} {
+		c := map[string]interface{}{
+			"iss":                "https://sso.konoha.org",
+			"aud":                []string{"wiki", "irc"},
+			"exp":                now.Add(time.Hour).Unix(),
+			"preferred_username": "RockLee",
+		}
+		for k, v := range changes {
+			if v == nil {
+				delete(c, k)
+			} else {
+				c[k] = v
+			}
+		}
+		return c
+	}
+
+	for _, key := range []struct {
+		signer crypto.Signer
+		alg    string
+		kid    string
+	}{
+		{rsaKey, "RS256", "rsa"},
+		{ecKey, "ES256", "ec"},
+	} {
+		name, err := j.Validate(signToken(t, key.signer, key.alg, key.kid, claims(nil)))
+		if err != nil || name != "RockLee" {
+			t.Errorf("token signed with %s should be valid, got %q %v", key.kid, name, err)
+		}
+	}
+
+	invalid := map[string]string{
+		"unknown key":     signToken(t, otherKey, "RS256", "rsa", claims(nil)),
+		"wrong algorithm": signToken(t, rsaKey, "PS256", "rsa", claims(nil)),
+		"shared secret":   signToken(t, rsaKey, "HS256", "secret", claims(nil)),
+		"expired":         signToken(t, rsaKey, "RS256", "rsa", claims(map[string]interface{}{"exp": now.Add(-time.Hour).Unix()})),
+		"no expiry":       signToken(t, rsaKey, "RS256", "rsa", claims(map[string]interface{}{"exp": nil})),
+		"not yet valid":   signToken(t, rsaKey, "RS256", "rsa", claims(map[string]interface{}{"nbf": now.Add(time.Hour).Unix()})),
+		"wrong issuer":    signToken(t, rsaKey, "RS256", "rsa", claims(map[string]interface{}{"iss": "https://evil.org"})),
+		"wrong audience":  signToken(t, rsaKey, "RS256", "rsa", claims(map[string]interface{}{"aud": "wiki"})),
+		"no account":      signToken(t, rsaKey, "RS256", "rsa", claims(map[string]interface{}{"preferred_username": nil})),
+		"malformed":       "not.a-token",
+	}
+
+	for reason, token := range invalid {
+		if _, err := j.Validate(token); err != ErrInvalidToken {
+			t.Errorf("token with %s should be refused", reason)
+		}
+	}
+
+	// The payload can't be changed without the signature
+	token := signToken(t, rsaKey, "RS256", "rsa", claims(nil))
+	payload, _ := json.Marshal(claims(map[string]interface{}{"preferred_username": "Gai"}))
+	parts := strings.Split(token, ".")
+	if _, err := j.Validate(parts[0] + "." + b64(payload) + "." + parts[2]); err != ErrInvalidToken {
+		t.Error("forged payload should be refused")
+	}
+}
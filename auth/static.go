@@ -0,0 +1,25 @@
+/*
+ * Copyright 2014 The starfruit Authors. All rights reserved.
+ * Use of this source code is governed by a BSD-style
+ * license that can be found in the LICENSE file.
+ */
+
+package auth
+
+import (
+	"crypto/subtle"
+)
+
+// Static accepts a single password whatever the user is, so it never tells
+// which account they belong to.
+type Static struct {
+	Password string
+}
+
+func (st *Static) Authenticate(user string, password string) (string, error) {
+	if subtle.ConstantTimeCompare([]byte(password), []byte(st.Password)) != 1 {
+		return "", ErrInvalidCredentials
+	}
+
+	return "", nil
+}
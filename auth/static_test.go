@@ -0,0 +1,21 @@
+package auth
+
+import (
+	"testing"
+)
+
+func TestStatic(t *testing.T) {
+	st := &Static{Password: "secret"}
+
+	if name, err := st.Authenticate("", "secret"); err != nil || name != "" {
+		t.Error("right password should be accepted")
+	}
+
+	if name, _ := st.Authenticate("RockLee", "secret"); name != "" {
+		t.Error("no account should be named")
+	}
+
+	if _, err := st.Authenticate("", "Secret"); err != ErrInvalidCredentials {
+		t.Error("wrong password should be refused")
+	}
+}
@@ -0,0 +1,177 @@
+/*
+ * Copyright 2014 The starfruit Authors. All rights reserved.
+ * Use of this source code is governed by a BSD-style
+ * license that can be found in the LICENSE file.
+ */
+
+package ban
+
+import (
+	"errors"
+	"github.com/flatpeach/starfruit/mask"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	ErrNotFound    = errors.New("Ban not found")
+	ErrInvalidMask = errors.New("Invalid ban mask")
+)
+
+// Kinds of server bans
+const (
+	KindK = "K" // user@host mask
+	KindD = "D" // IP address or CIDR, checked as soon as the connection is accepted
+	KindG = "G" // Realname pattern
+)
+
+// Shortest network prefixes D-lines may ban
+const (
+	MinPrefixIPv4 = 8
+	MinPrefixIPv6 = 32
+)
+
+// Ban keeps users off the server, until it expires if it does
+type Ban struct {
+	Kind    string `json:"kind"`
+	Mask    string `json:"mask"`
+	Reason  string `json:"reason"`
+	SetBy   string `json:"set_by"`
+	SetAt   int64  `json:"set_at"`
+	Expires int64  `json:"expires,omitempty"` // Never if 0
+}
+
+// Store keeps the server bans, expired bans are never returned
+type Store interface {
+	All() []*Ban
+	Add(b *Ban) error
+	Remove(kind string, mask string) error
+}
+
+// NormalizeMask checks the mask of a ban and returns the form it is kept
+// in, hosts alone are completed into user@host masks.
+func NormalizeMask(kind string, m string) (string, error) {
+	switch kind {
+	case KindK:
+		if m == "" || strings.ContainsAny(m, "! ") {
+			return "", ErrInvalidMask
+		}
+
+		if !strings.Contains(m, "@") {
+			m = "*@" + m
+		}
+
+		return strings.ToLower(m), nil
+
+	case KindD:
+		if _, network, err := net.ParseCIDR(m); err == nil {
+			return network.String(), nil
+		}
+
+		if ip := net.ParseIP(m); ip != nil {
+			return ip.String(), nil
+		}
+
+		return "", ErrInvalidMask
+
+	case KindG:
+		if m == "" {
+			return "", ErrInvalidMask
+		}
+
+		// Realnames are matched case insensitively
+		return strings.ToLower(m), nil
+	}
+
+	return "", ErrInvalidMask
+}
+
+// TooBroad tells whether a normalized mask would ban about everybody, masks
+// made of wildcards only and the largest networks.
+func TooBroad(kind string, m string) bool {
+	if kind == KindD {
+		_, network, err := net.ParseCIDR(m)
+		if err != nil {
+			return false
+		}
+
+		ones, bits := network.Mask.Size()
+		if bits == 8*net.IPv4len {
+			return ones < MinPrefixIPv4
+		}
+
+		return ones < MinPrefixIPv6
+	}
+
+	return strings.Trim(m, "*?@. ") == ""
+}
+
+// Expired tells whether the ban no longer applies
+func (b *Ban) Expired(now time.Time) bool {
+	return b.Expires != 0 && now.Unix() >= b.Expires
+}
+
+// MatchesAddr tells whether a D-line bans an address
+func (b *Ban) MatchesAddr(ip net.IP) bool {
+	if b.Kind != KindD || ip == nil {
+		return false
+	}
+
+	if _, network, err := net.ParseCIDR(b.Mask); err == nil {
+		return network.Contains(ip)
+	}
+
+	return ip.Equal(net.ParseIP(b.Mask))
+}
+
+// MatchesUser tells whether a K-line or a G-line bans a user, K-lines are
+// matched against both the host and the address he connects from.
+func (b *Ban) MatchesUser(userName string, hostName string, ip string, realName string) bool {
+	switch b.Kind {
+	case KindK:
+		return mask.Match(b.Mask, userName+"@"+hostName) || mask.Match(b.Mask, userName+"@"+ip)
+
+	case KindG:
+		return mask.Match(b.Mask, realName)
+	}
+
+	return false
+}
+
+// ParseDuration reads the duration of a ban, a number of minutes or a number
+// followed by one of the units s, m, h, d and w.
+func ParseDuration(s string) (time.Duration, bool) {
+	if s == "" {
+		return 0, false
+	}
+
+	unit := time.Minute
+
+	if last := s[len(s)-1]; last < '0' || last > '9' {
+		switch last {
+		case 's':
+			unit = time.Second
+		case 'm':
+			unit = time.Minute
+		case 'h':
+			unit = time.Hour
+		case 'd':
+			unit = 24 * time.Hour
+		case 'w':
+			unit = 7 * 24 * time.Hour
+		default:
+			return 0, false
+		}
+
+		s = s[:len(s)-1]
+	}
+
+	n, err := strconv.Atoi(s)
+	if err != nil || n <= 0 {
+		return 0, false
+	}
+
+	return time.Duration(n) * unit, true
+}
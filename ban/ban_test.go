@@ -0,0 +1,140 @@
+package ban
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+func TestNormalizeMask(t *testing.T) {
+	valid := []struct {
+		kind, mask, expected string
+	}{
+		{KindK, "Orochimaru@Sound.org", "orochimaru@sound.org"},
+		{KindK, "*.sound.org", "*@*.sound.org"},
+		{KindD, "10.0.0.7", "10.0.0.7"},
+		{KindD, "10.1.2.3/16", "10.1.0.0/16"},
+		{KindD, "2001:db8::1/64", "2001:db8::/64"},
+		{KindG, "*White Snake*", "*white snake*"},
+	}
+
+	for _, v := range valid {
+		if m, err := NormalizeMask(v.kind, v.mask); err != nil || m != v.expected {
+			t.Errorf("%s-line %s should be normalized to %s, got %s %v", v.kind, v.mask, v.expected, m, err)
+		}
+	}
+
+	invalid := []struct {
+		kind, mask string
+	}{
+		{KindK, "nick!user@host"},
+		{KindK, ""},
+		{KindD, "sound.org"},
+		{KindD, "10.0.0.0/33"},
+		{KindG, ""},
+		{"Z", "*"},
+	}
+
+	for _, v := range invalid {
+		if _, err := NormalizeMask(v.kind, v.mask); err != ErrInvalidMask {
+			t.Errorf("%s-line %s should be refused", v.kind, v.mask)
+		}
+	}
+}
+
+func TestTooBroad(t *testing.T) {
+	broad := []struct {
+		kind, mask string
+	}{
+		{KindK, "*@*"},
+		{KindK, "*@*.*"},
+		{KindD, "0.0.0.0/0"},
+		{KindD, "10.0.0.0/7"},
+		{KindD, "2001::/16"},
+		{KindG, "*"},
+	}
+
+	for _, v := range broad {
+		if !TooBroad(v.kind, v.mask) {
+			t.Errorf("%s-line %s should be too broad", v.kind, v.mask)
+		}
+	}
+
+	narrow := []struct {
+		kind, mask string
+	}{
+		{KindK, "kabuto@*"},
+		{KindK, "*@*.sound.org"},
+		{KindD, "10.0.0.0/8"},
+		{KindD, "10.0.0.7"},
+		{KindD, "2001:db8::/32"},
+		{KindG, "*snake*"},
+	}
+
+	for _, v := range narrow {
+		if TooBroad(v.kind, v.mask) {
+			t.Errorf("%s-line %s should be allowed", v.kind, v.mask)
+		}
+	}
+}
+
+func TestMatches(t *testing.T) {
+	kline := &Ban{Kind: KindK, Mask: "*@*.sound.org"}
+	if !kline.MatchesUser("kabuto", "lab.sound.org", "10.0.0.7", "Kabuto") {
+		t.Error("K-line should match the host")
+	}
+
+	kline = &Ban{Kind: KindK, Mask: "kabuto@10.0.*"}
+	if !kline.MatchesUser("Kabuto", "lab.sound.org", "10.0.0.7", "Kabuto") || kline.MatchesUser("lee", "konoha.org", "10.0.0.8", "Lee") {
+		t.Error("K-line should match the user and the address")
+	}
+
+	gline := &Ban{Kind: KindG, Mask: "*snake*"}
+	if !gline.MatchesUser("oro", "sound.org", "10.0.0.9", "The White Snake") || gline.MatchesUser("lee", "konoha.org", "10.0.0.8", "Rock Lee") {
+		t.Error("G-line should match the realname")
+	}
+
+	dline := &Ban{Kind: KindD, Mask: "10.0.0.0/24"}
+	if !dline.MatchesAddr(net.ParseIP("10.0.0.7")) || dline.MatchesAddr(net.ParseIP("10.0.1.7")) {
+		t.Error("D-line should match the addresses of its network")
+	}
+
+	dline = &Ban{Kind: KindD, Mask: "10.0.0.7"}
+	if !dline.MatchesAddr(net.ParseIP("10.0.0.7")) || dline.MatchesUser("kabuto", "10.0.0.7", "10.0.0.7", "Kabuto") {
+		t.Error("D-line should only match its address")
+	}
+}
+
+func TestExpired(t *testing.T) {
+	now := time.Now()
+
+	if (&Ban{}).Expired(now) {
+		t.Error("bans without expiry should never expire")
+	}
+
+	if !(&Ban{Expires: now.Add(-time.Second).Unix()}).Expired(now) {
+		t.Error("ban should be expired")
+	}
+}
+
+func TestParseDuration(t *testing.T) {
+	valid := map[string]time.Duration{
+		"30":  30 * time.Minute,
+		"45s": 45 * time.Second,
+		"2h":  2 * time.Hour,
+		"1d":  24 * time.Hour,
+		"1w":  7 * 24 * time.Hour,
+	}
+
+	for s, expected := range valid {
+		if d, ok := ParseDuration(s); !ok || d != expected {
+			t.Errorf("%s should last %s, got %s", s, expected, d)
+		}
+	}
+
+	for _, s := range []string{"", "h", "0", "-5m", "*@host", "1y"} {
+		if _, ok := ParseDuration(s); ok {
+			t.Errorf("%s should not be a duration", s)
+		}
+	}
+}
@@ -0,0 +1,127 @@
+/*
+ * Copyright 2014 The starfruit Authors. All rights reserved.
+ * Use of this source code is governed by a BSD-style
+ * license that can be found in the LICENSE file.
+ */
+
+package ban
+
+import (
+	"github.com/flatpeach/starfruit/jsonfile"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// FileStore keeps all server bans in memory and writes them to a JSON file
+// on every change, bans are only kept in memory without a file name.
+type FileStore struct {
+	file  string
+	bans  []*Ban
+	mutex sync.Mutex
+}
+
+func NewFileStore(file string) (*FileStore, error) {
+	fs := &FileStore{file: file}
+
+	err := jsonfile.Load(file, &fs.bans)
+	if err != nil {
+		return nil, err
+	}
+
+	return fs, nil
+}
+
+// All returns the bans which didn't expire, sorted by kind then by time
+func (fs *FileStore) All() []*Ban {
+	fs.mutex.Lock()
+	defer fs.mutex.Unlock()
+
+	var bans []*Ban
+
+	now := time.Now()
+	for _, b := range fs.bans {
+		if !b.Expired(now) {
+			bans = append(bans, b)
+		}
+	}
+
+	sort.SliceStable(bans, func(i, j int) bool {
+		if bans[i].Kind != bans[j].Kind {
+			return bans[i].Kind < bans[j].Kind
+		}
+
+		return bans[i].SetAt < bans[j].SetAt
+	})
+
+	return bans
+}
+
+// Add keeps a ban, replacing the one of the same kind and mask if any
+func (fs *FileStore) Add(b *Ban) error {
+	fs.mutex.Lock()
+	defer fs.mutex.Unlock()
+
+	old := append([]*Ban(nil), fs.bans...)
+
+	if idx := fs.find(b.Kind, b.Mask); idx >= 0 {
+		fs.bans[idx] = b
+	} else {
+		fs.bans = append(fs.bans, b)
+	}
+
+	return fs.flushOr(old)
+}
+
+func (fs *FileStore) Remove(kind string, mask string) error {
+	fs.mutex.Lock()
+	defer fs.mutex.Unlock()
+
+	idx := fs.find(kind, mask)
+	if idx < 0 || fs.bans[idx].Expired(time.Now()) {
+		return ErrNotFound
+	}
+
+	old := append([]*Ban(nil), fs.bans...)
+
+	fs.bans = append(fs.bans[:idx], fs.bans[idx+1:]...)
+
+	return fs.flushOr(old)
+}
+
+func (fs *FileStore) find(kind string, mask string) int {
+	for idx, b := range fs.bans {
+		if b.Kind == kind && strings.EqualFold(b.Mask, mask) {
+			return idx
+		}
+	}
+
+	return -1
+}
+
+// flush writes the bans, the expired ones are dropped on the way
+func (fs *FileStore) flush() error {
+	now := time.Now()
+
+	var bans []*Ban
+	for _, b := range fs.bans {
+		if !b.Expired(now) {
+			bans = append(bans, b)
+		}
+	}
+	fs.bans = bans
+
+	return jsonfile.Save(fs.file, fs.bans)
+}
+
+// flushOr writes the bans, the ones given are restored if they can't be
+// written so what is enforced is always what is saved.
+func (fs *FileStore) flushOr(old []*Ban) error {
+	err := fs.flush()
+	if err != nil {
+		fs.bans = old
+	}
+
+	return err
+}
@@ -0,0 +1,66 @@
+package ban
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestFileStore(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "bans.json")
+
+	fs, err := NewFileStore(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Now()
+
+	bans := []*Ban{
+		{Kind: KindK, Mask: "*@*.sound.org", Reason: "Invasion", SetAt: now.Unix()},
+		{Kind: KindD, Mask: "10.0.0.0/24", Reason: "Spam", SetAt: now.Unix()},
+		{Kind: KindK, Mask: "kabuto@*", Reason: "Spy", SetAt: now.Unix() - 10},
+		{Kind: KindG, Mask: "*snake*", Reason: "Old", SetAt: now.Unix(), Expires: now.Unix() - 1},
+	}
+
+	for _, b := range bans {
+		if err := fs.Add(b); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := fs.Add(&Ban{Kind: KindK, Mask: "*@*.sound.org", Reason: "Invasion again", SetAt: now.Unix()}); err != nil {
+		t.Fatal(err)
+	}
+
+	fs, err = NewFileStore(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	all := fs.All()
+	if len(all) != 3 {
+		t.Fatalf("expired bans should be dropped and same masks replaced, got %d bans", len(all))
+	}
+
+	if all[0].Kind != KindD || all[1].Mask != "kabuto@*" || all[2].Reason != "Invasion again" {
+		t.Error("bans should be sorted by kind then by time")
+	}
+
+	if err := fs.Remove(KindG, "*snake*"); err != ErrNotFound {
+		t.Error("expired ban can't be removed")
+	}
+
+	if err := fs.Remove(KindK, "kabuto@*"); err != nil || len(fs.All()) != 2 {
+		t.Error("ban should be removed")
+	}
+
+	fs, err = NewFileStore(filepath.Join(filepath.Dir(file), "missing", "bans.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := fs.Add(bans[0]); err == nil || len(fs.All()) != 0 {
+		t.Error("ban which can't be saved should not be kept")
+	}
+}
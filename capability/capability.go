@@ -0,0 +1,136 @@
+/*
+ * Copyright 2014 The starfruit Authors. All rights reserved.
+ * Use of this source code is governed by a BSD-style
+ * license that can be found in the LICENSE file.
+ */
+
+package capability
+
+import (
+	"sort"
+	"sync"
+)
+
+// IRCv3 capabilities supported by this server
+const (
+	CapNotify       = "cap-notify"
+	MessageTags     = "message-tags"
+	Sasl            = "sasl"
+	ServerTime      = "server-time"
+	EchoMessage     = "echo-message"
+	AwayNotify      = "away-notify"
+	AccountNotify   = "account-notify"
+	ExtendedJoin    = "extended-join"
+	ChgHost         = "chghost"
+	MultiPrefix     = "multi-prefix"
+	UserhostInNames = "userhost-in-names"
+	Batch           = "batch"
+	LabeledResponse = "labeled-response"
+	ChatHistory     = "draft/chathistory"
+	EventPlayback   = "draft/event-playback"
+	Redaction       = "draft/message-redaction"
+	Metadata        = "draft/metadata-2"
+	Multiline       = "draft/multiline"
+	Registration    = "draft/account-registration"
+)
+
+// Tags which are sent to clients without message-tags as long as they
+// negotiated the capability introducing the tag.
+var tagCapabilities = map[string]string{
+	"time":  ServerTime,
+	"batch": Batch,
+	"label": LabeledResponse,
+}
+
+// TagAllowed tells whether a tag may be sent to a client, given a way to
+// know which capabilities the client negotiated.
+func TagAllowed(tag string, hasCap func(name string) bool) bool {
+	if hasCap(MessageTags) {
+		return true
+	}
+
+	name, exists := tagCapabilities[tag]
+
+	return exists && hasCap(name)
+}
+
+// Registry keeps all the capabilities this server offers through CAP LS,
+// each capability may carry a value shown to clients speaking CAP 302.
+type Registry struct {
+	caps  map[string]string
+	mutex sync.Mutex
+}
+
+func New() *Registry {
+	r := &Registry{
+		caps: make(map[string]string),
+	}
+
+	return r
+}
+
+func (r *Registry) Register(name string, value string) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	r.caps[name] = value
+}
+
+func (r *Registry) Unregister(name string) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	delete(r.caps, name)
+}
+
+func (r *Registry) Exists(name string) bool {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	_, exists := r.caps[name]
+	return exists
+}
+
+func (r *Registry) Value(name string) (string, bool) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	v, exists := r.caps[name]
+	return v, exists
+}
+
+// Names returns the name of all capabilities, sorted
+func (r *Registry) Names() []string {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	var names []string
+	for name := range r.caps {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// Tokens returns the capabilities in the CAP LS format, values are only
+// included since CAP version 302.
+func (r *Registry) Tokens(version int) []string {
+	var tokens []string
+
+	for _, name := range r.Names() {
+		tokens = append(tokens, r.Token(name, version))
+	}
+
+	return tokens
+}
+
+// Token formats a single capability in the CAP LS / CAP NEW format
+func (r *Registry) Token(name string, version int) string {
+	value, _ := r.Value(name)
+	if version >= 302 && value != "" {
+		return name + "=" + value
+	}
+
+	return name
+}
@@ -0,0 +1,45 @@
+/*
+ * Copyright 2014 The starfruit Authors. All rights reserved.
+ * Use of this source code is governed by a BSD-style
+ * license that can be found in the LICENSE file.
+ */
+
+// Package casemapping compares nicks, channel and account names the way
+// CASEMAPPING advertises it, only the ASCII letters A to Z are folded.
+package casemapping
+
+// Name is the value of the CASEMAPPING token
+const Name = "ascii"
+
+// Fold returns the form names are compared in
+func Fold(name string) string {
+	b := []byte(name)
+	for i, c := range b {
+		b[i] = fold(c)
+	}
+
+	return string(b)
+}
+
+// Equal tells whether two names are the same whatever their case
+func Equal(a string, b string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+
+	for i := 0; i < len(a); i++ {
+		if fold(a[i]) != fold(b[i]) {
+			return false
+		}
+	}
+
+	return true
+}
+
+func fold(c byte) byte {
+	if 'A' <= c && c <= 'Z' {
+		return c + 'a' - 'A'
+	}
+
+	return c
+}
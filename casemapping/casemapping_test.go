@@ -0,0 +1,19 @@
+package casemapping
+
+import (
+	"testing"
+)
+
+func TestFold(t *testing.T) {
+	if Fold("RockLee[]") != "rocklee[]" {
+		t.Error("ASCII letters should be folded")
+	}
+
+	if Fold("ÉCOLE") != "École" {
+		t.Error("only ASCII letters should be folded")
+	}
+
+	if !Equal("#Konoha", "#kONOHA") || Equal("Éa", "éa") || Equal("a", "ab") {
+		t.Error("names compared error")
+	}
+}
@@ -8,9 +8,10 @@ package channel
 
 import (
 	"errors"
-	"fmt"
 	"github.com/flatpeach/starfruit/message"
+	"github.com/flatpeach/starfruit/metadata"
 	"github.com/flatpeach/starfruit/user"
+	"strings"
 	"sync"
 	"time"
 )
@@ -23,6 +24,10 @@ const (
 	NS_NETWORK_UNMODERATED_RAW = "+"
 	NS_SOFTCHAN_RAW            = "."
 	NS_GLOBAL_RAW              = "~"
+
+	// All the namespaces, as advertised with CHANTYPES
+	NS_ALL_RAW = NS_LOCAL_RAW + NS_NETWORK_RAW + NS_NETWORK_SAFE_RAW +
+		NS_NETWORK_UNMODERATED_RAW + NS_SOFTCHAN_RAW + NS_GLOBAL_RAW
 )
 
 const (
@@ -51,16 +56,33 @@ const (
 	MODE_BAN        = 0x2000
 	MODE_EXCEPTION  = 0x4000
 	MODE_INVITATION = 0x8000
+	MODE_TOPIC      = 0x10000
 )
 
+// Privileges members may hold, the highest one first
+var privilegeModes = []struct {
+	mode   int
+	char   byte
+	prefix string
+}{
+	{MODE_OPERATOR, 'o', "@"},
+	{MODE_VOICE, 'v', "+"},
+}
+
 type Channel struct {
 	Id        int
 	Namespace int
 	Name      string
 	Modes     int
+	Metadata  *metadata.Map // Key/value pairs shown with METADATA
 
 	topic        string
+	key          string
+	limit        int
 	users        []*user.User
+	privileges   map[int]int // Privileges of each member, by user id
+	invited      map[int]bool
+	bans         []string // Ban masks, nick!user@host
 	topicSetBy   string
 	topicSettime int64
 	mutex        sync.Mutex
@@ -76,8 +98,16 @@ func New(s string) (*Channel, error) {
 		return nil, errors.New("Channel name too long or too short")
 	}
 
+	// Space, comma and control G are never allowed in channel names
+	if strings.ContainsAny(s, " ,\x07") {
+		return nil, errors.New("Channel name malformed")
+	}
+
 	c := &Channel{
-		users: make([]*user.User, 0),
+		users:      make([]*user.User, 0),
+		privileges: make(map[int]int),
+		invited:    make(map[int]bool),
+		Metadata:   metadata.New(),
 	}
 
 	switch s[0:1] {
@@ -98,6 +128,9 @@ func New(s string) (*Channel, error) {
 
 	case NS_GLOBAL_RAW:
 		c.Namespace = NS_GLOBAL
+
+	default:
+		return nil, errors.New("Channel namespace unknown")
 	}
 
 	c.Name = s[1:]
@@ -144,13 +177,17 @@ func (c *Channel) Join(newUser *user.User) error {
 }
 
 func (c *Channel) Quit(uid int) error {
+	c.mutex.Lock()
+	delete(c.privileges, uid)
+	delete(c.invited, uid)
+	c.mutex.Unlock()
+
 	for idx, u := range c.users {
 		if u.Id == uid {
 			users := c.users
 			users[idx] = users[len(users)-1]
 			users = users[:len(users)-1]
 			c.users = users
-			fmt.Println(c.users)
 			return nil
 		}
 	}
@@ -169,7 +206,30 @@ outer:
 				}
 			}
 		}
-		u.SendMessage(m)
+		u.Deliver(m)
+	}
+
+	return nil
+}
+
+// BroadcastFunc sends every user the message built for him, which allows to
+// send a different version of a message depending on the capabilities.
+func (c *Channel) BroadcastFunc(f func(u *user.User) *message.Message, exludes []int) error {
+outer:
+	for _, u := range c.users {
+
+		if exludes != nil {
+			for _, exludeId := range exludes {
+				if exludeId == u.Id {
+					continue outer
+				}
+			}
+		}
+
+		m := f(u)
+		if m != nil {
+			u.Deliver(m)
+		}
 	}
 
 	return nil
@@ -202,6 +262,17 @@ func (c *Channel) SetTopic(s string, who string) {
 	c.topicSettime = time.Now().Unix()
 }
 
+// RestoreTopic sets a topic along with who set it and when, as it was before
+// a restart.
+func (c *Channel) RestoreTopic(s string, who string, at int64) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.topic = s
+	c.topicSetBy = who
+	c.topicSettime = at
+}
+
 func (c *Channel) Topic() string {
 	c.mutex.Lock()
 	defer c.mutex.Unlock()
@@ -216,9 +287,65 @@ func (c *Channel) TopicSetBy() string {
 	return c.topicSetBy
 }
 
-func (c *Channel) TopicSetTime() int {
+func (c *Channel) TopicSetTime() int64 {
 	c.mutex.Lock()
 	defer c.mutex.Unlock()
 
-	return c.TopicSetTime()
+	return c.topicSettime
+}
+
+// PrivilegeMode returns the privilege for a mode character, 0 if unknown
+func PrivilegeMode(char byte) int {
+	for _, pm := range privilegeModes {
+		if pm.char == char {
+			return pm.mode
+		}
+	}
+
+	return 0
+}
+
+func (c *Channel) SetPrivilege(uid int, privilege int) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.privileges[uid] |= privilege
+}
+
+func (c *Channel) ClearPrivilege(uid int, privilege int) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.privileges[uid] &= ^privilege
+}
+
+func (c *Channel) HasPrivilege(uid int, privilege int) bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	return c.privileges[uid]&privilege > 0
+}
+
+func (c *Channel) IsOperator(uid int) bool {
+	return c.HasPrivilege(uid, MODE_OPERATOR)
+}
+
+// Prefixes returns the prefixes of a member, all of them when multi is true
+// or only the highest one otherwise.
+func (c *Channel) Prefixes(uid int, multi bool) string {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	var s string
+
+	for _, pm := range privilegeModes {
+		if c.privileges[uid]&pm.mode > 0 {
+			s += pm.prefix
+			if !multi {
+				break
+			}
+		}
+	}
+
+	return s
 }
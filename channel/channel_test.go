@@ -20,3 +20,35 @@ func TestParse(t *testing.T) {
 		t.Error("Failed to parse the channel namespace")
 	}
 }
+
+func TestParseMalformed(t *testing.T) {
+	for _, name := range []string{"dev", "#", "#dev,ops", "#dev ops"} {
+		if _, err := New(name); err == nil {
+			t.Errorf("channel name %q should be refused", name)
+		}
+	}
+}
+
+func TestPrefixes(t *testing.T) {
+	c, _ := New("#dev")
+
+	c.SetPrivilege(1, MODE_VOICE)
+	c.SetPrivilege(1, MODE_OPERATOR)
+
+	if c.Prefixes(1, true) != "@+" {
+		t.Error("all prefixes should be shown with multi-prefix")
+	}
+
+	if c.Prefixes(1, false) != "@" {
+		t.Error("only the highest prefix should be shown")
+	}
+
+	c.ClearPrivilege(1, MODE_OPERATOR)
+	if c.Prefixes(1, false) != "+" || c.IsOperator(1) {
+		t.Error("operator privilege should be cleared")
+	}
+
+	if c.Prefixes(2, true) != "" {
+		t.Error("member without privileges has no prefix")
+	}
+}
@@ -0,0 +1,206 @@
+/*
+ * Copyright 2014 The starfruit Authors. All rights reserved.
+ * Use of this source code is governed by a BSD-style
+ * license that can be found in the LICENSE file.
+ */
+
+package channel
+
+import (
+	"github.com/flatpeach/starfruit/mask"
+	"strconv"
+)
+
+// Flag modes, which are set without parameter
+var flagModes = []struct {
+	mode int
+	char byte
+}{
+	{MODE_INVITE, 'i'},
+	{MODE_MODERATED, 'm'},
+	{MODE_NO_MESSAGE, 'n'},
+	{MODE_PRIVATE, 'p'},
+	{MODE_SECRET, 's'},
+	{MODE_TOPIC, 't'},
+}
+
+func (c *Channel) MarkMode(m int) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.Modes |= m
+}
+
+func (c *Channel) ClearMode(m int) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.Modes &= ^m
+}
+
+func (c *Channel) HasMode(m int) bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	return c.Modes&m > 0
+}
+
+func (c *Channel) Key() string {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	return c.key
+}
+
+func (c *Channel) SetKey(key string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.key = key
+	if key != "" {
+		c.Modes |= MODE_KEY
+	} else {
+		c.Modes &= ^MODE_KEY
+	}
+}
+
+func (c *Channel) Limit() int {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	return c.limit
+}
+
+func (c *Channel) SetLimit(limit int) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.limit = limit
+	if limit > 0 {
+		c.Modes |= MODE_LIMIT
+	} else {
+		c.Modes &= ^MODE_LIMIT
+	}
+}
+
+// ModeString returns the modes of this channel along with their parameters,
+// as shown in RPL_CHANNELMODEIS.
+func (c *Channel) ModeString() (string, []string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	var (
+		s      = "+"
+		params []string
+	)
+
+	for _, fm := range flagModes {
+		if c.Modes&fm.mode > 0 {
+			s += string(fm.char)
+		}
+	}
+
+	if c.Modes&MODE_KEY > 0 {
+		s += "k"
+		params = append(params, c.key)
+	}
+
+	if c.Modes&MODE_LIMIT > 0 {
+		s += "l"
+		params = append(params, strconv.Itoa(c.limit))
+	}
+
+	return s, params
+}
+
+// Flags returns the characters of the flag modes set on this channel
+func (c *Channel) Flags() string {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	var s string
+
+	for _, fm := range flagModes {
+		if c.Modes&fm.mode > 0 {
+			s += string(fm.char)
+		}
+	}
+
+	return s
+}
+
+// FlagMode returns the flag mode for a mode character, 0 if unknown
+func FlagMode(char byte) int {
+	for _, fm := range flagModes {
+		if fm.char == char {
+			return fm.mode
+		}
+	}
+
+	return 0
+}
+
+// Invite lets a user join even if the channel is invite-only or he is banned
+func (c *Channel) Invite(uid int) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.invited[uid] = true
+}
+
+func (c *Channel) IsInvited(uid int) bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	return c.invited[uid]
+}
+
+func (c *Channel) Bans() []string {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	return append([]string(nil), c.bans...)
+}
+
+func (c *Channel) AddBan(m string) bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	for _, ban := range c.bans {
+		if ban == m {
+			return false
+		}
+	}
+
+	c.bans = append(c.bans, m)
+
+	return true
+}
+
+func (c *Channel) RemoveBan(m string) bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	for idx, ban := range c.bans {
+		if ban == m {
+			c.bans = append(c.bans[:idx], c.bans[idx+1:]...)
+			return true
+		}
+	}
+
+	return false
+}
+
+// IsBanned tells whether a nick!user@host matches one of the bans
+func (c *Channel) IsBanned(full string) bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	for _, ban := range c.bans {
+		if mask.Match(ban, full) {
+			return true
+		}
+	}
+
+	return false
+}
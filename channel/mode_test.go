@@ -0,0 +1,44 @@
+package channel
+
+import (
+	"testing"
+)
+
+func TestModes(t *testing.T) {
+	c, _ := New("#dev")
+
+	c.MarkMode(MODE_NO_MESSAGE)
+	c.MarkMode(MODE_TOPIC)
+	c.SetKey("secret")
+	c.SetLimit(10)
+
+	modes, params := c.ModeString()
+	if modes != "+ntkl" || len(params) != 2 || params[0] != "secret" || params[1] != "10" {
+		t.Error("mode string built error")
+	}
+
+	if c.Flags() != "nt" {
+		t.Error("flags should not include modes with parameters")
+	}
+
+	c.SetKey("")
+	if c.HasMode(MODE_KEY) {
+		t.Error("empty key should clear the mode")
+	}
+}
+
+func TestBans(t *testing.T) {
+	c, _ := New("#dev")
+
+	if !c.AddBan("*!*@spam.example.com") || c.AddBan("*!*@spam.example.com") {
+		t.Error("duplicated ban should not be added")
+	}
+
+	if !c.IsBanned("rock!~rock@spam.example.com") {
+		t.Error("user should be banned")
+	}
+
+	if !c.RemoveBan("*!*@spam.example.com") || c.IsBanned("rock!~rock@spam.example.com") {
+		t.Error("ban should be removed")
+	}
+}
@@ -42,6 +42,7 @@ type Server struct {
 	Ip               string   `gcfg:"ip"`               // IP to bind, normally 0.0.0.0
 	Ports            []int    `gcfg:"port"`             // Port to bind
 	Name             string   `gcfg:"name"`             // Name of this IRC server
+	Network          string   `gcfg:"network"`          // Name of the IRC network
 	CreatedAt        string   `gcfg:"created"`          // Creted Time of this IRC server
 	SSL              bool     `gcfg:"ssl"`              // Enable SSL or not
 	Password         string   `gcfg:"password"`         // Password of this IRC server
@@ -59,10 +60,141 @@ type Recycle struct {
 	UserTimeout  int `gcfg:"user-timeout"`
 }
 
+type Account struct {
+	File string `gcfg:"file"` // Where to store accounts, kept in memory if empty
+}
+
+type ChanServ struct {
+	File string `gcfg:"file"` // Where to store registered channels, kept in memory if empty
+}
+
+type Ban struct {
+	File string `gcfg:"file"` // Where to store server bans, kept in memory if empty
+}
+
+// Auth picks the backends passwords are checked with, the accounts named by
+// other backends than accounts are suffixed with @<backend>.
+type Auth struct {
+	Pass         string `gcfg:"pass"`          // Backend checking PASS: none, static, htpasswd or exec
+	Sasl         string `gcfg:"sasl"`          // Backend checking SASL PLAIN: accounts, htpasswd or exec
+	HtpasswdFile string `gcfg:"htpasswd-file"` // Users and their bcrypt hash, as written by htpasswd -B
+	ExecCommand  string `gcfg:"exec-command"`  // Command given the user and the password on stdin
+	ExecTimeout  int    `gcfg:"exec-timeout"`  // Seconds the command may run
+}
+
+type OAuth struct {
+	JwksFile string `gcfg:"jwks-file"` // Keys signing the tokens of SASL OAUTHBEARER, disabled if empty
+	Issuer   string `gcfg:"issuer"`    // Issuer the tokens must come from, any if empty
+	Audience string `gcfg:"audience"`  // Audience the tokens must be issued for, any if empty
+	Claim    string `gcfg:"claim"`     // Claim holding the name of the account
+}
+
+// OperClass lists the capabilities given to the operators of a class
+type OperClass struct {
+	Capabilities []string `gcfg:"capability"` // kill, rehash, die, wallops, see-secret, override-channel-modes, manage-bans, chghost or services
+}
+
+// Oper is an operator block, users gain its class with OPER <name> <password>
+type Oper struct {
+	Password string   `gcfg:"password"` // bcrypt hash of the password, as written by htpasswd -nB
+	Class    string   `gcfg:"class"`    // Name of the class of the operator
+	Hosts    []string `gcfg:"host"`     // Masks the operator may connect from, anywhere if empty
+}
+
+type Sasl struct {
+	Required bool `gcfg:"required"` // Refuse users not authenticated with SASL
+}
+
+type Register struct {
+	Enabled           bool `gcfg:"enabled"`             // Let users register accounts
+	BeforeConnect     bool `gcfg:"before-connect"`      // Let users register before completing the connection
+	EmailRequired     bool `gcfg:"email-required"`      // Refuse to register accounts without an email address
+	Verify            bool `gcfg:"verify"`              // Accounts must be verified with a code before use
+	MinPasswordLength int  `gcfg:"min-password-length"` // Shortest password accepted
+	CodeExpiry        int  `gcfg:"code-expiry"`         // Seconds verification and reset codes may be used
+}
+
+type Smtp struct {
+	Server         string `gcfg:"server"`          // Host and port of the relay, codes are only logged if empty
+	From           string `gcfg:"from"`            // Sender of the emails
+	Username       string `gcfg:"username"`        // Empty if the relay needs no authentication
+	Password       string `gcfg:"password"`        // Password of the relay user
+	Interval       int    `gcfg:"interval"`        // Seconds between two emails to the same address
+	VerifyTemplate string `gcfg:"verify-template"` // File replacing the verification email
+	ResetTemplate  string `gcfg:"reset-template"`  // File replacing the password reset email
+}
+
+type Nick struct {
+	Enforce     bool   `gcfg:"enforce"`      // Rename users taking a nick owned by another account
+	GracePeriod int    `gcfg:"grace-period"` // Seconds given to log in before being renamed
+	GuestPrefix string `gcfg:"guest-prefix"` // Prefix of the nicks users are renamed to
+	MaxGrouped  int    `gcfg:"max-grouped"`  // Nicks an account may group besides its name
+}
+
+type Memo struct {
+	MaxMemos int `gcfg:"max-memos"` // Memos an account may hold, 0 for no limit
+}
+
+type History struct {
+	Enabled        bool   `gcfg:"enabled"`          // Keep the history of channels
+	MaxItems       int    `gcfg:"max-items"`        // Items kept per target, 0 for no limit
+	MaxAge         int    `gcfg:"max-age"`          // Seconds items are kept, 0 for no limit
+	Dir            string `gcfg:"dir"`              // Where to store history, kept in memory if empty
+	DirectMessages bool   `gcfg:"direct-messages"`  // Keep the history of private conversations too
+	DirectMaxItems int    `gcfg:"direct-max-items"` // Items kept per conversation, 0 for no limit
+	DirectMaxAge   int    `gcfg:"direct-max-age"`   // Seconds private items are kept, 0 for no limit
+}
+
+type Redact struct {
+	Window    int  `gcfg:"window"`    // Seconds senders may redact their messages, 0 for no limit
+	Tombstone bool `gcfg:"tombstone"` // Keep redacted messages in history without their content
+}
+
+type Metadata struct {
+	MaxKeys       int      `gcfg:"max-keys"`        // Keys a user or a channel may have
+	MaxValueBytes int      `gcfg:"max-value-bytes"` // Longest value allowed
+	MaxSubs       int      `gcfg:"max-subs"`        // Keys a user may subscribe to
+	PrivateKeys   []string `gcfg:"private-key"`     // Keys only their owner and operators may see
+}
+
+type Multiline struct {
+	MaxBytes int `gcfg:"max-bytes"` // Longest text of a multiline batch
+	MaxLines int `gcfg:"max-lines"` // Most lines of a multiline batch
+}
+
+type Monitor struct {
+	MaxTargets int `gcfg:"max-targets"` // Nicks a user may monitor, 0 for no limit
+}
+
+type Tags struct {
+	// Client-only tags relayed to other users, "*" for all of them. The
+	// defaults are kept unless a bare "allowed" line comes first.
+	Allowed []string `gcfg:"allowed"`
+}
+
 type Config struct {
-	Server  Server
-	Motd    Motd
-	Recycle Recycle
+	Server    Server
+	Motd      Motd
+	Recycle   Recycle
+	Account   Account
+	ChanServ  ChanServ
+	Ban       Ban
+	Auth      Auth
+	OAuth     OAuth
+	Sasl      Sasl
+	Register  Register
+	Smtp      Smtp
+	Nick      Nick
+	Memo      Memo
+	History   History
+	Redact    Redact
+	Metadata  Metadata
+	Multiline Multiline
+	Monitor   Monitor
+	Tags      Tags
+
+	Classes map[string]*OperClass `gcfg:"class"`
+	Opers   map[string]*Oper      `gcfg:"oper"`
 }
 
 func New() *Config {
@@ -71,6 +203,7 @@ func New() *Config {
 			Ip:               "127.0.0.1",
 			Ports:            []int{6667},
 			Name:             "irc.starfruit.io",
+			Network:          "starfruit",
 			CreatedAt:        "xxx", // @Fix this
 			SSL:              false,
 			Password:         "",
@@ -83,11 +216,119 @@ func New() *Config {
 			PingInterval: 300,
 			UserTimeout:  300,
 		},
+		Account:  Account{File: ""},
+		ChanServ: ChanServ{File: ""},
+		Ban:      Ban{File: ""},
+		Auth: Auth{
+			Pass:        "static",
+			Sasl:        "accounts",
+			ExecTimeout: 5,
+		},
+		OAuth: OAuth{
+			Claim: "preferred_username",
+		},
+		Sasl: Sasl{Required: false},
+		Register: Register{
+			Enabled:           true,
+			BeforeConnect:     true,
+			EmailRequired:     false,
+			Verify:            false,
+			MinPasswordLength: 8,
+			CodeExpiry:        3600,
+		},
+		Smtp: Smtp{
+			From:     "services@starfruit.io",
+			Interval: 300,
+		},
+		Nick: Nick{
+			Enforce:     true,
+			GracePeriod: 60,
+			GuestPrefix: "Guest",
+			MaxGrouped:  5,
+		},
+		Memo: Memo{
+			MaxMemos: 20,
+		},
+		History: History{
+			Enabled:  true,
+			MaxItems: 1000,
+			MaxAge:   7 * 24 * 3600,
+			Dir:      "",
+
+			DirectMessages: true,
+			DirectMaxItems: 1000,
+			DirectMaxAge:   7 * 24 * 3600,
+		},
+		Redact: Redact{
+			Window:    15 * 60,
+			Tombstone: false,
+		},
+		Metadata: Metadata{
+			MaxKeys:       20,
+			MaxValueBytes: 300,
+			MaxSubs:       50,
+			PrivateKeys:   []string{},
+		},
+		Multiline: Multiline{
+			MaxBytes: 4096,
+			MaxLines: 100,
+		},
+		Monitor: Monitor{
+			MaxTargets: 100,
+		},
+		Tags: Tags{
+			Allowed: []string{"+typing", "+draft/react", "+draft/reply"},
+		},
 	}
 	return cf
 }
 
 func (c *Config) LoadFromFile(name string) error {
 	err := gcfg.ReadFileInto(c, name)
-	return err
+	if err != nil {
+		return err
+	}
+
+	// Client-only tags may be listed with or without their "+"
+	for i, tag := range c.Tags.Allowed {
+		if tag != "" && tag != "*" && !strings.HasPrefix(tag, "+") {
+			c.Tags.Allowed[i] = "+" + tag
+		}
+	}
+
+	return nil
+}
+
+// PasswordRequired tells whether users must send PASS before registering
+func (c *Config) PasswordRequired() bool {
+	switch c.Auth.Pass {
+	case "none":
+		return false
+	case "static":
+		return c.Server.Password != ""
+	}
+
+	return true
+}
+
+// EmailRequired tells whether accounts must be registered with an email
+// address, which is the case when codes are sent by email.
+func (c *Config) EmailRequired() bool {
+	return c.Register.EmailRequired || (c.Register.Verify && c.Smtp.Server != "")
+}
+
+// ClassCan tells whether the operators of a class have a capability
+func (c *Config) ClassCan(class string, capability string) bool {
+	cl, exists := c.Classes[class]
+	if !exists {
+		return false
+	}
+
+	for _, name := range cl.Capabilities {
+		if name == capability {
+			return true
+		}
+	}
+
+	return false
 }
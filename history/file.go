@@ -0,0 +1,161 @@
+/*
+ * Copyright 2014 The starfruit Authors. All rights reserved.
+ * Use of this source code is governed by a BSD-style
+ * license that can be found in the LICENSE file.
+ */
+
+package history
+
+import (
+	"bufio"
+	"encoding/json"
+	"github.com/flatpeach/starfruit/jsonfile"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const fileSuffix = ".jsonl"
+
+// FileStore is a MemoryStore whose items are also appended to a file per
+// target, one JSON item per line, so history survives a restart.
+type FileStore struct {
+	*MemoryStore
+
+	dir    string
+	pruned map[string]int // Items pruned since the file was last rewritten
+}
+
+func NewFileStore(dir string, maxItems int, maxAge time.Duration) (*FileStore, error) {
+	fs := &FileStore{
+		MemoryStore: NewMemoryStore(maxItems, maxAge),
+		dir:         dir,
+		pruned:      make(map[string]int),
+	}
+
+	err := os.MkdirAll(dir, 0700)
+	if err != nil {
+		return nil, err
+	}
+
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, f := range files {
+		if f.IsDir() || !strings.HasSuffix(f.Name(), fileSuffix) {
+			continue
+		}
+
+		target, err := url.PathUnescape(strings.TrimSuffix(f.Name(), fileSuffix))
+		if err != nil {
+			continue
+		}
+
+		err = fs.load(target)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return fs, nil
+}
+
+func (fs *FileStore) Add(target string, item *Item) error {
+	fs.mutex.Lock()
+	defer fs.mutex.Unlock()
+
+	key := Key(target)
+
+	fs.pruned[key] += fs.add(target, item)
+
+	// Rewrite the file once it holds as many stale items as live ones
+	if fs.pruned[key] > 0 && fs.pruned[key] >= len(fs.targets[key].items) {
+		return fs.rewrite(target)
+	}
+
+	return fs.append(target, item)
+}
+
+func (fs *FileStore) Replace(target string, msgid string, item *Item) (bool, error) {
+	fs.mutex.Lock()
+	defer fs.mutex.Unlock()
+
+	if !fs.replace(target, msgid, item) {
+		return false, nil
+	}
+
+	return true, fs.rewrite(target)
+}
+
+func (fs *FileStore) path(target string) string {
+	return filepath.Join(fs.dir, url.PathEscape(Key(target))+fileSuffix)
+}
+
+func (fs *FileStore) load(target string) error {
+	f, err := os.Open(fs.path(target))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(nil, 1<<20)
+
+	for scanner.Scan() {
+		var item Item
+
+		// Skip lines broken by a crash while writing
+		if json.Unmarshal(scanner.Bytes(), &item) != nil {
+			continue
+		}
+
+		fs.pruned[Key(target)] += fs.add(target, &item)
+	}
+
+	return scanner.Err()
+}
+
+func (fs *FileStore) append(target string, item *Item) error {
+	data, err := json.Marshal(item)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(fs.path(target), os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	_, err = f.Write(append(data, '\n'))
+
+	return err
+}
+
+func (fs *FileStore) rewrite(target string) error {
+	var data []byte
+
+	for _, item := range fs.targets[Key(target)].items {
+		line, err := json.Marshal(item)
+		if err != nil {
+			return err
+		}
+
+		data = append(data, line...)
+		data = append(data, '\n')
+	}
+
+	err := jsonfile.WriteFile(fs.path(target), data)
+	if err != nil {
+		return err
+	}
+
+	fs.pruned[Key(target)] = 0
+
+	return nil
+}
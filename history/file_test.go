@@ -0,0 +1,32 @@
+package history
+
+import (
+	"io/ioutil"
+	"testing"
+	"time"
+)
+
+func TestFileStore(t *testing.T) {
+	dir := t.TempDir()
+
+	fs, err := NewFileStore(dir, 4, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fill(fs, "#Starfruit/Dev", 10)
+
+	fs, err = NewFileStore(dir, 4, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got := ids(fs.Latest("#starfruit/dev", time.Time{}, 0)); got != "ghij" {
+		t.Errorf("history should survive a restart, got %q", got)
+	}
+
+	files, _ := ioutil.ReadDir(dir)
+	if len(files) != 1 {
+		t.Errorf("one file per target expected, got %d", len(files))
+	}
+}
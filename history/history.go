@@ -0,0 +1,153 @@
+/*
+ * Copyright 2014 The starfruit Authors. All rights reserved.
+ * Use of this source code is governed by a BSD-style
+ * license that can be found in the LICENSE file.
+ */
+
+package history
+
+import (
+	"github.com/flatpeach/starfruit/casemapping"
+	"github.com/flatpeach/starfruit/message"
+	"sort"
+	"time"
+)
+
+// Item is a message relayed by the server, kept to be played back later
+type Item struct {
+	MsgId       string            `json:"msgid"`
+	Time        time.Time         `json:"time"`
+	Source      string            `json:"source"` // nick!user@host of the sender
+	Account     string            `json:"account,omitempty"`
+	Conn        string            `json:"conn,omitempty"` // Connection of a sender not logged in
+	Command     string            `json:"command"`
+	Params      []string          `json:"params,omitempty"` // Trailing excluded
+	Trailing    string            `json:"trailing,omitempty"`
+	HasTrailing bool              `json:"has_trailing,omitempty"`
+	Tags        map[string]string `json:"tags,omitempty"` // Client-only tags
+	Redacted    bool              `json:"redacted,omitempty"`
+}
+
+// Target is a conversation with history, along with its latest message
+type Target struct {
+	Name   string
+	Latest time.Time
+}
+
+// Store keeps the history of every target, targets are case insensitive.
+// Items returned are always sorted from the oldest to the newest.
+type Store interface {
+	Add(target string, item *Item) error
+	Find(target string, msgid string) *Item
+
+	// Latest returns the newest items after the given time, if not zero
+	Latest(target string, after time.Time, limit int) []*Item
+
+	// Before and After exclude the item at the given time
+	Before(target string, t time.Time, limit int) []*Item
+	After(target string, t time.Time, limit int) []*Item
+
+	// Between returns the items closest to start, start and end excluded
+	Between(target string, start time.Time, end time.Time, limit int) []*Item
+
+	// Targets returns the targets with items between start and end, only
+	// the targets the filter accepts are returned unless it is nil.
+	Targets(start time.Time, end time.Time, limit int, filter func(name string) bool) []*Target
+
+	// Replace swaps the item with the given msgid for another, or removes it
+	// if the other is nil. It returns false if there is no such item.
+	Replace(target string, msgid string, item *Item) (bool, error)
+}
+
+// NewItem makes an history item from a message stamped by the server
+func NewItem(m *message.Message, account string) *Item {
+	item := &Item{
+		Source:      m.Prefix,
+		Account:     account,
+		Command:     m.Command,
+		Params:      m.Params,
+		Trailing:    m.Trailing,
+		HasTrailing: m.HasTrailing(),
+		Tags:        m.ClientTags(),
+	}
+
+	if item.HasTrailing && len(item.Params) > 0 {
+		item.Params = item.Params[:len(item.Params)-1]
+	}
+
+	item.MsgId, _ = m.Tag("msgid")
+
+	ts, _ := m.Tag("time")
+
+	t, err := time.Parse(message.TimeFormat, ts)
+	if err != nil {
+		t = time.Now()
+	}
+	item.Time = t.UTC()
+
+	return item
+}
+
+// Tombstone returns a copy of this item whose content is gone, kept so the
+// references to its msgid are still valid.
+func (i *Item) Tombstone() *Item {
+	tombstone := *i
+	tombstone.Trailing = ""
+	tombstone.Tags = nil
+	tombstone.Redacted = true
+
+	return &tombstone
+}
+
+// Message rebuilds the message as it was relayed, with its time and msgid
+func (i *Item) Message() *message.Message {
+	var trailing interface{}
+	if i.HasTrailing {
+		trailing = i.Trailing
+	}
+
+	m := message.New(
+		i.Source,
+		i.Command,
+		append([]string(nil), i.Params...),
+		trailing,
+	)
+
+	for k, v := range i.Tags {
+		m.SetTag(k, v)
+	}
+
+	m.SetTag("time", i.Time.Format(message.TimeFormat))
+	if i.MsgId != "" {
+		m.SetTag("msgid", i.MsgId)
+	}
+
+	return m
+}
+
+// SortTargets sorts targets by their latest item and keeps at most limit of
+// them, the newest ones if reversed, the oldest ones otherwise.
+func SortTargets(targets []*Target, limit int, reversed bool) []*Target {
+	sort.Sort(byLatest(targets))
+
+	if limit > 0 && len(targets) > limit {
+		if reversed {
+			targets = targets[len(targets)-limit:]
+		} else {
+			targets = targets[:limit]
+		}
+	}
+
+	return targets
+}
+
+type byLatest []*Target
+
+func (t byLatest) Len() int           { return len(t) }
+func (t byLatest) Swap(i, j int)      { t[i], t[j] = t[j], t[i] }
+func (t byLatest) Less(i, j int) bool { return t[i].Latest.Before(t[j].Latest) }
+
+// Key returns the name targets are indexed with
+func Key(target string) string {
+	return casemapping.Fold(target)
+}
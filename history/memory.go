@@ -0,0 +1,263 @@
+/*
+ * Copyright 2014 The starfruit Authors. All rights reserved.
+ * Use of this source code is governed by a BSD-style
+ * license that can be found in the LICENSE file.
+ */
+
+package history
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// MemoryStore keeps the history in memory, bounded by a number of items per
+// target and by the age of items. A zero bound means no limit.
+type MemoryStore struct {
+	maxItems int
+	maxAge   time.Duration
+	targets  map[string]*buffer
+	mutex    sync.Mutex
+}
+
+type buffer struct {
+	name  string
+	items []*Item // Sorted from the oldest to the newest
+}
+
+func NewMemoryStore(maxItems int, maxAge time.Duration) *MemoryStore {
+	ms := &MemoryStore{
+		maxItems: maxItems,
+		maxAge:   maxAge,
+		targets:  make(map[string]*buffer),
+	}
+
+	return ms
+}
+
+func (ms *MemoryStore) Add(target string, item *Item) error {
+	ms.mutex.Lock()
+	defer ms.mutex.Unlock()
+
+	ms.add(target, item)
+
+	return nil
+}
+
+// add appends an item and returns the number of items pruned
+func (ms *MemoryStore) add(target string, item *Item) int {
+	b, exists := ms.targets[Key(target)]
+	if !exists {
+		b = &buffer{name: target}
+		ms.targets[Key(target)] = b
+	}
+
+	// Times are only precise to the millisecond, give every item of a
+	// target its own time so selectors always point to a single item.
+	if n := len(b.items); n > 0 && !item.Time.After(b.items[n-1].Time) {
+		item.Time = b.items[n-1].Time.Add(time.Nanosecond)
+	}
+
+	b.items = append(b.items, item)
+
+	return ms.prune(b)
+}
+
+func (ms *MemoryStore) prune(b *buffer) int {
+	n := 0
+
+	if ms.maxItems > 0 && len(b.items) > ms.maxItems {
+		n = len(b.items) - ms.maxItems
+	}
+
+	if ms.maxAge > 0 {
+		limit := time.Now().Add(-ms.maxAge)
+		for n < len(b.items) && b.items[n].Time.Before(limit) {
+			n++
+		}
+	}
+
+	if n > 0 {
+		b.items = append([]*Item(nil), b.items[n:]...)
+	}
+
+	return n
+}
+
+func (ms *MemoryStore) items(target string) []*Item {
+	b, exists := ms.targets[Key(target)]
+	if !exists {
+		return nil
+	}
+
+	ms.prune(b)
+
+	return b.items
+}
+
+func (ms *MemoryStore) Find(target string, msgid string) *Item {
+	ms.mutex.Lock()
+	defer ms.mutex.Unlock()
+
+	for _, item := range ms.items(target) {
+		if item.MsgId == msgid {
+			return item
+		}
+	}
+
+	return nil
+}
+
+func (ms *MemoryStore) Latest(target string, after time.Time, limit int) []*Item {
+	ms.mutex.Lock()
+	defer ms.mutex.Unlock()
+
+	items := ms.items(target)
+
+	start := 0
+	if !after.IsZero() {
+		start = sort.Search(len(items), func(i int) bool {
+			return items[i].Time.After(after)
+		})
+	}
+
+	return newest(items[start:], limit)
+}
+
+func (ms *MemoryStore) Before(target string, t time.Time, limit int) []*Item {
+	ms.mutex.Lock()
+	defer ms.mutex.Unlock()
+
+	items := ms.items(target)
+
+	end := sort.Search(len(items), func(i int) bool {
+		return !items[i].Time.Before(t)
+	})
+
+	return newest(items[:end], limit)
+}
+
+func (ms *MemoryStore) After(target string, t time.Time, limit int) []*Item {
+	ms.mutex.Lock()
+	defer ms.mutex.Unlock()
+
+	items := ms.items(target)
+
+	start := sort.Search(len(items), func(i int) bool {
+		return items[i].Time.After(t)
+	})
+
+	return oldest(items[start:], limit)
+}
+
+func (ms *MemoryStore) Between(target string, start time.Time, end time.Time, limit int) []*Item {
+	ms.mutex.Lock()
+	defer ms.mutex.Unlock()
+
+	items := ms.items(target)
+
+	low, high := start, end
+	if end.Before(start) {
+		low, high = end, start
+	}
+
+	first := sort.Search(len(items), func(i int) bool {
+		return items[i].Time.After(low)
+	})
+	last := sort.Search(len(items), func(i int) bool {
+		return !items[i].Time.Before(high)
+	})
+
+	if first >= last {
+		return nil
+	}
+
+	if end.Before(start) {
+		return newest(items[first:last], limit)
+	}
+
+	return oldest(items[first:last], limit)
+}
+
+func (ms *MemoryStore) Targets(start time.Time, end time.Time, limit int, filter func(name string) bool) []*Target {
+	ms.mutex.Lock()
+	defer ms.mutex.Unlock()
+
+	low, high := start, end
+	if end.Before(start) {
+		low, high = end, start
+	}
+
+	var targets []*Target
+
+	for key, b := range ms.targets {
+		if filter != nil && !filter(b.name) {
+			continue
+		}
+
+		items := ms.items(key)
+
+		// Only the latest item in the range matters
+		i := sort.Search(len(items), func(i int) bool {
+			return !items[i].Time.Before(high)
+		}) - 1
+
+		if i < 0 || !items[i].Time.After(low) {
+			continue
+		}
+
+		targets = append(targets, &Target{
+			Name:   b.name,
+			Latest: items[i].Time,
+		})
+	}
+
+	return SortTargets(targets, limit, end.Before(start))
+}
+
+func (ms *MemoryStore) Replace(target string, msgid string, item *Item) (bool, error) {
+	ms.mutex.Lock()
+	defer ms.mutex.Unlock()
+
+	return ms.replace(target, msgid, item), nil
+}
+
+func (ms *MemoryStore) replace(target string, msgid string, item *Item) bool {
+	b, exists := ms.targets[Key(target)]
+	if !exists {
+		return false
+	}
+
+	for i, old := range b.items {
+		if old.MsgId != msgid {
+			continue
+		}
+
+		if item == nil {
+			b.items = append(b.items[:i:i], b.items[i+1:]...)
+		} else {
+			b.items[i] = item
+		}
+
+		return true
+	}
+
+	return false
+}
+
+func oldest(items []*Item, limit int) []*Item {
+	if limit > 0 && len(items) > limit {
+		items = items[:limit]
+	}
+
+	return append([]*Item(nil), items...)
+}
+
+func newest(items []*Item, limit int) []*Item {
+	if limit > 0 && len(items) > limit {
+		items = items[len(items)-limit:]
+	}
+
+	return append([]*Item(nil), items...)
+}
@@ -0,0 +1,153 @@
+package history
+
+import (
+	"github.com/flatpeach/starfruit/message"
+	"testing"
+	"time"
+)
+
+var base = time.Date(2014, 1, 1, 0, 0, 0, 0, time.UTC)
+
+func at(i int) time.Time {
+	return base.Add(time.Duration(i) * time.Second)
+}
+
+func fill(st Store, target string, n int) {
+	for i := 0; i < n; i++ {
+		st.Add(target, &Item{
+			MsgId:   string(rune('a' + i)),
+			Time:    at(i),
+			Command: "PRIVMSG",
+		})
+	}
+}
+
+func ids(items []*Item) string {
+	var s string
+	for _, item := range items {
+		s += item.MsgId
+	}
+
+	return s
+}
+
+func TestMemoryStoreQueries(t *testing.T) {
+	ms := NewMemoryStore(0, 0)
+	fill(ms, "#Starfruit", 10) // abcdefghij
+
+	tests := []struct {
+		name  string
+		items []*Item
+		want  string
+	}{
+		{"latest", ms.Latest("#starfruit", time.Time{}, 3), "hij"},
+		{"latest after", ms.Latest("#starfruit", at(7), 5), "ij"},
+		{"before", ms.Before("#starfruit", at(5), 3), "cde"},
+		{"after", ms.After("#starfruit", at(5), 3), "ghi"},
+		{"between", ms.Between("#starfruit", at(2), at(8), 3), "def"},
+		{"between reversed", ms.Between("#starfruit", at(8), at(2), 3), "fgh"},
+		{"unknown target", ms.Latest("#nothing", time.Time{}, 3), ""},
+	}
+
+	for _, test := range tests {
+		if got := ids(test.items); got != test.want {
+			t.Errorf("%s: got %q, want %q", test.name, got, test.want)
+		}
+	}
+
+	if item := ms.Find("#STARFRUIT", "c"); item == nil || !item.Time.Equal(at(2)) {
+		t.Error("item should be found by its msgid")
+	}
+}
+
+func TestMemoryStoreSameTime(t *testing.T) {
+	ms := NewMemoryStore(0, 0)
+
+	for _, id := range []string{"a", "b", "c"} {
+		ms.Add("#starfruit", &Item{MsgId: id, Time: base})
+	}
+
+	b := ms.Find("#starfruit", "b")
+	if got := ids(ms.Before("#starfruit", b.Time, 0)); got != "a" {
+		t.Errorf("items at the same time should be told apart, got %q", got)
+	}
+
+	if got := ids(ms.After("#starfruit", b.Time, 0)); got != "c" {
+		t.Errorf("items at the same time should be told apart, got %q", got)
+	}
+}
+
+func TestMemoryStoreReplace(t *testing.T) {
+	ms := NewMemoryStore(0, 0)
+	fill(ms, "#starfruit", 4) // abcd
+
+	if ok, _ := ms.Replace("#starfruit", "b", nil); !ok {
+		t.Fatal("item should be removed")
+	}
+
+	if ok, _ := ms.Replace("#starfruit", "z", nil); ok {
+		t.Error("unknown item can't be removed")
+	}
+
+	c := ms.Find("#starfruit", "c")
+	ms.Replace("#starfruit", "c", c.Tombstone())
+
+	if got := ids(ms.Latest("#starfruit", time.Time{}, 0)); got != "acd" {
+		t.Errorf("got %q, want %q", got, "acd")
+	}
+
+	if c = ms.Find("#starfruit", "c"); !c.Redacted || c.Trailing != "" {
+		t.Error("tombstone should keep the item without its content")
+	}
+}
+
+func TestMemoryStoreBounds(t *testing.T) {
+	ms := NewMemoryStore(4, 0)
+	fill(ms, "#starfruit", 10)
+
+	if got := ids(ms.Latest("#starfruit", time.Time{}, 0)); got != "ghij" {
+		t.Errorf("only the newest items should be kept, got %q", got)
+	}
+
+	ms = NewMemoryStore(0, time.Hour)
+	ms.Add("#starfruit", &Item{MsgId: "old", Time: time.Now().Add(-2 * time.Hour)})
+	ms.Add("#starfruit", &Item{MsgId: "new", Time: time.Now()})
+
+	if got := ids(ms.Latest("#starfruit", time.Time{}, 0)); got != "new" {
+		t.Errorf("expired items should be dropped, got %q", got)
+	}
+}
+
+func TestMemoryStoreTargets(t *testing.T) {
+	ms := NewMemoryStore(0, 0)
+	fill(ms, "#a", 3)
+	fill(ms, "#b", 6)
+	fill(ms, "#c", 1)
+
+	targets := ms.Targets(at(0), at(10), 0, nil)
+	if len(targets) != 2 || targets[0].Name != "#a" || targets[1].Name != "#b" {
+		t.Errorf("targets should be sorted by latest item, got %v", targets)
+	}
+
+	targets = ms.Targets(at(0), at(10), 0, func(name string) bool {
+		return name != "#a"
+	})
+	if len(targets) != 1 || targets[0].Name != "#b" {
+		t.Errorf("targets should be filtered, got %v", targets)
+	}
+}
+
+func TestItemMessage(t *testing.T) {
+	m, _ := message.Parse("@+draft/react=x;time=2014-01-01T00:00:00.000Z;msgid=abc :nick!user@host PRIVMSG #starfruit :hello world")
+
+	item := NewItem(m, "account")
+
+	if item.MsgId != "abc" || !item.Time.Equal(base) || item.Account != "account" {
+		t.Errorf("item parsed error: %+v", item)
+	}
+
+	want := "@+draft/react=x;msgid=abc;time=2014-01-01T00:00:00.000Z :nick!user@host PRIVMSG #starfruit :hello world"
+	if got := item.Message().String(); got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,177 @@
+/*
+ * Copyright 2014 The starfruit Authors. All rights reserved.
+ * Use of this source code is governed by a BSD-style
+ * license that can be found in the LICENSE file.
+ */
+
+package isupport
+
+import (
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Maximum tokens carried by a single RPL_ISUPPORT line
+const MaxTokensPerLine = 13
+
+// Registry keeps all the tokens advertised to clients with RPL_ISUPPORT (005).
+// Features register their own tokens, the registry takes care of the format.
+type Registry struct {
+	tokens map[string]string
+	mutex  sync.Mutex
+}
+
+func New() *Registry {
+	r := &Registry{
+		tokens: make(map[string]string),
+	}
+
+	return r
+}
+
+// Register adds or replaces a token, an empty value advertises the bare name
+func (r *Registry) Register(name string, value string) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	r.tokens[strings.ToUpper(name)] = value
+}
+
+func (r *Registry) Unregister(name string) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	delete(r.tokens, strings.ToUpper(name))
+}
+
+func (r *Registry) Value(name string) (string, bool) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	v, exists := r.tokens[strings.ToUpper(name)]
+	return v, exists
+}
+
+// Tokens returns all the tokens in the wire format, sorted by name
+func (r *Registry) Tokens() []string {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	var names []string
+	for name := range r.tokens {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var tokens []string
+	for _, name := range names {
+		tokens = append(tokens, format(name, r.tokens[name]))
+	}
+
+	return tokens
+}
+
+// Replace swaps all the tokens for the given ones, and returns the tokens
+// which changed in the wire format, the removed ones as -<name>.
+func (r *Registry) Replace(tokens map[string]string) []string {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	old := r.tokens
+
+	r.tokens = make(map[string]string)
+	for name, value := range tokens {
+		r.tokens[strings.ToUpper(name)] = value
+	}
+
+	var changed []string
+
+	for name, value := range r.tokens {
+		if v, exists := old[name]; !exists || v != value {
+			changed = append(changed, format(name, value))
+		}
+	}
+
+	for name := range old {
+		if _, exists := r.tokens[name]; !exists {
+			changed = append(changed, "-"+name)
+		}
+	}
+
+	sort.Slice(changed, func(i, j int) bool {
+		return strings.TrimPrefix(changed[i], "-") < strings.TrimPrefix(changed[j], "-")
+	})
+
+	return changed
+}
+
+// Lines splits the tokens into chunks fitting in one RPL_ISUPPORT line each,
+// size is the room left for the tokens once the rest of the line is counted.
+func (r *Registry) Lines(size int) [][]string {
+	return Split(r.Tokens(), size)
+}
+
+// Split is Lines for any tokens in the wire format
+func Split(tokens []string, size int) [][]string {
+	var (
+		lines  [][]string
+		line   []string
+		length int
+	)
+
+	for _, token := range tokens {
+		if len(line) > 0 &&
+			(len(line) == MaxTokensPerLine || length+1+len(token) > size) {
+			lines = append(lines, line)
+			line = nil
+			length = 0
+		}
+
+		if len(line) > 0 {
+			length += 1
+		}
+		length += len(token)
+		line = append(line, token)
+	}
+
+	if len(line) > 0 {
+		lines = append(lines, line)
+	}
+
+	return lines
+}
+
+// ChannelModes returns all channel modes advertised by PREFIX and CHANMODES,
+// which is what RPL_MYINFO wants to show.
+func (r *Registry) ChannelModes() string {
+	var modes []string
+
+	prefix, _ := r.Value("PREFIX")
+	if strings.HasPrefix(prefix, "(") && strings.Index(prefix, ")") > 0 {
+		modes = append(modes, strings.Split(prefix[1:strings.Index(prefix, ")")], "")...)
+	}
+
+	chanModes, _ := r.Value("CHANMODES")
+	modes = append(modes, strings.Split(strings.Replace(chanModes, ",", "", -1), "")...)
+
+	sort.Strings(modes)
+
+	return strings.Join(modes, "")
+}
+
+func escape(s string) string {
+	s = strings.Replace(s, "\\", "\\x5C", -1)
+	s = strings.Replace(s, " ", "\\x20", -1)
+	s = strings.Replace(s, "=", "\\x3D", -1)
+
+	return s
+}
+
+func format(name string, value string) string {
+	if value == "" {
+		return name
+	}
+
+	return name + "=" + escape(value)
+}
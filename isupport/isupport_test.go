@@ -0,0 +1,85 @@
+package isupport
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestTokens(t *testing.T) {
+	r := New()
+	r.Register("network", "star fruit")
+	r.Register("CASEMAPPING", "ascii")
+	r.Register("EXCEPTS", "")
+
+	tokens := r.Tokens()
+	if len(tokens) != 3 {
+		t.Fatal("should have 3 tokens")
+	}
+
+	if tokens[0] != "CASEMAPPING=ascii" {
+		t.Error("tokens should be sorted by name")
+	}
+
+	if tokens[1] != "EXCEPTS" {
+		t.Error("token without value should be the bare name")
+	}
+
+	if tokens[2] != "NETWORK=star\\x20fruit" {
+		t.Error("space in value should be escaped")
+	}
+
+	r.Unregister("EXCEPTS")
+	if _, exists := r.Value("EXCEPTS"); exists {
+		t.Error("EXCEPTS should be unregistered")
+	}
+}
+
+func TestReplace(t *testing.T) {
+	r := New()
+	r.Register("NETWORK", "starfruit")
+	r.Register("MONITOR", "100")
+	r.Register("EXCEPTS", "")
+
+	changed := r.Replace(map[string]string{"network": "starfruit", "MONITOR": "50", "WHOX": ""})
+
+	if got := fmt.Sprint(changed); got != "[-EXCEPTS MONITOR=50 WHOX]" {
+		t.Errorf("got %s, want only the tokens changed", got)
+	}
+
+	if len(r.Tokens()) != 3 {
+		t.Error("tokens should be replaced")
+	}
+}
+
+func TestLines(t *testing.T) {
+	r := New()
+	for i := 0; i < 30; i++ {
+		r.Register(fmt.Sprintf("TOKEN%02d", i), "1")
+	}
+
+	lines := r.Lines(400)
+	if len(lines) != 3 {
+		t.Fatal("30 tokens should be split into 3 lines")
+	}
+
+	if len(lines[0]) != MaxTokensPerLine || len(lines[2]) != 4 {
+		t.Error("lines should carry at most 13 tokens")
+	}
+
+	lines = r.Lines(20)
+	for _, line := range lines {
+		if len(line) != 2 {
+			t.Error("lines should respect the size given")
+		}
+	}
+}
+
+func TestChannelModes(t *testing.T) {
+	r := New()
+	r.Register("PREFIX", "(ov)@+")
+	r.Register("CHANMODES", "b,k,l,imnpst")
+
+	if r.ChannelModes() != "biklmnopstv" {
+		t.Error("channel modes should be built from PREFIX and CHANMODES")
+	}
+}
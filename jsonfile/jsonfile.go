@@ -0,0 +1,61 @@
+/*
+ * Copyright 2014 The starfruit Authors. All rights reserved.
+ * Use of this source code is governed by a BSD-style
+ * license that can be found in the LICENSE file.
+ */
+
+// Package jsonfile reads and writes the files the stores keep their data in.
+package jsonfile
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+)
+
+// Load decodes a JSON file into v, which is left untouched if the file is
+// missing or its name empty.
+func Load(file string, v interface{}) error {
+	if file == "" {
+		return nil
+	}
+
+	data, err := ioutil.ReadFile(file)
+	if os.IsNotExist(err) {
+		return nil
+	}
+
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(data, v)
+}
+
+// Save writes v as indented JSON to a file, nothing is written if its name
+// is empty.
+func Save(file string, v interface{}) error {
+	if file == "" {
+		return nil
+	}
+
+	data, err := json.MarshalIndent(v, "", "\t")
+	if err != nil {
+		return err
+	}
+
+	return WriteFile(file, data)
+}
+
+// WriteFile writes to a temporary file first and renames it, so a crash
+// never leaves half a file.
+func WriteFile(file string, data []byte) error {
+	tmp := file + ".tmp"
+
+	err := ioutil.WriteFile(tmp, data, 0600)
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, file)
+}
@@ -0,0 +1,34 @@
+package jsonfile
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+func TestLoadSave(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "store.json")
+
+	v := []string{"untouched"}
+	if err := Load(file, &v); err != nil || len(v) != 1 {
+		t.Fatal("missing file should leave the value untouched")
+	}
+
+	if err := Save(file, []string{"a", "b"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := Load(file, &v); err != nil || len(v) != 2 || v[1] != "b" {
+		t.Errorf("got %v, want [a b]", v)
+	}
+
+	files, _ := ioutil.ReadDir(dir)
+	if len(files) != 1 {
+		t.Errorf("temporary file should be renamed, got %d files", len(files))
+	}
+
+	if err := Save("", v); err != nil {
+		t.Error("nothing should be written without a file name")
+	}
+}
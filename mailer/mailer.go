@@ -0,0 +1,195 @@
+/*
+ * Copyright 2014 The starfruit Authors. All rights reserved.
+ * Use of this source code is governed by a BSD-style
+ * license that can be found in the LICENSE file.
+ */
+
+package mailer
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"net"
+	"net/smtp"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+)
+
+var ErrUnknownTemplate = errors.New("Unknown email template")
+
+// Names of the templates emails are written with
+const (
+	TemplateVerify = "verify"
+	TemplateReset  = "reset"
+)
+
+// Templates start with their headers, the Subject at least, followed by an
+// empty line and the body.
+var defaultTemplates = map[string]string{
+	TemplateVerify: `Subject: Verify your {{.Network}} account {{.Account}}
+
+Hello,
+
+The account {{.Account}} was registered on {{.Network}} with this address.
+Verify it within {{.Minutes}} minutes by sending this command:
+
+/VERIFY {{.Account}} {{.Code}}
+
+If you didn't register it, just ignore this email.
+`,
+	TemplateReset: `Subject: Reset the password of your {{.Network}} account {{.Account}}
+
+Hello,
+
+Somebody asked to reset the password of the account {{.Account}} on
+{{.Network}}. Choose a new one within {{.Minutes}} minutes by sending this
+command:
+
+/msg NickServ RESETPASS {{.Account}} {{.Code}} <new password>
+
+If you didn't ask for it, just ignore this email.
+`,
+}
+
+// Data is given to the templates
+type Data struct {
+	Network string
+	Account string
+	Code    string
+	Minutes int // Time left to use the code
+}
+
+// Mailer sends the codes users need to manage their account through an
+// SMTP relay, an address is sent at most one email per interval.
+type Mailer struct {
+	Addr     string // Host and port of the relay
+	From     string
+	Auth     smtp.Auth // Nil if the relay needs no authentication
+	Interval time.Duration
+
+	templates map[string]*template.Template
+
+	mu   sync.Mutex
+	sent map[string]time.Time // Last email sent to every address
+}
+
+func New(addr string, from string, username string, password string, interval time.Duration) (*Mailer, error) {
+	m := &Mailer{
+		Addr:      addr,
+		From:      from,
+		Interval:  interval,
+		templates: make(map[string]*template.Template),
+		sent:      make(map[string]time.Time),
+	}
+
+	if username != "" {
+		host, _, err := net.SplitHostPort(addr)
+		if err != nil {
+			return nil, err
+		}
+
+		m.Auth = smtp.PlainAuth("", username, password, host)
+	}
+
+	for name, text := range defaultTemplates {
+		tmpl, err := template.New(name).Parse(text)
+		if err != nil {
+			return nil, err
+		}
+
+		m.templates[name] = tmpl
+	}
+
+	return m, nil
+}
+
+// LoadTemplate replaces a template by the content of a file
+func (m *Mailer) LoadTemplate(name string, file string) error {
+	if _, exists := m.templates[name]; !exists {
+		return ErrUnknownTemplate
+	}
+
+	text, err := ioutil.ReadFile(file)
+	if err != nil {
+		return err
+	}
+
+	tmpl, err := template.New(name).Parse(string(text))
+	if err != nil {
+		return err
+	}
+
+	m.templates[name] = tmpl
+
+	return nil
+}
+
+// Allow tells whether an email may be sent to an address, and counts it as
+// sent if so.
+func (m *Mailer) Allow(to string) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	key := strings.ToLower(to)
+
+	for addr, at := range m.sent {
+		if now.Sub(at) >= m.Interval {
+			delete(m.sent, addr)
+		}
+	}
+
+	if _, exists := m.sent[key]; exists {
+		return false
+	}
+
+	m.sent[key] = now
+
+	return true
+}
+
+// Send writes an email with a template and hands it to the relay
+func (m *Mailer) Send(to string, name string, data *Data) error {
+	msg, err := m.Compose(to, name, data)
+	if err != nil {
+		return err
+	}
+
+	return smtp.SendMail(m.Addr, m.Auth, m.From, []string{to}, msg)
+}
+
+// Compose returns the email Send would hand to the relay
+func (m *Mailer) Compose(to string, name string, data *Data) ([]byte, error) {
+	tmpl, exists := m.templates[name]
+	if !exists {
+		return nil, ErrUnknownTemplate
+	}
+
+	if strings.ContainsAny(to, "\r\n") {
+		return nil, errors.New("Invalid address " + to)
+	}
+
+	var text bytes.Buffer
+
+	err := tmpl.Execute(&text, data)
+	if err != nil {
+		return nil, err
+	}
+
+	var msg bytes.Buffer
+
+	msg.WriteString("From: " + m.From + "\r\n")
+	msg.WriteString("To: " + to + "\r\n")
+	msg.WriteString("Date: " + time.Now().Format(time.RFC1123Z) + "\r\n")
+	msg.WriteString("Content-Type: text/plain; charset=UTF-8\r\n")
+
+	lines := strings.Split(strings.TrimRight(text.String(), "\n"), "\n")
+	for _, line := range lines {
+		msg.WriteString(strings.TrimRight(line, "\r") + "\r\n")
+	}
+
+	return msg.Bytes(), nil
+}
@@ -0,0 +1,173 @@
+package mailer
+
+import (
+	"bufio"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// smtpStandIn accepts a single email and hands what it received over
+func smtpStandIn(t *testing.T) (string, chan string) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	received := make(chan string, 1)
+
+	go func() {
+		defer ln.Close()
+
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		r := bufio.NewReader(conn)
+		reply := func(line string) { conn.Write([]byte(line + "\r\n")) }
+
+		var session []string
+		inData := false
+
+		reply("220 localhost ESMTP")
+
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				return
+			}
+			line = strings.TrimRight(line, "\r\n")
+
+			if inData {
+				if line == "." {
+					inData = false
+					reply("250 Queued")
+					continue
+				}
+				session = append(session, line)
+				continue
+			}
+
+			session = append(session, line)
+
+			switch strings.ToUpper(strings.Fields(line + " x")[0]) {
+			case "EHLO", "HELO":
+				reply("250 localhost")
+			case "DATA":
+				inData = true
+				reply("354 Go ahead")
+			case "QUIT":
+				reply("221 Bye")
+				received <- strings.Join(session, "\n")
+				return
+			default:
+				reply("250 OK")
+			}
+		}
+	}()
+
+	return ln.Addr().String(), received
+}
+
+func TestSend(t *testing.T) {
+	addr, received := smtpStandIn(t)
+
+	m, err := New(addr, "services@konoha.org", "", "", time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = m.Send("lee@konoha.org", TemplateVerify, &Data{
+		Network: "Konoha",
+		Account: "RockLee",
+		Code:    "ABCD1234",
+		Minutes: 60,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case session := <-received:
+		for _, expected := range []string{
+			"MAIL FROM:<services@konoha.org>",
+			"RCPT TO:<lee@konoha.org>",
+			"To: lee@konoha.org",
+			"Subject: Verify your Konoha account RockLee",
+			"/VERIFY RockLee ABCD1234",
+		} {
+			if !strings.Contains(session, expected) {
+				t.Errorf("email should contain %q, got:\n%s", expected, session)
+			}
+		}
+
+	case <-time.After(5 * time.Second):
+		t.Fatal("email never reached the relay")
+	}
+}
+
+func TestLoadTemplate(t *testing.T) {
+	dir, err := ioutil.TempDir("", "starfruit")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	file := filepath.Join(dir, "reset.txt")
+	if err := ioutil.WriteFile(file, []byte("Subject: Reset\n\nCode {{.Code}} for {{.Account}}\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	m, err := New("localhost:25", "services@konoha.org", "", "", time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := m.LoadTemplate("welcome", file); err != ErrUnknownTemplate {
+		t.Error("only known templates should be replaced")
+	}
+
+	if err := m.LoadTemplate(TemplateReset, file); err != nil {
+		t.Fatal(err)
+	}
+
+	msg, err := m.Compose("lee@konoha.org", TemplateReset, &Data{Account: "RockLee", Code: "XYZ"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.HasSuffix(string(msg), "Subject: Reset\r\n\r\nCode XYZ for RockLee\r\n") {
+		t.Errorf("email should be written with the template, got %q", msg)
+	}
+
+	if _, err := m.Compose("lee@konoha.org\r\nBcc: all@konoha.org", TemplateReset, &Data{}); err == nil {
+		t.Error("headers should not be injected through the address")
+	}
+}
+
+func TestAllow(t *testing.T) {
+	m, err := New("localhost:25", "services@konoha.org", "", "", time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !m.Allow("lee@konoha.org") || !m.Allow("gai@konoha.org") {
+		t.Fatal("first email to an address should be allowed")
+	}
+
+	if m.Allow("LEE@konoha.org") {
+		t.Error("second email within the interval should be refused")
+	}
+
+	m.sent["lee@konoha.org"] = time.Now().Add(-2 * time.Hour)
+
+	if !m.Allow("lee@konoha.org") {
+		t.Error("email should be allowed once the interval is over")
+	}
+}
@@ -0,0 +1,64 @@
+/*
+ * Copyright 2014 The starfruit Authors. All rights reserved.
+ * Use of this source code is governed by a BSD-style
+ * license that can be found in the LICENSE file.
+ */
+
+package mask
+
+import (
+	"strings"
+)
+
+// Match tells whether s matches the mask, '*' matches any sequence of
+// characters and '?' matches exactly one, the case is ignored.
+func Match(mask string, s string) bool {
+	return match(strings.ToLower(mask), strings.ToLower(s))
+}
+
+func match(mask string, s string) bool {
+	// Position to restart from when the last '*' has to eat one more character
+	starIdx, sIdx := -1, 0
+
+	i, j := 0, 0
+	for j < len(s) {
+		if i < len(mask) && (mask[i] == '?' || mask[i] == s[j]) {
+			i++
+			j++
+		} else if i < len(mask) && mask[i] == '*' {
+			starIdx = i
+			sIdx = j
+			i++
+		} else if starIdx >= 0 {
+			i = starIdx + 1
+			sIdx++
+			j = sIdx
+		} else {
+			return false
+		}
+	}
+
+	for i < len(mask) && mask[i] == '*' {
+		i++
+	}
+
+	return i == len(mask)
+}
+
+// Normalize completes a partial mask such as "nick" or "*@host" into a
+// full nick!user@host mask.
+func Normalize(mask string) string {
+	if !strings.Contains(mask, "!") && !strings.Contains(mask, "@") {
+		return mask + "!*@*"
+	}
+
+	if !strings.Contains(mask, "!") {
+		return "*!" + mask
+	}
+
+	if !strings.Contains(mask, "@") {
+		return mask + "@*"
+	}
+
+	return mask
+}
@@ -0,0 +1,43 @@
+package mask
+
+import (
+	"testing"
+)
+
+func TestMatch(t *testing.T) {
+	cases := []struct {
+		mask    string
+		s       string
+		matched bool
+	}{
+		{"*!*@*", "rock!~rock@localhost", true},
+		{"rock!*@*", "Rock!~rock@localhost", true},
+		{"*!*@*.example.com", "rock!~rock@irc.example.com", true},
+		{"*!*@*.example.com", "rock!~rock@example.com", false},
+		{"r?ck!*", "rock!~rock@localhost", true},
+		{"r?ck!*", "rck!~rock@localhost", false},
+		{"*a*b", "xxaxxbxb", true},
+		{"", "", true},
+		{"", "a", false},
+	}
+
+	for _, c := range cases {
+		if Match(c.mask, c.s) != c.matched {
+			t.Errorf("Match(%q, %q) should be %v", c.mask, c.s, c.matched)
+		}
+	}
+}
+
+func TestNormalize(t *testing.T) {
+	if Normalize("rock") != "rock!*@*" {
+		t.Error("nick should be normalized to nick!*@*")
+	}
+
+	if Normalize("*@localhost") != "*!*@localhost" {
+		t.Error("user@host should be normalized to *!user@host")
+	}
+
+	if Normalize("rock!~rock") != "rock!~rock@*" {
+		t.Error("nick!user should be normalized to nick!user@*")
+	}
+}
@@ -12,6 +12,7 @@ const (
 	RPL_CREATED         = "003"
 	RPL_MYINFO          = "004"
 	RPL_BOUNCE          = "005"
+	RPL_ISUPPORT        = "005"
 	RPL_USERHOST        = "302"
 	RPL_ISON            = "303"
 	RPL_AWAY            = "301"
@@ -23,6 +24,7 @@ const (
 	RPL_WHOISIDLE       = "317"
 	RPL_ENDOFWHOIS      = "318"
 	RPL_WHOISCHANNELS   = "319"
+	RPL_WHOISACCOUNT    = "330"
 	RPL_WHOWASUSER      = "314"
 	RPL_ENDOFWHOWAS     = "369"
 	RPL_LISTSTART       = "321"
@@ -76,6 +78,9 @@ const (
 	RPL_TRACEEND        = "262"
 	RPL_STATSLINKINFO   = "211"
 	RPL_STATSCOMMANDS   = "212"
+	RPL_STATSKLINE      = "216"
+	RPL_STATSDLINE      = "225"
+	RPL_STATSGLINE      = "247"
 	RPL_ENDOFSTATS      = "219"
 	RPL_STATSUPTIME     = "242"
 	RPL_STATSOLINE      = "243"
@@ -102,11 +107,13 @@ const (
 	ERR_TOOMANYTARGETS    = "407"
 	ERR_NOSUCHSERVICE     = "408"
 	ERR_NOORIGIN          = "409"
+	ERR_INVALIDCAPCMD     = "410"
 	ERR_NORECIPIENT       = "411"
 	ERR_NOTEXTTOSEND      = "412"
 	ERR_NOTOPLEVEL        = "413"
 	ERR_WILDTOPLEVEL      = "414"
 	ERR_BADMASK           = "415"
+	ERR_INPUTTOOLONG      = "417"
 	ERR_UNKNOWNCOMMAND    = "421"
 	ERR_NOMOTD            = "422"
 	ERR_NOADMININFO       = "423"
@@ -146,4 +153,28 @@ const (
 	ERR_NOOPERHOST        = "491"
 	ERR_UMODEUNKNOWNFLAG  = "501"
 	ERR_USERSDONTMATCH    = "502"
+
+	RPL_MONONLINE    = "730"
+	RPL_MONOFFLINE   = "731"
+	RPL_MONLIST      = "732"
+	RPL_ENDOFMONLIST = "733"
+	ERR_MONLISTFULL  = "734"
+
+	ERR_NOPRIVS = "723"
+
+	RPL_KEYVALUE        = "761"
+	RPL_KEYNOTSET       = "766"
+	RPL_METADATASUBOK   = "770"
+	RPL_METADATAUNSUBOK = "771"
+	RPL_METADATASUBS    = "772"
+
+	RPL_LOGGEDIN    = "900"
+	RPL_LOGGEDOUT   = "901"
+	ERR_NICKLOCKED  = "902"
+	RPL_SASLSUCCESS = "903"
+	ERR_SASLFAIL    = "904"
+	ERR_SASLTOOLONG = "905"
+	ERR_SASLABORTED = "906"
+	ERR_SASLALREADY = "907"
+	RPL_SASLMECHS   = "908"
 )
@@ -7,14 +7,30 @@
 package message
 
 import (
+	"crypto/rand"
+	"encoding/base32"
 	"errors"
+	"sort"
 	"strings"
+	"time"
 )
 
 // Message Format
-// :<prefix> <command> <params> :<trailing>
+// @<tags> :<prefix> <command> <params> :<trailing>
+
+// Limits of the tags part, the leading '@' and the trailing space included
+const (
+	MaxClientTagsLength = 4096 // Tags a client is allowed to send
+	MaxTagsLength       = 8191 // Tags of any message, server tags included
+)
+
+// Format of the time tag, as defined by the server-time capability
+const TimeFormat = "2006-01-02T15:04:05.000Z"
+
+var ErrInputTooLong = errors.New("Input line was too long")
 
 type Message struct {
+	Tags     map[string]string
 	Prefix   string
 	Command  string
 	Params   []string
@@ -37,6 +53,22 @@ func Parse(s string) (*Message, error) {
 
 	s = strings.TrimSpace(s)
 
+	if strings.HasPrefix(s, "@") {
+		tagsEnd := strings.Index(s, " ")
+		if tagsEnd <= 1 {
+			err = errors.New("Malformed Tags Message")
+			goto ret
+		}
+
+		if tagsEnd+1 > MaxTagsLength {
+			err = ErrInputTooLong
+			goto ret
+		}
+
+		m.Tags = parseTags(s[1:tagsEnd])
+		s = strings.TrimLeft(s[tagsEnd+1:], " ")
+	}
+
 	if strings.HasPrefix(s, ":") {
 		prefixEnd = strings.Index(s, " ")
 		if prefixEnd-1 <= 0 {
@@ -70,6 +102,19 @@ ret:
 	return m, err
 }
 
+// ParseClient parses a message sent by a client, which is allowed to carry
+// less tags than a message relayed by the server.
+func ParseClient(s string) (*Message, error) {
+	if strings.HasPrefix(s, "@") {
+		tagsEnd := strings.Index(s, " ")
+		if tagsEnd+1 > MaxClientTagsLength {
+			return nil, ErrInputTooLong
+		}
+	}
+
+	return Parse(s)
+}
+
 func New(prefix interface{}, command string, params []string, trailing interface{}) *Message {
 	m := &Message{
 		Command: command,
@@ -100,7 +145,15 @@ func New(prefix interface{}, command string, params []string, trailing interface
 func (m *Message) String() string {
 	var s string
 
+	if len(m.Tags) > 0 {
+		s += "@"
+		s += m.tagsString()
+	}
+
 	if m.Prefix != "" {
+		if s != "" {
+			s += " "
+		}
 		s += ":"
 		s += m.Prefix
 	}
@@ -151,3 +204,169 @@ func (m *Message) HasPrefix() bool {
 func (m *Message) SetHasPrefix(b bool) {
 	m.hasPrefix = b
 }
+
+func (m *Message) Tag(key string) (string, bool) {
+	v, exists := m.Tags[key]
+	return v, exists
+}
+
+func (m *Message) SetTag(key string, value string) {
+	if m.Tags == nil {
+		m.Tags = make(map[string]string)
+	}
+
+	m.Tags[key] = value
+}
+
+func (m *Message) DeleteTag(key string) {
+	delete(m.Tags, key)
+}
+
+// WithTag returns a copy of this message with one more tag, the message
+// itself is never changed since it may be shared by recipients.
+func (m *Message) WithTag(key string, value string) *Message {
+	tagged := m.FilterTags(func(string) bool {
+		return true
+	})
+	tagged.SetTag(key, value)
+
+	return tagged
+}
+
+// Stamp tags a message relayed by the server with the time it was received
+// and an unique id, which clients use to order and deduplicate messages.
+func (m *Message) Stamp() {
+	m.SetTag("time", time.Now().UTC().Format(TimeFormat))
+	m.SetTag("msgid", NewMsgId())
+}
+
+func NewMsgId() string {
+	buf := make([]byte, 16)
+	rand.Read(buf)
+
+	return strings.ToLower(strings.TrimRight(base32.StdEncoding.EncodeToString(buf), "="))
+}
+
+// ClientTags returns the client-only tags, which are prefixed with '+'
+func (m *Message) ClientTags() map[string]string {
+	var tags map[string]string
+
+	for k, v := range m.Tags {
+		if IsClientTag(k) {
+			if tags == nil {
+				tags = make(map[string]string)
+			}
+			tags[k] = v
+		}
+	}
+
+	return tags
+}
+
+// FilterTags returns a copy of this message only carrying the tags allowed,
+// the message itself is never changed since it may be shared by recipients.
+func (m *Message) FilterTags(allowed func(key string) bool) *Message {
+	filtered := *m
+	filtered.Tags = nil
+
+	for k, v := range m.Tags {
+		if allowed(k) {
+			filtered.SetTag(k, v)
+		}
+	}
+
+	return &filtered
+}
+
+func (m *Message) tagsString() string {
+	var keys []string
+	for k := range m.Tags {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var tags []string
+	for _, k := range keys {
+		if m.Tags[k] == "" {
+			tags = append(tags, k)
+		} else {
+			tags = append(tags, k+"="+EscapeTagValue(m.Tags[k]))
+		}
+	}
+
+	return strings.Join(tags, ";")
+}
+
+func IsClientTag(key string) bool {
+	return strings.HasPrefix(key, "+")
+}
+
+func parseTags(s string) map[string]string {
+	tags := make(map[string]string)
+
+	for _, tag := range strings.Split(s, ";") {
+		if tag == "" {
+			continue
+		}
+
+		kv := strings.SplitN(tag, "=", 2)
+		if len(kv) == 1 {
+			tags[kv[0]] = ""
+		} else {
+			tags[kv[0]] = UnescapeTagValue(kv[1])
+		}
+	}
+
+	return tags
+}
+
+var tagValueEscaper = strings.NewReplacer(
+	"\\", "\\\\",
+	";", "\\:",
+	" ", "\\s",
+	"\r", "\\r",
+	"\n", "\\n",
+)
+
+func EscapeTagValue(s string) string {
+	return tagValueEscaper.Replace(s)
+}
+
+func UnescapeTagValue(s string) string {
+	var (
+		buf     []byte
+		escaped bool
+	)
+
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+
+		if !escaped {
+			if c == '\\' {
+				escaped = true
+			} else {
+				buf = append(buf, c)
+			}
+
+			continue
+		}
+
+		escaped = false
+
+		switch c {
+		case ':':
+			buf = append(buf, ';')
+		case 's':
+			buf = append(buf, ' ')
+		case 'r':
+			buf = append(buf, '\r')
+		case 'n':
+			buf = append(buf, '\n')
+		default:
+			// Both "\\" and invalid escapes give the character itself
+			buf = append(buf, c)
+		}
+	}
+
+	return string(buf)
+}
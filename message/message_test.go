@@ -7,7 +7,9 @@
 package message
 
 import (
+	"strings"
 	"testing"
+	"time"
 )
 
 func TestMessage1(t *testing.T) {
@@ -101,3 +103,116 @@ func TestMessage3(t *testing.T) {
 		t.Error("should has param1, param2")
 	}
 }
+
+func TestMessageTags(t *testing.T) {
+	line := "@time=2014-01-01T00:00:00.000Z;+example.com/typing=active;flag :Rock!rock@localhost PRIVMSG #abord :hi"
+
+	m, err := Parse(line)
+	if err != nil {
+		t.Error("error happened")
+	}
+
+	if m.Prefix != "Rock!rock@localhost" || m.Command != "PRIVMSG" {
+		t.Error("Prefix and command should be parsed after the tags")
+	}
+
+	if v, _ := m.Tag("time"); v != "2014-01-01T00:00:00.000Z" {
+		t.Error("time tag parsed error")
+	}
+
+	if v, exists := m.Tag("flag"); !exists || v != "" {
+		t.Error("tag without value should be present with empty value")
+	}
+
+	clientTags := m.ClientTags()
+	if len(clientTags) != 1 || clientTags["+example.com/typing"] != "active" {
+		t.Error("should have one client-only tag")
+	}
+
+	if m.String() != "@+example.com/typing=active;flag;time=2014-01-01T00:00:00.000Z :Rock!rock@localhost PRIVMSG #abord :hi" {
+		t.Error("tags should be serialized sorted by key")
+	}
+}
+
+func TestMessageTagsEscaping(t *testing.T) {
+	value := "a;b c\\d\r\n"
+
+	m := New("server", "NOTICE", []string{"*"}, "hi")
+	m.SetTag("note", value)
+
+	if m.String() != "@note=a\\:b\\sc\\\\d\\r\\n :server NOTICE * :hi" {
+		t.Error("tag value escaped error")
+	}
+
+	parsed, err := Parse(m.String())
+	if err != nil {
+		t.Error("error happened")
+	}
+
+	if v, _ := parsed.Tag("note"); v != value {
+		t.Error("tag value unescaped error")
+	}
+
+	if UnescapeTagValue("a\\bc\\") != "abc" {
+		t.Error("invalid escapes and trailing backslash should be dropped")
+	}
+}
+
+func TestMessageTagsLimit(t *testing.T) {
+	line := "@+tag=" + strings.Repeat("a", MaxClientTagsLength) + " PRIVMSG #abord :hi"
+
+	if _, err := ParseClient(line); err != ErrInputTooLong {
+		t.Error("client tags longer than 4096 bytes should be rejected")
+	}
+
+	if _, err := Parse(line); err != nil {
+		t.Error("server tags up to 8191 bytes should be accepted")
+	}
+
+	line = "@+tag=" + strings.Repeat("a", MaxTagsLength) + " PRIVMSG #abord :hi"
+
+	if _, err := Parse(line); err != ErrInputTooLong {
+		t.Error("tags longer than 8191 bytes should be rejected")
+	}
+}
+
+func TestMessageFilterTags(t *testing.T) {
+	m := New("server", "NOTICE", []string{"*"}, "hi")
+	m.SetTag("time", "2014-01-01T00:00:00.000Z")
+	m.SetTag("+typing", "active")
+
+	filtered := m.FilterTags(func(key string) bool {
+		return !IsClientTag(key)
+	})
+
+	if len(filtered.Tags) != 1 {
+		t.Error("client-only tag should be filtered")
+	}
+
+	if len(m.Tags) != 2 {
+		t.Error("original message should not be changed")
+	}
+
+	filtered = m.FilterTags(func(key string) bool {
+		return false
+	})
+
+	if filtered.String() != ":server NOTICE * :hi" {
+		t.Error("message without tags should not start with '@'")
+	}
+}
+
+func TestMessageStamp(t *testing.T) {
+	m := New("server", "NOTICE", []string{"*"}, "hi")
+	m.Stamp()
+
+	v, _ := m.Tag("time")
+	if _, err := time.Parse(TimeFormat, v); err != nil {
+		t.Error("time tag should follow the server-time format")
+	}
+
+	id, _ := m.Tag("msgid")
+	if id == "" || id == NewMsgId() {
+		t.Error("msgid should be unique")
+	}
+}
@@ -0,0 +1,139 @@
+/*
+ * Copyright 2014 The starfruit Authors. All rights reserved.
+ * Use of this source code is governed by a BSD-style
+ * license that can be found in the LICENSE file.
+ */
+
+package metadata
+
+import (
+	"sort"
+	"sync"
+)
+
+// Visibility of the keys everyone may see
+const Public = "*"
+
+// Map keeps the metadata of a user or a channel
+type Map struct {
+	values map[string]string
+	mutex  sync.Mutex
+}
+
+func New() *Map {
+	m := &Map{
+		values: make(map[string]string),
+	}
+
+	return m
+}
+
+func (m *Map) Get(key string) (string, bool) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	v, exists := m.values[key]
+	return v, exists
+}
+
+func (m *Map) Set(key string, value string) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	m.values[key] = value
+}
+
+// Delete removes a key, it returns false if the key was not set
+func (m *Map) Delete(key string) bool {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	_, exists := m.values[key]
+	delete(m.values, key)
+
+	return exists
+}
+
+// Clear removes all keys and returns them, sorted
+func (m *Map) Clear() []string {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	keys := m.keys()
+	m.values = make(map[string]string)
+
+	return keys
+}
+
+// Keys returns all the keys set, sorted
+func (m *Map) Keys() []string {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	return m.keys()
+}
+
+func (m *Map) keys() []string {
+	var keys []string
+	for k := range m.values {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	return keys
+}
+
+func (m *Map) Len() int {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	return len(m.values)
+}
+
+// All returns a copy of all the metadata, nil if there is none
+func (m *Map) All() map[string]string {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if len(m.values) == 0 {
+		return nil
+	}
+
+	values := make(map[string]string)
+	for k, v := range m.values {
+		values[k] = v
+	}
+
+	return values
+}
+
+// Load replaces all the metadata with the given one
+func (m *Map) Load(values map[string]string) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	m.values = make(map[string]string)
+	for k, v := range values {
+		m.values[k] = v
+	}
+}
+
+// ValidKey tells whether a key only has the characters allowed, which are
+// lowercase letters, digits and "_./-".
+func ValidKey(key string) bool {
+	if key == "" {
+		return false
+	}
+
+	for _, c := range key {
+		switch {
+		case c >= 'a' && c <= 'z':
+		case c >= '0' && c <= '9':
+		case c == '_', c == '.', c == '/', c == '-':
+		default:
+			return false
+		}
+	}
+
+	return true
+}
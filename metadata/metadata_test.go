@@ -0,0 +1,47 @@
+package metadata
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestMap(t *testing.T) {
+	m := New()
+
+	m.Set("pronouns", "they/them")
+	m.Set("avatar", "https://example.com/a.png")
+
+	if v, exists := m.Get("pronouns"); !exists || v != "they/them" {
+		t.Error("key should be set")
+	}
+
+	if keys := strings.Join(m.Keys(), ","); keys != "avatar,pronouns" {
+		t.Errorf("keys should be sorted, got %s", keys)
+	}
+
+	if !m.Delete("avatar") || m.Delete("avatar") {
+		t.Error("key should be deleted once")
+	}
+
+	if cleared := m.Clear(); len(cleared) != 1 || m.Len() != 0 {
+		t.Error("all keys should be cleared")
+	}
+
+	if m.All() != nil {
+		t.Error("empty metadata should give nil")
+	}
+}
+
+func TestValidKey(t *testing.T) {
+	for _, key := range []string{"avatar", "display-name", "chat/url", "x_1.2"} {
+		if !ValidKey(key) {
+			t.Errorf("key %q should be valid", key)
+		}
+	}
+
+	for _, key := range []string{"", "Avatar", "with space", "a=b", "é"} {
+		if ValidKey(key) {
+			t.Errorf("key %q should be invalid", key)
+		}
+	}
+}
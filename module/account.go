@@ -0,0 +1,110 @@
+/*
+ * Copyright 2014 The starfruit Authors. All rights reserved.
+ * Use of this source code is governed by a BSD-style
+ * license that can be found in the LICENSE file.
+ */
+
+package module
+
+import (
+	"fmt"
+	"github.com/flatpeach/starfruit/auth"
+	"github.com/flatpeach/starfruit/capability"
+	"github.com/flatpeach/starfruit/message"
+	"github.com/flatpeach/starfruit/server"
+	"github.com/flatpeach/starfruit/user"
+	"strings"
+)
+
+// Accounts named by other backends than the accounts of this server are
+// suffixed with the backend, local accounts are named after nicks and can't
+// hold the separator so they are never taken for one another.
+const externalSeparator = "@"
+
+// accountName names the account a backend authenticated a user as
+func accountName(backend string, name string) string {
+	if backend == auth.BackendAccounts {
+		return name
+	}
+
+	return name + externalSeparator + backend
+}
+
+// isExternal tells whether an account was named by another backend, such
+// accounts aren't kept by this server. ChanServ grants them access on their
+// name alone, nothing tells whether they exist, and they can't hold memos.
+func isExternal(name string) bool {
+	return strings.Contains(name, externalSeparator)
+}
+
+// logIn attaches an account to the user, channel neighbours who negotiated
+// account-notify are told about it.
+func logIn(s *server.Server, u *user.User, name string) {
+	nickName := u.NickName
+	if nickName == "" {
+		nickName = "*"
+	}
+
+	u.SetAccount(name)
+
+	a, err := s.Accounts.Get(name)
+
+	// Metadata is kept with the account, whatever was set before is dropped
+	if err == nil && a.Metadata != nil {
+		u.Metadata.Load(a.Metadata)
+	}
+
+	u.SendMessage(message.New(
+		s.Config.Server.Name,
+		message.RPL_LOGGEDIN,
+		[]string{
+			nickName,
+			u.Full(),
+			name,
+		},
+		fmt.Sprintf("You are now logged in as %s", name),
+	))
+
+	if err == nil {
+		notifyMemos(s, u, a)
+	}
+
+	if u.IsRegistered() {
+		s.NotifyNeighbours(u.Id, capability.AccountNotify, message.New(
+			u.Full(),
+			"ACCOUNT",
+			[]string{name},
+			nil,
+		))
+	}
+}
+
+// logOut detaches the account from the user, channel neighbours who
+// negotiated account-notify are told about it.
+func logOut(s *server.Server, u *user.User) {
+	if !u.IsLoggedIn() {
+		return
+	}
+
+	u.SetAccount("")
+
+	u.SendMessage(message.New(
+		s.Config.Server.Name,
+		message.RPL_LOGGEDOUT,
+		[]string{
+			u.NickName,
+			u.Full(),
+		},
+		"You are now logged out",
+	))
+
+	s.NotifyNeighbours(u.Id, capability.AccountNotify, message.New(
+		u.Full(),
+		"ACCOUNT",
+		[]string{"*"},
+		nil,
+	))
+
+	// His nick may be owned by the account he left
+	enforceNickName(s, u)
+}
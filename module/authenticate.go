@@ -0,0 +1,298 @@
+/*
+ * Copyright 2014 The starfruit Authors. All rights reserved.
+ * Use of this source code is governed by a BSD-style
+ * license that can be found in the LICENSE file.
+ */
+
+package module
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"github.com/flatpeach/starfruit/capability"
+	"github.com/flatpeach/starfruit/casemapping"
+	"github.com/flatpeach/starfruit/message"
+	"github.com/flatpeach/starfruit/server"
+	"github.com/flatpeach/starfruit/user"
+	"sort"
+	"strings"
+)
+
+const (
+	saslChunkSize = 400  // Responses are sent in chunks of 400 bytes
+	saslMaxLength = 8192 // Longest response accepted, still base64 encoded
+
+	backendOAuth = "oauth" // Suffix of the accounts named by OAUTHBEARER tokens
+)
+
+var errSaslFailed = errors.New("SASL authentication failed")
+
+// A SASL mechanism checks the decoded response of the client and returns
+// the name of the account the user is authenticated as.
+type saslMechanism func(s *server.Server, u *user.User, response []byte) (string, error)
+
+var saslMechanisms = map[string]saslMechanism{
+	"PLAIN":       saslPlain,
+	"EXTERNAL":    saslExternal,
+	"OAUTHBEARER": saslOauthbearer,
+}
+
+// SaslMechanisms returns the name of the SASL mechanisms the server is set
+// up for, sorted
+func SaslMechanisms(s *server.Server) []string {
+	var names []string
+	for name := range saslMechanisms {
+		if saslAvailable(s, name) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+func saslAvailable(s *server.Server, mechanism string) bool {
+	if _, exists := saslMechanisms[mechanism]; !exists {
+		return false
+	}
+
+	return mechanism != "OAUTHBEARER" || s.Tokens != nil
+}
+
+type Authenticate struct{}
+
+func (module *Authenticate) Handle(s *server.Server, u *user.User, m *message.Message) error {
+	// AUTHENTICATE <mechanism> / <base64 response> / "*"
+
+	if len(m.Params) == 0 {
+		u.SendErrorNeedMoreParams("AUTHENTICATE")
+		return nil
+	}
+
+	nickName := u.NickName
+	if nickName == "" {
+		nickName = "*"
+	}
+
+	if !u.HasCap(capability.Sasl) {
+		sendSaslFail(s, u, nickName)
+		return nil
+	}
+
+	if u.IsLoggedIn() {
+		u.SendMessage(message.New(
+			s.Config.Server.Name,
+			message.ERR_SASLALREADY,
+			[]string{nickName},
+			"You have already authenticated using SASL",
+		))
+
+		return nil
+	}
+
+	param := m.Params[0]
+
+	if param == "*" {
+		u.Sasl = nil
+
+		u.SendMessage(message.New(
+			s.Config.Server.Name,
+			message.ERR_SASLABORTED,
+			[]string{nickName},
+			"SASL authentication aborted",
+		))
+
+		return nil
+	}
+
+	if u.Sasl == nil {
+		mechanism := strings.ToUpper(param)
+
+		if !saslAvailable(s, mechanism) {
+			u.SendMessage(message.New(
+				s.Config.Server.Name,
+				message.RPL_SASLMECHS,
+				[]string{
+					nickName,
+					strings.Join(SaslMechanisms(s), ","),
+				},
+				"are available SASL mechanisms",
+			))
+
+			sendSaslFail(s, u, nickName)
+
+			return nil
+		}
+
+		u.Sasl = &user.SaslSession{Mechanism: mechanism}
+
+		u.SendMessage(message.New(
+			nil,
+			"AUTHENTICATE",
+			[]string{"+"},
+			nil,
+		))
+
+		return nil
+	}
+
+	if len(param) > saslChunkSize || len(u.Sasl.Buffer)+len(param) > saslMaxLength {
+		u.Sasl = nil
+
+		u.SendMessage(message.New(
+			s.Config.Server.Name,
+			message.ERR_SASLTOOLONG,
+			[]string{nickName},
+			"SASL message too long",
+		))
+
+		return nil
+	}
+
+	if param != "+" {
+		u.Sasl.Buffer += param
+	}
+
+	if len(param) == saslChunkSize {
+		// Wait for the rest of the response
+		return nil
+	}
+
+	mechanism := saslMechanisms[u.Sasl.Mechanism]
+	buffer := u.Sasl.Buffer
+	u.Sasl = nil
+
+	response, err := base64.StdEncoding.DecodeString(buffer)
+	if err != nil {
+		sendSaslFail(s, u, nickName)
+		return nil
+	}
+
+	name, err := mechanism(s, u, response)
+	if err != nil {
+		sendSaslFail(s, u, nickName)
+		return nil
+	}
+
+	logIn(s, u, name)
+
+	u.SendMessage(message.New(
+		s.Config.Server.Name,
+		message.RPL_SASLSUCCESS,
+		[]string{nickName},
+		"SASL authentication successful",
+	))
+
+	return nil
+}
+
+func sendSaslFail(s *server.Server, u *user.User, nickName string) {
+	u.SendMessage(message.New(
+		s.Config.Server.Name,
+		message.ERR_SASLFAIL,
+		[]string{nickName},
+		"SASL authentication failed",
+	))
+}
+
+func saslPlain(s *server.Server, u *user.User, response []byte) (string, error) {
+	// <authzid> NUL <authcid> NUL <passwd>
+
+	fields := bytes.Split(response, []byte{0})
+	if len(fields) != 3 {
+		return "", errSaslFailed
+	}
+
+	authzid, authcid, password := string(fields[0]), string(fields[1]), string(fields[2])
+
+	if authzid != "" && !casemapping.Equal(authzid, authcid) {
+		return "", errSaslFailed
+	}
+
+	name, err := s.SaslAuth.Authenticate(authcid, password)
+	if err != nil || name == "" {
+		return "", errSaslFailed
+	}
+
+	return accountName(s.Config.Auth.Sasl, name), nil
+}
+
+func saslExternal(s *server.Server, u *user.User, response []byte) (string, error) {
+	// [ <authzid> ], the account is found by the client certificate
+
+	fp := u.CertFingerprint()
+	if fp == "" {
+		return "", errSaslFailed
+	}
+
+	a, err := s.Accounts.GetByCertfp(fp)
+	if err != nil {
+		return "", errSaslFailed
+	}
+
+	if !a.IsVerified() {
+		return "", errSaslFailed
+	}
+
+	if len(response) > 0 && !casemapping.Equal(string(response), a.Name) {
+		return "", errSaslFailed
+	}
+
+	return a.Name, nil
+}
+
+func saslOauthbearer(s *server.Server, u *user.User, response []byte) (string, error) {
+	// <gs2 header> ^A *( <key> "=" <value> ^A ) ^A, as in RFC 7628
+
+	fields := strings.Split(string(response), "\x01")
+	if len(fields) < 3 || fields[len(fields)-1] != "" || fields[len(fields)-2] != "" {
+		return "", errSaslFailed
+	}
+
+	// n,[a=<authzid>], channel binding isn't supported
+	gs2 := strings.Split(fields[0], ",")
+	if len(gs2) != 3 || (gs2[0] != "n" && gs2[0] != "y") || gs2[2] != "" {
+		return "", errSaslFailed
+	}
+
+	authzid := ""
+	if gs2[1] != "" {
+		if !strings.HasPrefix(gs2[1], "a=") {
+			return "", errSaslFailed
+		}
+
+		authzid = strings.NewReplacer("=2C", ",", "=3D", "=").Replace(gs2[1][2:])
+	}
+
+	token := ""
+	for _, kv := range fields[1 : len(fields)-2] {
+		if strings.HasPrefix(kv, "auth=") {
+			scheme := strings.SplitN(kv[len("auth="):], " ", 2)
+			if len(scheme) == 2 && strings.EqualFold(scheme[0], "Bearer") {
+				token = scheme[1]
+			}
+		}
+	}
+
+	if token == "" {
+		return "", errSaslFailed
+	}
+
+	// A rehash may have turned OAUTHBEARER off since the exchange began
+	tokens := s.Tokens
+	if tokens == nil {
+		return "", errSaslFailed
+	}
+
+	name, err := tokens.Validate(token)
+	if err != nil {
+		return "", errSaslFailed
+	}
+
+	if authzid != "" && !casemapping.Equal(authzid, name) {
+		return "", errSaslFailed
+	}
+
+	return accountName(backendOAuth, name), nil
+}
@@ -7,6 +7,7 @@
 package module
 
 import (
+	"github.com/flatpeach/starfruit/capability"
 	"github.com/flatpeach/starfruit/message"
 	"github.com/flatpeach/starfruit/server"
 	"github.com/flatpeach/starfruit/user"
@@ -42,5 +43,21 @@ func (module *Away) Handle(s *server.Server, u *user.User, m *message.Message) e
 		u.MarkAway(true)
 	}
 
+	if u.AwayMsg() == "" {
+		s.NotifyNeighbours(u.Id, capability.AwayNotify, message.New(
+			u.Full(),
+			"AWAY",
+			nil,
+			nil,
+		))
+	} else {
+		s.NotifyNeighbours(u.Id, capability.AwayNotify, message.New(
+			u.Full(),
+			"AWAY",
+			nil,
+			u.AwayMsg(),
+		))
+	}
+
 	return nil
 }
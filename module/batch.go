@@ -0,0 +1,307 @@
+/*
+ * Copyright 2014 The starfruit Authors. All rights reserved.
+ * Use of this source code is governed by a BSD-style
+ * license that can be found in the LICENSE file.
+ */
+
+package module
+
+import (
+	"github.com/flatpeach/starfruit/capability"
+	"github.com/flatpeach/starfruit/casemapping"
+	"github.com/flatpeach/starfruit/message"
+	"github.com/flatpeach/starfruit/server"
+	"github.com/flatpeach/starfruit/user"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Tag of multiline messages to be joined to the previous one without a newline
+const multilineConcat = "draft/multiline-concat"
+
+type Batch struct{}
+
+func (module *Batch) Handle(s *server.Server, u *user.User, m *message.Message) error {
+	// BATCH +<reference> <type> *( <parameter> )
+	// BATCH -<reference>
+
+	if len(m.Params) == 0 || len(m.Params[0]) < 2 {
+		u.SendFail("BATCH", "NEED_MORE_PARAMS", nil, "Not enough parameters")
+		return nil
+	}
+
+	ref := m.Params[0][1:]
+
+	switch m.Params[0][0] {
+	case '+':
+		openBatch(s, u, m, ref)
+
+	case '-':
+		b := u.Batch
+		if b == nil || b.Ref != ref {
+			u.SendFail("BATCH", "MULTILINE_INVALID", nil, "No such batch")
+			return nil
+		}
+
+		u.Batch = nil
+
+		if b.Failed {
+			return nil
+		}
+
+		// The label of the opening BATCH applies to the whole batch
+		if b.Label != "" && u.HasCap(capability.LabeledResponse) {
+			u.StartCollecting()
+			relayMultiline(s, u, b)
+			u.SendLabeledResponse(b.Label, u.StopCollecting())
+		} else {
+			relayMultiline(s, u, b)
+		}
+
+	default:
+		u.SendFail("BATCH", "MULTILINE_INVALID", nil, "Invalid batch reference")
+	}
+
+	return nil
+}
+
+// OpensBatch tells whether a message opens a batch, whose label is only
+// replied to once the batch ends.
+func OpensBatch(m *message.Message) bool {
+	return m.Command == "BATCH" && len(m.Params) > 0 && strings.HasPrefix(m.Params[0], "+")
+}
+
+func openBatch(s *server.Server, u *user.User, m *message.Message, ref string) {
+	if u.Batch != nil {
+		u.SendFail("BATCH", "MULTILINE_INVALID", nil, "Batches can't be nested")
+		return
+	}
+
+	if len(m.Params) < 2 {
+		u.SendFail("BATCH", "NEED_MORE_PARAMS", nil, "Not enough parameters")
+		return
+	}
+
+	batchType := m.Params[1]
+	if batchType != capability.Multiline || !u.HasCap(capability.Multiline) {
+		u.SendFail("BATCH", "UNKNOWN_TYPE", []string{batchType}, "Unsupported batch type")
+		return
+	}
+
+	if len(m.Params) < 3 {
+		u.SendFail("BATCH", "MULTILINE_INVALID_TARGET", nil, "No target given")
+		return
+	}
+
+	u.Batch = &user.ClientBatch{
+		Ref:    ref,
+		Type:   batchType,
+		Params: m.Params[2:],
+	}
+
+	u.Batch.Label, _ = m.Tag("label")
+}
+
+// AddToBatch holds back a message the client sent as part of a batch, it
+// returns false if the message does not belong to the batch being sent.
+func AddToBatch(s *server.Server, u *user.User, m *message.Message) bool {
+	b := u.Batch
+	if b == nil {
+		return false
+	}
+
+	if ref, _ := m.Tag("batch"); ref != b.Ref {
+		return false
+	}
+
+	if b.Failed {
+		return true
+	}
+
+	cf := s.Config.Multiline
+
+	fail := func(code string, context []string, description string) bool {
+		b.Failed = true
+		u.SendFail("BATCH", code, context, description)
+
+		return true
+	}
+
+	if m.Command != "PRIVMSG" && m.Command != "NOTICE" {
+		return fail("MULTILINE_INVALID", nil, "Only PRIVMSG and NOTICE are allowed")
+	}
+
+	if len(b.Msgs) > 0 && m.Command != b.Msgs[0].Command {
+		return fail("MULTILINE_INVALID", nil, "All lines must be of the same command")
+	}
+
+	if len(m.Params) < 2 {
+		return fail("MULTILINE_INVALID", nil, "No text to send")
+	}
+
+	if !casemapping.Equal(m.Params[0], b.Params[0]) {
+		return fail("MULTILINE_INVALID_TARGET", []string{b.Params[0], m.Params[0]}, "Target differs from the batch one")
+	}
+
+	text := m.Params[1]
+
+	if _, concat := m.Tag(multilineConcat); concat && text == "" {
+		return fail("MULTILINE_INVALID", nil, "Concatenated lines can't be blank")
+	}
+
+	b.Msgs = append(b.Msgs, m)
+	b.Bytes += len(text)
+
+	// Lines not concatenated are joined to the previous one by a newline
+	if _, concat := m.Tag(multilineConcat); !concat && len(b.Msgs) > 1 {
+		b.Bytes++
+	}
+
+	if len(b.Msgs) > cf.MaxLines {
+		return fail("MULTILINE_MAX_LINES", []string{strconv.Itoa(cf.MaxLines)}, "Too many lines")
+	}
+
+	if b.Bytes > cf.MaxBytes {
+		return fail("MULTILINE_MAX_BYTES", []string{strconv.Itoa(cf.MaxBytes)}, "Too many bytes")
+	}
+
+	return true
+}
+
+// relayMultiline delivers the lines of a multiline batch as a whole to the
+// users who negotiated draft/multiline, line by line to the others.
+func relayMultiline(s *server.Server, u *user.User, b *user.ClientBatch) {
+	if len(b.Msgs) == 0 {
+		return
+	}
+
+	var (
+		notice     = b.Msgs[0].Command == "NOTICE"
+		target     = b.Params[0]
+		recipients []*user.User
+		lines      []*message.Message
+		fallback   []*message.Message
+	)
+
+	svc, targetUser, cnl := findMessageTarget(s, u, b.Msgs[0].Command, target)
+
+	switch {
+	case svc != nil:
+		// Services only take commands, and never reply to a notice
+		if !notice {
+			for _, text := range multilineText(b) {
+				svc.handle(s, u, text)
+			}
+		}
+
+		return
+
+	case targetUser != nil:
+		target = targetUser.NickName
+		recipients = []*user.User{targetUser}
+
+	case cnl != nil:
+		target = cnl.String()
+		recipients = s.GetJoinedUsers(cnl.Id)
+
+	default:
+		return
+	}
+
+	// The whole batch shares a single time, each fallback line has its own
+	// id but the first one has the id of the batch, so the message is found
+	// in the history by the id multiline clients saw.
+	now := time.Now().UTC().Format(message.TimeFormat)
+	msgId := message.NewMsgId()
+
+	for _, m := range b.Msgs {
+		line := message.New(u.Full(), m.Command, []string{target}, m.Params[1])
+		relayClientTags(s, m, line)
+
+		if _, concat := m.Tag(multilineConcat); concat {
+			line.SetTag(multilineConcat, "")
+		}
+
+		lines = append(lines, line)
+
+		single := line.FilterTags(func(tag string) bool {
+			return tag != multilineConcat
+		})
+		single.SetTag("time", now)
+		if len(fallback) == 0 {
+			single.SetTag("msgid", msgId)
+		} else {
+			single.SetTag("msgid", message.NewMsgId())
+		}
+
+		fallback = append(fallback, single)
+	}
+
+	for _, line := range fallback {
+		if targetUser != nil {
+			addDirectHistory(s, u, targetUser, line)
+		} else {
+			addHistory(s, u, target, line)
+		}
+	}
+
+	tags := map[string]string{
+		"time":  now,
+		"msgid": msgId,
+	}
+
+	// The sender gets the echo even when not on the channel
+	echo := u.HasCap(capability.EchoMessage)
+	if echo && !containsUser(recipients, u) {
+		recipients = append(recipients, u)
+	}
+
+	for _, recipient := range recipients {
+		if recipient.Id == u.Id && !echo {
+			continue
+		}
+
+		send, sendBatch := recipient.Deliver, recipient.DeliverBatch
+		if recipient.Id == u.Id {
+			// The echo answers the BATCH closing the batch
+			send, sendBatch = u.SendMessage, u.SendBatchWithTags
+		}
+
+		if recipient.HasCap(capability.Multiline) && recipient.HasCap(capability.Batch) {
+			sendBatch(capability.Multiline, []string{target}, tags, lines)
+			continue
+		}
+
+		for _, line := range fallback {
+			send(line)
+		}
+	}
+}
+
+// multilineText joins the lines of a multiline batch the way clients display
+// them, concatenated lines being appended to the previous one.
+func multilineText(b *user.ClientBatch) []string {
+	var lines []string
+
+	for _, m := range b.Msgs {
+		if _, concat := m.Tag(multilineConcat); concat && len(lines) > 0 {
+			lines[len(lines)-1] += m.Params[1]
+			continue
+		}
+
+		lines = append(lines, m.Params[1])
+	}
+
+	return lines
+}
+
+func containsUser(users []*user.User, u *user.User) bool {
+	for _, other := range users {
+		if other.Id == u.Id {
+			return true
+		}
+	}
+
+	return false
+}
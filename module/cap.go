@@ -0,0 +1,160 @@
+/*
+ * Copyright 2014 The starfruit Authors. All rights reserved.
+ * Use of this source code is governed by a BSD-style
+ * license that can be found in the LICENSE file.
+ */
+
+package module
+
+import (
+	"github.com/flatpeach/starfruit/capability"
+	"github.com/flatpeach/starfruit/message"
+	"github.com/flatpeach/starfruit/server"
+	"github.com/flatpeach/starfruit/user"
+	"strconv"
+	"strings"
+)
+
+type Cap struct{}
+
+func (module *Cap) Handle(s *server.Server, u *user.User, m *message.Message) error {
+	// CAP <subcommand> [ <param> ]
+
+	if len(m.Params) == 0 {
+		u.SendErrorNeedMoreParams("CAP")
+		return nil
+	}
+
+	nickName := u.NickName
+	if nickName == "" {
+		nickName = "*"
+	}
+
+	subCommand := strings.ToUpper(m.Params[0])
+
+	switch subCommand {
+	case "LS":
+		if !u.IsRegistered() {
+			u.SetNegotiatingCaps(true)
+		}
+
+		if len(m.Params) > 1 {
+			version, err := strconv.Atoi(m.Params[1])
+			if err == nil {
+				u.SetCapVersion(version)
+			}
+		}
+
+		if u.CapVersion() >= 302 {
+			// cap-notify is implicitly enabled by CAP LS 302
+			u.EnableCap(capability.CapNotify)
+		}
+
+		sendCapList(s, u, nickName, "LS", s.Caps.Tokens(u.CapVersion()))
+
+	case "LIST":
+		sendCapList(s, u, nickName, "LIST", u.Caps())
+
+	case "REQ":
+		if !u.IsRegistered() {
+			u.SetNegotiatingCaps(true)
+		}
+
+		var requested string
+		if len(m.Params) > 1 {
+			requested = m.Params[1]
+		}
+
+		caps := strings.Fields(requested)
+
+		// Requests are atomic, either all capabilities are changed or none
+		for _, name := range caps {
+			if !s.Caps.Exists(strings.TrimPrefix(name, "-")) {
+				u.SendMessage(message.New(
+					s.Config.Server.Name,
+					"CAP",
+					[]string{nickName, "NAK"},
+					requested,
+				))
+
+				return nil
+			}
+		}
+
+		for _, name := range caps {
+			if strings.HasPrefix(name, "-") {
+				u.DisableCap(name[1:])
+			} else {
+				u.EnableCap(name)
+			}
+		}
+
+		u.SendMessage(message.New(
+			s.Config.Server.Name,
+			"CAP",
+			[]string{nickName, "ACK"},
+			requested,
+		))
+
+	case "END":
+		if u.IsRegistered() {
+			return nil
+		}
+
+		u.SetNegotiatingCaps(false)
+		completeRegistration(s, u)
+
+	default:
+		u.SendMessage(message.New(
+			s.Config.Server.Name,
+			message.ERR_INVALIDCAPCMD,
+			[]string{nickName, m.Params[0]},
+			"Invalid CAP command",
+		))
+	}
+
+	return nil
+}
+
+// sendCapList replies with a list of capabilities, for clients speaking
+// CAP 302 the list is split over several lines when it is too long.
+func sendCapList(s *server.Server, u *user.User, nickName string, subCommand string, tokens []string) {
+	// Room left once the prefix, command, nick and the "*" marker are counted
+	size := 510 - len(":"+s.Config.Server.Name+" CAP "+nickName+" "+subCommand+" * :")
+
+	var (
+		lines []string
+		line  string
+	)
+
+	for _, token := range tokens {
+		if line != "" && len(line)+1+len(token) > size {
+			lines = append(lines, line)
+			line = ""
+		}
+
+		if line != "" {
+			line += " "
+		}
+		line += token
+	}
+	lines = append(lines, line)
+
+	if u.CapVersion() < 302 && len(lines) > 1 {
+		lines = []string{strings.Join(lines, " ")}
+	}
+
+	for idx, line := range lines {
+		params := []string{nickName, subCommand}
+		if idx < len(lines)-1 {
+			params = append(params, "*")
+		}
+
+		u.SendMessage(message.New(
+			s.Config.Server.Name,
+			"CAP",
+			params,
+			line,
+		))
+	}
+}
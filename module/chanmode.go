@@ -0,0 +1,239 @@
+/*
+ * Copyright 2014 The starfruit Authors. All rights reserved.
+ * Use of this source code is governed by a BSD-style
+ * license that can be found in the LICENSE file.
+ */
+
+package module
+
+import (
+	"github.com/flatpeach/starfruit/channel"
+	"github.com/flatpeach/starfruit/mask"
+	"github.com/flatpeach/starfruit/message"
+	"github.com/flatpeach/starfruit/server"
+	"github.com/flatpeach/starfruit/user"
+	"strconv"
+)
+
+// channelMode shows or changes the modes of a channel, the privileges of its
+// members included.
+func channelMode(s *server.Server, u *user.User, m *message.Message) error {
+	channelName := m.Params[0]
+
+	cnl := s.FindChannelByName(channelName)
+	if cnl == nil {
+		u.SendMessage(message.New(
+			s.Config.Server.Name,
+			message.ERR_NOSUCHCHANNEL,
+			[]string{u.NickName, channelName},
+			"No such channel",
+		))
+
+		return nil
+	}
+
+	if len(m.Params) == 1 {
+		modes, params := cnl.ModeString()
+		if !s.IsUserJoinedChannel(u.Id, cnl.Id) {
+			// The key is only shown to members
+			params = nil
+		}
+
+		u.SendMessage(message.New(
+			s.Config.Server.Name,
+			message.RPL_CHANNELMODEIS,
+			append([]string{u.NickName, cnl.String(), modes}, params...),
+			nil,
+		))
+
+		return nil
+	}
+
+	modes := m.Params[1]
+	args := m.Params[2:]
+
+	if (modes == "b" || modes == "+b") && len(args) == 0 {
+		sendBanList(s, u, cnl)
+		return nil
+	}
+
+	if !cnl.IsOperator(u.Id) && !u.Can(user.OperOverrideChannelModes) {
+		u.SendMessage(message.New(
+			s.Config.Server.Name,
+			message.ERR_CHANOPRIVSNEEDED,
+			[]string{u.NickName, cnl.String()},
+			"You're not channel operator",
+		))
+
+		return nil
+	}
+
+	var (
+		operator      byte = '+'
+		lastOperator  byte
+		applied       string
+		appliedParams []string
+	)
+
+	// change records a mode really changed, to be broadcasted to members
+	change := func(mode byte, param string) {
+		if operator != lastOperator {
+			applied += string(operator)
+			lastOperator = operator
+		}
+
+		applied += string(mode)
+
+		if param != "" {
+			appliedParams = append(appliedParams, param)
+		}
+	}
+
+	// nextArg consumes the next parameter of a mode, empty if missing
+	nextArg := func() string {
+		if len(args) == 0 {
+			return ""
+		}
+
+		arg := args[0]
+		args = args[1:]
+
+		return arg
+	}
+
+	for i := 0; i < len(modes); i++ {
+		mode := modes[i]
+
+		switch mode {
+		case '+', '-':
+			operator = mode
+
+		case 'o', 'v':
+			nick := nextArg()
+			if nick == "" {
+				continue
+			}
+
+			target := s.GetUserByNickName(nick)
+			if target == nil || !s.IsUserJoinedChannel(target.Id, cnl.Id) {
+				u.SendMessage(message.New(
+					s.Config.Server.Name,
+					message.ERR_USERNOTINCHANNEL,
+					[]string{u.NickName, nick, cnl.String()},
+					"They aren't on that channel",
+				))
+
+				continue
+			}
+
+			if operator == '+' {
+				cnl.SetPrivilege(target.Id, channel.PrivilegeMode(mode))
+			} else {
+				cnl.ClearPrivilege(target.Id, channel.PrivilegeMode(mode))
+			}
+
+			change(mode, target.NickName)
+
+		case 'b':
+			banMask := nextArg()
+			if banMask == "" {
+				sendBanList(s, u, cnl)
+				continue
+			}
+
+			banMask = mask.Normalize(banMask)
+
+			if operator == '+' && cnl.AddBan(banMask) {
+				change(mode, banMask)
+			} else if operator == '-' && cnl.RemoveBan(banMask) {
+				change(mode, banMask)
+			}
+
+		case 'k':
+			key := nextArg()
+
+			if operator == '+' {
+				if key == "" {
+					continue
+				}
+
+				cnl.SetKey(key)
+				change(mode, key)
+			} else if cnl.HasMode(channel.MODE_KEY) {
+				cnl.SetKey("")
+				change(mode, "*")
+			}
+
+		case 'l':
+			if operator == '+' {
+				limit, err := strconv.Atoi(nextArg())
+				if err != nil || limit <= 0 {
+					continue
+				}
+
+				cnl.SetLimit(limit)
+				change(mode, strconv.Itoa(limit))
+			} else if cnl.HasMode(channel.MODE_LIMIT) {
+				cnl.SetLimit(0)
+				change(mode, "")
+			}
+
+		default:
+			flag := channel.FlagMode(mode)
+			if flag == 0 {
+				u.SendMessage(message.New(
+					s.Config.Server.Name,
+					message.ERR_UNKNOWNMODE,
+					[]string{u.NickName, string(mode)},
+					"is unknown mode char to me for "+cnl.String(),
+				))
+
+				continue
+			}
+
+			if operator == '+' && !cnl.HasMode(flag) {
+				cnl.MarkMode(flag)
+				change(mode, "")
+			} else if operator == '-' && cnl.HasMode(flag) {
+				cnl.ClearMode(flag)
+				change(mode, "")
+			}
+		}
+	}
+
+	if applied == "" {
+		return nil
+	}
+
+	saveChannel(s, cnl)
+
+	modeMsg := message.New(
+		u.Full(),
+		"MODE",
+		append([]string{cnl.String(), applied}, appliedParams...),
+		nil,
+	)
+	modeMsg.Stamp()
+
+	s.BroadcastReply(u, cnl.Id, modeMsg)
+
+	return nil
+}
+
+func sendBanList(s *server.Server, u *user.User, cnl *channel.Channel) {
+	for _, ban := range cnl.Bans() {
+		u.SendMessage(message.New(
+			s.Config.Server.Name,
+			message.RPL_BANLIST,
+			[]string{u.NickName, cnl.String(), ban},
+			nil,
+		))
+	}
+
+	u.SendMessage(message.New(
+		s.Config.Server.Name,
+		message.RPL_ENDOFBANLIST,
+		[]string{u.NickName, cnl.String()},
+		"End of channel ban list",
+	))
+}
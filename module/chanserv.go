@@ -0,0 +1,345 @@
+/*
+ * Copyright 2014 The starfruit Authors. All rights reserved.
+ * Use of this source code is governed by a BSD-style
+ * license that can be found in the LICENSE file.
+ */
+
+package module
+
+import (
+	"github.com/flatpeach/starfruit/channel"
+	"github.com/flatpeach/starfruit/message"
+	"github.com/flatpeach/starfruit/registry"
+	"github.com/flatpeach/starfruit/server"
+	"github.com/flatpeach/starfruit/user"
+	"log"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ChanServ is the service users register channels with
+var chanServ = &service{
+	Name: "ChanServ",
+	Commands: map[string]*serviceCommand{
+		"REGISTER": {
+			Syntax: "<channel>",
+			Help:   "Registers a channel you're an operator of to your account",
+			Handle: chanServRegister,
+		},
+		"DROP": {
+			Syntax: "<channel>",
+			Help:   "Drops the registration of a channel you founded",
+			Handle: chanServDrop,
+		},
+		"ACCESS": {
+			Syntax: "<channel> LIST | ADD <account> <op|voice> | DEL <account>",
+			Help:   "Manages the accounts given op or voice when they join",
+			Handle: chanServAccess,
+		},
+		"INFO": {
+			Syntax: "<channel>",
+			Help:   "Shows information about a registered channel",
+			Handle: chanServInfo,
+		},
+	},
+}
+
+func init() {
+	registerService(chanServ)
+}
+
+// RestoreChannels creates the registered channels with the settings they had
+// before the server stopped.
+func RestoreChannels(s *server.Server) {
+	for _, record := range s.Registry.All() {
+		cnl, err := s.FindOrCreateChannel(record.Name)
+		if err != nil {
+			log.Printf("[CHANSERV] Failed to restore the channel %s :%s", record.Name, err)
+			continue
+		}
+
+		cnl.RestoreTopic(record.Topic, record.TopicSetBy, record.TopicSetAt)
+
+		for i := 0; i < len(record.Modes); i++ {
+			if flag := channel.FlagMode(record.Modes[i]); flag != 0 {
+				cnl.MarkMode(flag)
+			}
+		}
+
+		cnl.SetKey(record.Key)
+		cnl.SetLimit(record.Limit)
+
+		for _, ban := range record.Bans {
+			cnl.AddBan(ban)
+		}
+
+		cnl.Metadata.Load(record.Metadata)
+	}
+}
+
+// registeredChannel returns the registration of a channel, nil if nobody
+// registered it.
+func registeredChannel(s *server.Server, cnl *channel.Channel) *registry.Channel {
+	if s.Registry == nil {
+		return nil
+	}
+
+	record, err := s.Registry.Get(cnl.String())
+	if err != nil {
+		return nil
+	}
+
+	return record
+}
+
+// saveChannel keeps the current settings of a registered channel
+func saveChannel(s *server.Server, cnl *channel.Channel) {
+	record := registeredChannel(s, cnl)
+	if record == nil {
+		return
+	}
+
+	record.Topic = cnl.Topic()
+	record.TopicSetBy = cnl.TopicSetBy()
+	record.TopicSetAt = cnl.TopicSetTime()
+	record.Modes = cnl.Flags()
+	record.Key = cnl.Key()
+	record.Limit = cnl.Limit()
+	record.Bans = cnl.Bans()
+	record.Metadata = cnl.Metadata.All()
+
+	err := s.Registry.Save(record)
+	if err != nil {
+		log.Printf("[CHANSERV] Failed to save the channel %s :%s", record.Name, err)
+	}
+}
+
+// applyAccess gives a user who just joined a registered channel the
+// privilege his account has on it.
+func applyAccess(s *server.Server, u *user.User, cnl *channel.Channel, record *registry.Channel) {
+	var (
+		privilege int
+		mode      string
+	)
+
+	switch record.Level(u.Account()) {
+	case registry.LevelOp:
+		privilege, mode = channel.MODE_OPERATOR, "+o"
+	case registry.LevelVoice:
+		privilege, mode = channel.MODE_VOICE, "+v"
+	default:
+		return
+	}
+
+	cnl.SetPrivilege(u.Id, privilege)
+
+	modeMsg := message.New(
+		chanServ.source(s),
+		"MODE",
+		[]string{cnl.String(), mode, u.NickName},
+		nil,
+	)
+	modeMsg.Stamp()
+
+	s.BroadcastReply(u, cnl.Id, modeMsg)
+}
+
+func chanServRegister(s *server.Server, u *user.User, svc *service, args []string) {
+	if len(args) == 0 {
+		svc.replySyntax(s, u, "REGISTER")
+		return
+	}
+
+	if !u.IsLoggedIn() {
+		svc.reply(s, u, "You must be logged in to register a channel")
+		return
+	}
+
+	cnl := s.FindChannelByName(args[0])
+	if cnl == nil || !s.IsUserJoinedChannel(u.Id, cnl.Id) || !cnl.IsOperator(u.Id) {
+		svc.reply(s, u, "You must be an operator of "+args[0]+" to register it")
+		return
+	}
+
+	if registeredChannel(s, cnl) != nil {
+		svc.reply(s, u, "The channel "+cnl.String()+" is already registered")
+		return
+	}
+
+	err := s.Registry.Save(&registry.Channel{
+		Name:      cnl.String(),
+		Founder:   u.Account(),
+		CreatedAt: time.Now().Unix(),
+	})
+	if err != nil {
+		log.Printf("[CHANSERV] Failed to register the channel %s :%s", cnl.String(), err)
+		svc.reply(s, u, "The channel can't be registered right now")
+		return
+	}
+
+	saveChannel(s, cnl)
+
+	svc.reply(s, u, "The channel "+cnl.String()+" is now registered to your account")
+}
+
+func chanServDrop(s *server.Server, u *user.User, svc *service, args []string) {
+	if len(args) == 0 {
+		svc.replySyntax(s, u, "DROP")
+		return
+	}
+
+	record, ok := founderRecord(s, u, svc, args[0])
+	if !ok {
+		return
+	}
+
+	err := s.Registry.Delete(record.Name)
+	if err != nil {
+		log.Printf("[CHANSERV] Failed to drop the channel %s :%s", record.Name, err)
+		svc.reply(s, u, "The channel can't be dropped right now")
+		return
+	}
+
+	svc.reply(s, u, "The channel "+record.Name+" is no longer registered")
+}
+
+func chanServAccess(s *server.Server, u *user.User, svc *service, args []string) {
+	if len(args) < 2 {
+		svc.replySyntax(s, u, "ACCESS")
+		return
+	}
+
+	record, err := s.Registry.Get(args[0])
+	if err != nil {
+		svc.reply(s, u, "The channel "+args[0]+" is not registered")
+		return
+	}
+
+	level := record.Level(u.Account())
+	oper := u.Can(user.OperServices)
+
+	if level == "" && !oper {
+		svc.reply(s, u, "You have no access to "+record.Name)
+		return
+	}
+
+	switch strings.ToUpper(args[1]) {
+	case "LIST":
+		svc.reply(s, u, "Founder of "+record.Name+": "+record.Founder)
+
+		var accounts []string
+		for account := range record.Access {
+			accounts = append(accounts, account)
+		}
+		sort.Strings(accounts)
+
+		for _, account := range accounts {
+			svc.reply(s, u, account+" "+record.Access[account])
+		}
+
+		return
+
+	case "ADD":
+		if len(args) < 4 || !registry.ValidLevel(strings.ToLower(args[3])) {
+			svc.replySyntax(s, u, "ACCESS")
+			return
+		}
+
+		account, newLevel := args[2], strings.ToLower(args[3])
+
+		if !canChangeAccess(u, record, account, newLevel) {
+			svc.reply(s, u, "You're not allowed to change this access")
+			return
+		}
+
+		if _, err := s.Accounts.Get(account); err != nil && !isExternal(account) {
+			svc.reply(s, u, "Account "+account+" is not registered")
+			return
+		}
+
+		record.SetLevel(account, newLevel)
+		svc.reply(s, u, "Account "+account+" now has "+newLevel+" access to "+record.Name)
+
+	case "DEL":
+		if len(args) < 3 {
+			svc.replySyntax(s, u, "ACCESS")
+			return
+		}
+
+		account := args[2]
+
+		if !canChangeAccess(u, record, account, record.Access[registry.Key(account)]) {
+			svc.reply(s, u, "You're not allowed to change this access")
+			return
+		}
+
+		if !record.RemoveLevel(account) {
+			svc.reply(s, u, "Account "+account+" has no access to "+record.Name)
+			return
+		}
+
+		svc.reply(s, u, "Account "+account+" no longer has access to "+record.Name)
+
+	default:
+		svc.replySyntax(s, u, "ACCESS")
+		return
+	}
+
+	err = s.Registry.Save(record)
+	if err != nil {
+		log.Printf("[CHANSERV] Failed to save the channel %s :%s", record.Name, err)
+	}
+}
+
+// canChangeAccess tells whether a user may give or take a level, operators
+// may manage voices while only the founder manages operators. IRC operators
+// with the services capability may change any.
+func canChangeAccess(u *user.User, record *registry.Channel, account string, level string) bool {
+	if u.Can(user.OperServices) {
+		return true
+	}
+
+	if registry.Key(account) == registry.Key(record.Founder) {
+		return false
+	}
+
+	if registry.Key(u.Account()) == registry.Key(record.Founder) {
+		return true
+	}
+
+	return level == registry.LevelVoice && record.Level(u.Account()) == registry.LevelOp
+}
+
+func chanServInfo(s *server.Server, u *user.User, svc *service, args []string) {
+	if len(args) == 0 {
+		svc.replySyntax(s, u, "INFO")
+		return
+	}
+
+	record, err := s.Registry.Get(args[0])
+	if err != nil {
+		svc.reply(s, u, "The channel "+args[0]+" is not registered")
+		return
+	}
+
+	svc.reply(s, u, "The channel "+record.Name+" was registered by "+record.Founder+
+		" on "+time.Unix(record.CreatedAt, 0).UTC().Format(time.RFC1123))
+}
+
+// founderRecord returns the registration of a channel the user founded, IRC
+// operators with the services capability may act as the founder of any.
+func founderRecord(s *server.Server, u *user.User, svc *service, name string) (*registry.Channel, bool) {
+	record, err := s.Registry.Get(name)
+	if err != nil {
+		svc.reply(s, u, "The channel "+name+" is not registered")
+		return nil, false
+	}
+
+	if registry.Key(u.Account()) != registry.Key(record.Founder) && !u.Can(user.OperServices) {
+		svc.reply(s, u, "Only the founder of "+record.Name+" may do this")
+		return nil, false
+	}
+
+	return record, true
+}
@@ -0,0 +1,280 @@
+/*
+ * Copyright 2014 The starfruit Authors. All rights reserved.
+ * Use of this source code is governed by a BSD-style
+ * license that can be found in the LICENSE file.
+ */
+
+package module
+
+import (
+	"errors"
+	"github.com/flatpeach/starfruit/capability"
+	"github.com/flatpeach/starfruit/channel"
+	"github.com/flatpeach/starfruit/history"
+	"github.com/flatpeach/starfruit/message"
+	"github.com/flatpeach/starfruit/server"
+	"github.com/flatpeach/starfruit/user"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Most messages a single CHATHISTORY request may return
+const MaxChatHistoryItems = 100
+
+var (
+	errInvalidSelector = errors.New("Invalid message reference")
+	errUnknownMessage  = errors.New("Unknown message")
+)
+
+type ChatHistory struct{}
+
+func (module *ChatHistory) Handle(s *server.Server, u *user.User, m *message.Message) error {
+	// CHATHISTORY LATEST <target> <* | selector> <limit>
+	// CHATHISTORY BEFORE <target> <selector> <limit>
+	// CHATHISTORY AFTER <target> <selector> <limit>
+	// CHATHISTORY AROUND <target> <selector> <limit>
+	// CHATHISTORY BETWEEN <target> <selector> <selector> <limit>
+	// CHATHISTORY TARGETS <timestamp> <timestamp> <limit>
+
+	if len(m.Params) == 0 {
+		u.SendFail("CHATHISTORY", "NEED_MORE_PARAMS", nil, "Insufficient parameters")
+		return nil
+	}
+
+	subcommand := strings.ToUpper(m.Params[0])
+
+	needed := map[string]int{
+		"LATEST":  4,
+		"BEFORE":  4,
+		"AFTER":   4,
+		"AROUND":  4,
+		"BETWEEN": 5,
+		"TARGETS": 4,
+	}
+
+	n, exists := needed[subcommand]
+	if !exists {
+		u.SendFail("CHATHISTORY", "INVALID_PARAMS", []string{m.Params[0]}, "Unknown subcommand")
+		return nil
+	}
+
+	if len(m.Params) < n {
+		u.SendFail("CHATHISTORY", "NEED_MORE_PARAMS", []string{subcommand}, "Insufficient parameters")
+		return nil
+	}
+
+	limit, err := strconv.Atoi(m.Params[n-1])
+	if err != nil || limit < 0 {
+		u.SendFail("CHATHISTORY", "INVALID_PARAMS", []string{subcommand}, "Invalid limit")
+		return nil
+	}
+
+	if limit == 0 || limit > MaxChatHistoryItems {
+		limit = MaxChatHistoryItems
+	}
+
+	if s.History == nil {
+		u.SendFail("CHATHISTORY", "MESSAGE_ERROR", []string{subcommand}, "History is disabled")
+		return nil
+	}
+
+	if subcommand == "TARGETS" {
+		sendChatHistoryTargets(s, u, m.Params[1], m.Params[2], limit)
+		return nil
+	}
+
+	target := m.Params[1]
+
+	store, name, ok := historyOf(s, u, target)
+	if !ok {
+		u.SendFail("CHATHISTORY", "INVALID_TARGET", []string{subcommand, target}, "Messages could not be retrieved")
+		return nil
+	}
+
+	var (
+		items []*history.Item
+		t     time.Time
+		end   time.Time
+	)
+
+	t, err = parseSelector(store, name, m.Params[2], subcommand == "LATEST")
+	if err == nil && subcommand == "BETWEEN" {
+		end, err = parseSelector(store, name, m.Params[3], false)
+	}
+
+	if err == errUnknownMessage {
+		u.SendFail("CHATHISTORY", "MESSAGE_ERROR", []string{subcommand, target}, "Unknown message")
+		return nil
+	}
+
+	if err != nil {
+		u.SendFail("CHATHISTORY", "INVALID_MSGREFTYPE", []string{subcommand, target}, "Invalid message reference")
+		return nil
+	}
+
+	switch subcommand {
+	case "LATEST":
+		items = store.Latest(name, t, limit)
+
+	case "BEFORE":
+		items = store.Before(name, t, limit)
+
+	case "AFTER":
+		items = store.After(name, t, limit)
+
+	case "AROUND":
+		// The message at the selector itself is part of the older half
+		items = store.Before(name, t.Add(time.Nanosecond), limit-limit/2)
+		items = append(items, store.After(name, t, limit/2)...)
+
+	case "BETWEEN":
+		items = store.Between(name, t, end, limit)
+	}
+
+	eventPlayback := u.HasCap(capability.EventPlayback)
+
+	var msgs []*message.Message
+	for _, item := range items {
+		if item.Redacted {
+			continue
+		}
+
+		if !eventPlayback && item.Command != "PRIVMSG" && item.Command != "NOTICE" {
+			continue
+		}
+
+		msgs = append(msgs, item.Message())
+	}
+
+	u.SendBatch("chathistory", []string{target}, msgs)
+
+	return nil
+}
+
+// historyOf finds the history of a channel or the one of the conversation
+// with a nick, along with the name it is kept under.
+func historyOf(s *server.Server, u *user.User, target string) (history.Store, string, bool) {
+	if target == "" {
+		return nil, "", false
+	}
+
+	if strings.ContainsAny(target[0:1], channel.NS_ALL_RAW) {
+		cnl := s.FindChannelByName(target)
+		if cnl == nil || !canSeeChannel(s, u, cnl) {
+			return nil, "", false
+		}
+
+		return s.History, cnl.String(), true
+	}
+
+	if s.Direct == nil {
+		return nil, "", false
+	}
+
+	other, ok := partyByNickName(s, target)
+	if !ok {
+		return nil, "", false
+	}
+
+	return s.Direct, conversation(party(u), other), true
+}
+
+// canSeeChannel tells whether a user may see the history or the metadata
+// of a channel, only members can, and operators allowed to see secret
+// channels.
+func canSeeChannel(s *server.Server, u *user.User, cnl *channel.Channel) bool {
+	return s.IsUserJoinedChannel(u.Id, cnl.Id) || u.Can(user.OperSeeSecret)
+}
+
+// parseSelector turns a timestamp= or msgid= selector into a time, "*" is
+// the zero time where allowed.
+func parseSelector(store history.Store, name string, selector string, allowStar bool) (time.Time, error) {
+	if selector == "*" && allowStar {
+		return time.Time{}, nil
+	}
+
+	kv := strings.SplitN(selector, "=", 2)
+	if len(kv) != 2 {
+		return time.Time{}, errInvalidSelector
+	}
+
+	switch kv[0] {
+	case "timestamp":
+		t, err := time.Parse(time.RFC3339Nano, kv[1])
+		if err != nil {
+			return time.Time{}, errInvalidSelector
+		}
+
+		return t, nil
+
+	case "msgid":
+		item := store.Find(name, kv[1])
+		if item == nil {
+			return time.Time{}, errUnknownMessage
+		}
+
+		return item.Time, nil
+	}
+
+	return time.Time{}, errInvalidSelector
+}
+
+func sendChatHistoryTargets(s *server.Server, u *user.User, from string, to string, limit int) {
+	start, validStart := parseTimestamp(from)
+	end, validEnd := parseTimestamp(to)
+
+	if !validStart || !validEnd {
+		u.SendFail("CHATHISTORY", "INVALID_PARAMS", []string{"TARGETS"}, "Only timestamps are allowed")
+		return
+	}
+
+	targets := s.History.Targets(start, end, limit, func(name string) bool {
+		cnl := s.FindChannelByName(name)
+		return cnl != nil && s.IsUserJoinedChannel(u.Id, cnl.Id)
+	})
+
+	if s.Direct != nil {
+		self := party(u)
+
+		conversations := s.Direct.Targets(start, end, limit, func(name string) bool {
+			_, ok := peer(name, self)
+			return ok
+		})
+
+		for _, t := range conversations {
+			t.Name, _ = peer(t.Name, self)
+		}
+
+		targets = append(targets, conversations...)
+	}
+
+	// Keep the targets closest to start once both histories are merged
+	targets = history.SortTargets(targets, limit, end.Before(start))
+
+	var msgs []*message.Message
+	for _, t := range targets {
+		msgs = append(msgs, message.New(
+			s.Config.Server.Name,
+			"CHATHISTORY",
+			[]string{
+				"TARGETS",
+				t.Name,
+				"timestamp=" + t.Latest.Format(message.TimeFormat),
+			},
+			nil,
+		))
+	}
+
+	u.SendBatch("draft/chathistory-targets", nil, msgs)
+}
+
+func parseTimestamp(selector string) (time.Time, bool) {
+	if !strings.HasPrefix(selector, "timestamp=") {
+		return time.Time{}, false
+	}
+
+	t, err := time.Parse(time.RFC3339Nano, strings.TrimPrefix(selector, "timestamp="))
+
+	return t, err == nil
+}
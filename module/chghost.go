@@ -0,0 +1,75 @@
+/*
+ * Copyright 2014 The starfruit Authors. All rights reserved.
+ * Use of this source code is governed by a BSD-style
+ * license that can be found in the LICENSE file.
+ */
+
+package module
+
+import (
+	"github.com/flatpeach/starfruit/capability"
+	"github.com/flatpeach/starfruit/message"
+	"github.com/flatpeach/starfruit/server"
+	"github.com/flatpeach/starfruit/user"
+	"strings"
+)
+
+type ChgHost struct{}
+
+func (module *ChgHost) Handle(s *server.Server, u *user.User, m *message.Message) error {
+	// CHGHOST <nickname> <new user> <new host>
+
+	if !requireCapability(s, u, user.OperChgHost) {
+		return nil
+	}
+
+	if len(m.Params) < 3 {
+		u.SendFail("CHGHOST", "NEED_MORE_PARAMS", nil, "Not enough parameters")
+		return nil
+	}
+
+	target := s.GetUserByNickName(m.Params[0])
+	if target == nil {
+		u.SendFail("CHGHOST", "INVALID_TARGET", []string{m.Params[0]}, "No such nick")
+		return nil
+	}
+
+	userName, hostName := m.Params[1], m.Params[2]
+	if !validHostPart(userName) || !validHostPart(hostName) {
+		u.SendFail("CHGHOST", "INVALID_PARAMS", []string{userName, hostName}, "Invalid user name or host")
+		return nil
+	}
+
+	changeHost(s, target, userName, hostName)
+
+	return nil
+}
+
+// changeHost changes the user name and host of a user, the user and his
+// channel neighbours who negotiated chghost are told about it.
+func changeHost(s *server.Server, u *user.User, userName string, hostName string) {
+	chgHostMsg := message.New(
+		u.Full(),
+		"CHGHOST",
+		[]string{
+			user.Ident(userName),
+			hostName,
+		},
+		nil,
+	)
+	chgHostMsg.Stamp()
+
+	if u.HasCap(capability.ChgHost) {
+		u.Deliver(chgHostMsg)
+	}
+
+	s.NotifyNeighbours(u.Id, capability.ChgHost, chgHostMsg)
+
+	u.SetHost(userName, hostName)
+}
+
+// validHostPart tells whether a user name or host can be put in the masks of
+// users and sent as a parameter.
+func validHostPart(v string) bool {
+	return v != "" && v[0] != ':' && !strings.ContainsAny(v, " !@")
+}
@@ -0,0 +1,41 @@
+/*
+ * Copyright 2014 The starfruit Authors. All rights reserved.
+ * Use of this source code is governed by a BSD-style
+ * license that can be found in the LICENSE file.
+ */
+
+package module
+
+import (
+	"github.com/flatpeach/starfruit/message"
+	"github.com/flatpeach/starfruit/server"
+	"github.com/flatpeach/starfruit/user"
+	"log"
+	"os"
+	"time"
+)
+
+// Time given to the last messages to reach the users before exiting
+const dieDelay = time.Second
+
+type Die struct{}
+
+func (module *Die) Handle(s *server.Server, u *user.User, m *message.Message) error {
+	// DIE
+
+	if !requireCapability(s, u, user.OperDie) {
+		return nil
+	}
+
+	log.Printf("[DIE] %s is shutting the server down", u.Full())
+
+	for _, target := range s.GetAllUsers() {
+		disconnectUser(s, target, "Server shutting down")
+	}
+
+	time.AfterFunc(dieDelay, func() {
+		os.Exit(0)
+	})
+
+	return nil
+}
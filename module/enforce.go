@@ -0,0 +1,113 @@
+/*
+ * Copyright 2014 The starfruit Authors. All rights reserved.
+ * Use of this source code is governed by a BSD-style
+ * license that can be found in the LICENSE file.
+ */
+
+package module
+
+import (
+	"fmt"
+	"github.com/flatpeach/starfruit/account"
+	"github.com/flatpeach/starfruit/server"
+	"github.com/flatpeach/starfruit/user"
+	"math/rand"
+	"time"
+)
+
+// nickOwner returns the account owning a nick, nil if anybody may use it
+func nickOwner(s *server.Server, nick string) *account.Account {
+	if s.Accounts == nil {
+		return nil
+	}
+
+	a, err := s.Accounts.GetByNick(nick)
+	if err != nil || a.VerificationExpired() {
+		return nil
+	}
+
+	return a
+}
+
+// mustLogIn tells whether a user uses a nick owned by an account he is not
+// logged in to, operators with the services capability may use any nick.
+func mustLogIn(s *server.Server, u *user.User, nick string) bool {
+	if u.Can(user.OperServices) {
+		return false
+	}
+
+	owner := nickOwner(s, nick)
+	if owner == nil {
+		return false
+	}
+
+	return account.Key(owner.Name) != account.Key(u.Account())
+}
+
+// enforceNickName gives a user who took a nick owned by another account
+// some time to log in to it, he is renamed to a guest nick otherwise.
+func enforceNickName(s *server.Server, u *user.User) {
+	if u.NickTimer != nil {
+		u.NickTimer.Stop()
+		u.NickTimer = nil
+	}
+
+	cf := s.Config.Nick
+	nick := u.NickName
+
+	// Looked up rather than referred to, NickServ commands enforce nicks too
+	svc := findService("NickServ")
+
+	if !cf.Enforce || !mustLogIn(s, u, nick) {
+		return
+	}
+
+	rename := func() {
+		if u.NickName != nick || !s.ExistsUser(u.Id) || !mustLogIn(s, u, nick) {
+			return
+		}
+
+		guest := guestNickName(s)
+
+		svc.reply(s, u, "You're now known as "+guest+", the nick "+nick+" is owned by another account")
+		changeNickName(s, u, guest)
+	}
+
+	if cf.GracePeriod <= 0 {
+		rename()
+		return
+	}
+
+	svc.reply(s, u, fmt.Sprintf(
+		"The nick %s is owned by another account, log in to it within %d seconds or you will be renamed",
+		nick,
+		cf.GracePeriod,
+	))
+
+	// The timer fires outside of the request loop of the user
+	u.NickTimer = time.AfterFunc(time.Duration(cf.GracePeriod)*time.Second, func() {
+		u.Serialize(func() {
+			s.ReadConfig(rename)
+		})
+	})
+}
+
+// guestDigits is the number of random digits following the guest prefix
+const guestDigits = 5
+
+// ValidGuestPrefix tells whether the guest nicks made of a prefix are short
+// enough to be nicks.
+func ValidGuestPrefix(prefix string) bool {
+	return len(prefix)+guestDigits <= user.MaxNickNameLength
+}
+
+// guestNickName returns a nick nobody uses nor owns
+func guestNickName(s *server.Server) string {
+	for {
+		nick := fmt.Sprintf("%s%0*d", s.Config.Nick.GuestPrefix, guestDigits, rand.Intn(100000))
+
+		if !s.IsNickNameRegistered(nick) && nickOwner(s, nick) == nil {
+			return nick
+		}
+	}
+}
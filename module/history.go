@@ -0,0 +1,132 @@
+/*
+ * Copyright 2014 The starfruit Authors. All rights reserved.
+ * Use of this source code is governed by a BSD-style
+ * license that can be found in the LICENSE file.
+ */
+
+package module
+
+import (
+	"github.com/flatpeach/starfruit/account"
+	"github.com/flatpeach/starfruit/casemapping"
+	"github.com/flatpeach/starfruit/history"
+	"github.com/flatpeach/starfruit/message"
+	"github.com/flatpeach/starfruit/server"
+	"github.com/flatpeach/starfruit/user"
+	"log"
+	"strings"
+)
+
+// Prefixes telling whether a party of a conversation is an account or a nick
+const (
+	partyAccount = "account:"
+	partyNick    = "nick:"
+)
+
+// addHistory records a stamped message relayed to a target, so it can be
+// played back with CHATHISTORY.
+func addHistory(s *server.Server, u *user.User, target string, m *message.Message) {
+	if s.History == nil {
+		return
+	}
+
+	err := s.History.Add(target, newItem(u, m))
+	if err != nil {
+		log.Printf("[HISTORY] Failed to store a message to %s :%s", target, err)
+	}
+}
+
+// addDirectHistory records a stamped message between two users, unless the
+// history of private conversations is disabled.
+func addDirectHistory(s *server.Server, u *user.User, target *user.User, m *message.Message) {
+	if s.Direct == nil {
+		return
+	}
+
+	name := conversation(party(u), party(target))
+
+	err := s.Direct.Add(name, newItem(u, m))
+	if err != nil {
+		log.Printf("[HISTORY] Failed to store a private message :%s", err)
+	}
+}
+
+// newItem makes the history item of a message sent by a user, remembering
+// the connection of users who aren't logged in so only they can redact it.
+func newItem(u *user.User, m *message.Message) *history.Item {
+	item := history.NewItem(m, u.Account())
+	if item.Account == "" {
+		item.Conn = u.ConnId
+	}
+
+	return item
+}
+
+// party names a user in a conversation, by his account if logged in so the
+// history follows the account whatever nick is used. Users who aren't logged
+// in are named by their nick and their connection, so whoever takes the nick
+// later never reads their conversations.
+func party(u *user.User) string {
+	if u.IsLoggedIn() {
+		return partyAccount + account.Key(u.Account())
+	}
+
+	return partyNick + casemapping.Fold(u.NickName) + "@" + u.ConnId
+}
+
+// partyByNickName names the user behind a nick who may be disconnected, in
+// which case the account of the same name is assumed if there is one. It
+// fails for nicks of disconnected users who weren't logged in.
+func partyByNickName(s *server.Server, nick string) (string, bool) {
+	if target := s.GetUserByNickName(nick); target != nil {
+		return party(target), true
+	}
+
+	if s.Accounts != nil {
+		if _, err := s.Accounts.Get(nick); err == nil {
+			return partyAccount + account.Key(nick), true
+		}
+	}
+
+	return "", false
+}
+
+// conversation names the history of two parties, whoever speaks first.
+// Parties are joined with a space, which never appears in a nick.
+func conversation(a string, b string) string {
+	if b < a {
+		a, b = b, a
+	}
+
+	return a + " " + b
+}
+
+// peer returns the name of the other party of a conversation, and whether
+// the given party takes part in it.
+func peer(name string, self string) (string, bool) {
+	parties := strings.SplitN(name, " ", 2)
+	if len(parties) != 2 {
+		return "", false
+	}
+
+	var other string
+
+	switch self {
+	case parties[0]:
+		other = parties[1]
+	case parties[1]:
+		other = parties[0]
+	default:
+		return "", false
+	}
+
+	if strings.HasPrefix(other, partyNick) {
+		// Drop the connection the nick is tied to
+		other = strings.TrimPrefix(other, partyNick)
+		other = strings.SplitN(other, "@", 2)[0]
+	}
+
+	other = strings.TrimPrefix(other, partyAccount)
+
+	return other, true
+}
@@ -81,14 +81,21 @@ func (module *Invite) Handle(s *server.Server, u *user.User, m *message.Message)
 		nil,
 	))
 
-	invitedUser.SendMessage(message.New(
+	if c != nil {
+		c.Invite(invitedUser.Id)
+	}
+
+	inviteMsg := message.New(
 		u.Full(),
 		"INVITE",
 		[]string{
 			invitedUser.NickName,
 		},
 		channelName,
-	))
+	)
+	inviteMsg.Stamp()
+
+	invitedUser.Deliver(inviteMsg)
 
 	if invitedUser.IsAway() {
 		u.SendMessage(message.New(
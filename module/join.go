@@ -7,97 +7,221 @@
 package module
 
 import (
+	"github.com/flatpeach/starfruit/capability"
+	"github.com/flatpeach/starfruit/channel"
 	"github.com/flatpeach/starfruit/message"
 	"github.com/flatpeach/starfruit/server"
 	"github.com/flatpeach/starfruit/user"
-	"log"
 	"strings"
 )
 
 type Join struct{}
 
 func (module *Join) Handle(s *server.Server, u *user.User, m *message.Message) error {
-	// JOIN (<channel> ) / "0"
+	// JOIN ( <channel> *( "," <channel> ) [ <key> *( "," <key> ) ] ) / "0"
+
+	if len(m.Params) == 0 {
+		u.SendErrorNeedMoreParams("JOIN")
+		return nil
+	}
 
 	if len(m.Params) == 1 && m.Params[0] == "0" {
 		// This user wanna leave all channels he/she joined now
 		// Send PART replies to members of each channel
-		partMsg := &message.Message{}
-
 		joinedChannels := s.GetJoinedChannels(u.Id)
-		for _, channel := range joinedChannels {
-			s.BroadcastMessage(channel.Id, partMsg, nil)
+		for _, cnl := range joinedChannels {
+			partMsg := message.New(
+				u.Full(),
+				"PART",
+				[]string{cnl.String()},
+				nil,
+			)
+			partMsg.Stamp()
+
+			addHistory(s, u, cnl.String(), partMsg)
+
+			s.BroadcastReply(u, cnl.Id, partMsg)
+			s.QuitFromChannel(u.Id, cnl.Id)
 		}
 
 		return nil
 	}
 
 	channelsRaw := m.Params[0]
-	// @Todo: handle channel join key
+
+	var keys []string
+	if len(m.Params) > 1 {
+		keys = strings.Split(m.Params[1], ",")
+	}
 
 	channels := strings.Split(channelsRaw, ",")
 
-	for _, channelRaw := range channels {
+	for idx, channelRaw := range channels {
 		cnl, err := s.FindOrCreateChannel(channelRaw)
 		if err != nil {
-			log.Printf("[JOIN] Malformed channel :%s", channelRaw)
-			return nil
+			u.SendMessage(message.New(
+				s.Config.Server.Name,
+				message.ERR_BADCHANMASK,
+				[]string{
+					u.NickName,
+					channelRaw,
+				},
+				"Bad Channel Mask",
+			))
+
+			continue
 		}
 
 		if s.IsUserJoinedChannel(u.Id, cnl.Id) {
 			continue
 		}
 
+		var key string
+		if idx < len(keys) {
+			key = keys[idx]
+		}
+
+		if !canJoin(s, u, cnl, key) {
+			continue
+		}
+
+		// The first member of a channel is its operator, unless registered
+		record := registeredChannel(s, cnl)
+		created := s.ChannelUserCount(cnl.Id) == 0 && record == nil
+
 		joinMsg := message.New(
 			u.Full(),
 			"JOIN",
 			nil,
 			cnl.String(),
 		)
+		joinMsg.Stamp()
 
-		u.SendMessage(joinMsg)
+		// extended-join adds the account and the real name of the user
+		account := u.Account()
+		if account == "" {
+			account = "*"
+		}
 
-		u.SendMessage(message.New(
-			s.Config.Server.Name,
-			"MODE",
-			[]string{cnl.String(), "+nt"},
-			nil,
-		))
-
-		u.SendMessage(message.New(
-			s.Config.Server.Name,
-			message.RPL_NAMREPLY,
-			[]string{
-				u.NickName,
-				"=",
-				cnl.String(),
-			},
-			(func() string {
-				var names []string = []string{"@" + u.NickName}
-				users := s.GetJoinedUsers(cnl.Id)
-				for _, u := range users {
-					names = append(names, "@"+u.NickName)
-				}
-				return strings.Join(names, " ")
-			})(),
-		))
-
-		u.SendMessage(message.New(
-			s.Config.Server.Name,
-			message.RPL_ENDOFNAMES,
-			[]string{
-				u.NickName,
-				cnl.String(),
-			},
-			"End of /NAMES list.",
-		))
-
-		cnl.Broadcast(joinMsg, nil)
+		extendedJoinMsg := message.New(
+			u.Full(),
+			"JOIN",
+			[]string{cnl.String(), account},
+			u.RealName,
+		)
+		extendedJoinMsg.Tags = joinMsg.Tags
+
+		addHistory(s, u, cnl.String(), joinMsg)
+
+		joinMsgFor := func(member *user.User) *message.Message {
+			if member.HasCap(capability.ExtendedJoin) {
+				return extendedJoinMsg
+			}
+
+			return joinMsg
+		}
+
+		u.SendMessage(joinMsgFor(u))
+
+		s.BroadcastMessageFunc(cnl.Id, joinMsgFor, nil)
 		s.JoinChannel(u.Id, cnl.Id)
 
-		// @Todo: Fix duplicated created channels in client side
+		if created {
+			cnl.SetPrivilege(u.Id, channel.MODE_OPERATOR)
+			cnl.MarkMode(channel.MODE_NO_MESSAGE)
+			cnl.MarkMode(channel.MODE_TOPIC)
+
+			u.SendMessage(message.New(
+				s.Config.Server.Name,
+				"MODE",
+				[]string{cnl.String(), "+nt"},
+				nil,
+			))
+		}
+
+		if record != nil {
+			applyAccess(s, u, cnl, record)
+		}
 
+		if u.IsAway() {
+			awayMsg := message.New(
+				u.Full(),
+				"AWAY",
+				nil,
+				u.AwayMsg(),
+			)
+
+			s.BroadcastMessageFunc(cnl.Id, func(member *user.User) *message.Message {
+				if member.HasCap(capability.AwayNotify) {
+					return awayMsg
+				}
+
+				return nil
+			}, []int{u.Id})
+		}
+
+		if cnl.Topic() != "" {
+			u.SendMessage(message.New(
+				s.Config.Server.Name,
+				message.RPL_TOPIC,
+				[]string{
+					u.NickName,
+					cnl.String(),
+				},
+				cnl.Topic(),
+			))
+		}
+
+		sendNames(s, u, cnl)
+		sendMetadataOnJoin(s, u, cnl)
 	}
 
 	return nil
 }
+
+// canJoin checks the bans, key, limit and invite-only mode of a channel,
+// telling the user why he can't join. Accounts with access to a registered
+// channel always may.
+func canJoin(s *server.Server, u *user.User, cnl *channel.Channel, key string) bool {
+	if record := registeredChannel(s, cnl); record != nil && record.Level(u.Account()) != "" {
+		return true
+	}
+
+	if u.Can(user.OperOverrideChannelModes) {
+		return true
+	}
+
+	var (
+		code   string
+		reason string
+	)
+
+	switch {
+	case cnl.HasMode(channel.MODE_INVITE) && !cnl.IsInvited(u.Id):
+		code, reason = message.ERR_INVITEONLYCHAN, "Cannot join channel (+i)"
+
+	case cnl.IsBanned(u.Full()) && !cnl.IsInvited(u.Id):
+		code, reason = message.ERR_BANNEDFROMCHAN, "Cannot join channel (+b)"
+
+	case cnl.HasMode(channel.MODE_KEY) && cnl.Key() != key:
+		code, reason = message.ERR_BADCHANNELKEY, "Cannot join channel (+k)"
+
+	case cnl.HasMode(channel.MODE_LIMIT) && s.ChannelUserCount(cnl.Id) >= cnl.Limit():
+		code, reason = message.ERR_CHANNELISFULL, "Cannot join channel (+l)"
+
+	default:
+		return true
+	}
+
+	u.SendMessage(message.New(
+		s.Config.Server.Name,
+		code,
+		[]string{
+			u.NickName,
+			cnl.String(),
+		},
+		reason,
+	))
+
+	return false
+}
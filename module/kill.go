@@ -0,0 +1,99 @@
+/*
+ * Copyright 2014 The starfruit Authors. All rights reserved.
+ * Use of this source code is governed by a BSD-style
+ * license that can be found in the LICENSE file.
+ */
+
+package module
+
+import (
+	"github.com/flatpeach/starfruit/message"
+	"github.com/flatpeach/starfruit/server"
+	"github.com/flatpeach/starfruit/user"
+	"log"
+)
+
+type Kill struct{}
+
+func (module *Kill) Handle(s *server.Server, u *user.User, m *message.Message) error {
+	// KILL <nickname> <comment>
+
+	if !requireCapability(s, u, user.OperKill) {
+		return nil
+	}
+
+	if len(m.Params) < 2 {
+		u.SendErrorNeedMoreParams("KILL")
+		return nil
+	}
+
+	if isServiceName(m.Params[0]) {
+		u.SendMessage(message.New(
+			s.Config.Server.Name,
+			message.ERR_CANTKILLSERVER,
+			[]string{u.NickName},
+			"You can't kill a service",
+		))
+
+		return nil
+	}
+
+	target := s.GetUserByNickName(m.Params[0])
+	if target == nil {
+		u.SendMessage(message.New(
+			s.Config.Server.Name,
+			message.ERR_NOSUCHNICK,
+			[]string{u.NickName, m.Params[0]},
+			"No such nick/channel",
+		))
+
+		return nil
+	}
+
+	comment := m.Params[1]
+
+	log.Printf("[KILL] %s killed %s (%s)", u.Full(), target.Full(), comment)
+
+	target.Deliver(message.New(
+		u.Full(),
+		"KILL",
+		[]string{target.NickName},
+		comment,
+	))
+
+	disconnectUser(s, target, "Killed ("+u.NickName+" ("+comment+"))")
+
+	return nil
+}
+
+// disconnectUser closes the connection of a user the server throws out, his
+// channel neighbours see him quit with the reason.
+func disconnectUser(s *server.Server, u *user.User, reason string) {
+	if u.IsDisconnecting() {
+		return
+	}
+
+	quitMsg := message.New(
+		u.Full(),
+		"QUIT",
+		nil,
+		reason,
+	)
+	quitMsg.Stamp()
+
+	for _, neighbour := range s.GetNeighbours(u.Id) {
+		neighbour.Deliver(quitMsg)
+	}
+
+	s.RemoveUser(u.Id)
+
+	u.Deliver(message.New(
+		nil,
+		"ERROR",
+		nil,
+		"Closing Link: "+u.HostName+" ("+reason+")",
+	))
+
+	u.Deliver(nil)
+	u.EnterStatus(user.StatusDisconnecting)
+}
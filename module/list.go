@@ -39,6 +39,10 @@ func (module *List) Handle(s *server.Server, u *user.User, m *message.Message) e
 	}
 
 	for _, cnl := range channelsToList {
+		if !isChannelVisible(s, u, cnl) {
+			continue
+		}
+
 		u.SendMessage(message.New(
 			s.Config.Server.Name,
 			message.RPL_LIST,
@@ -60,3 +64,14 @@ func (module *List) Handle(s *server.Server, u *user.User, m *message.Message) e
 
 	return nil
 }
+
+// isChannelVisible tells whether a user may know about a channel, secret and
+// private channels are only shown to their members and to operators with the
+// see-secret capability.
+func isChannelVisible(s *server.Server, u *user.User, cnl *channel.Channel) bool {
+	if !cnl.HasMode(channel.MODE_SECRET) && !cnl.HasMode(channel.MODE_PRIVATE) {
+		return true
+	}
+
+	return s.IsUserJoinedChannel(u.Id, cnl.Id) || u.Can(user.OperSeeSecret)
+}
@@ -0,0 +1,287 @@
+/*
+ * Copyright 2014 The starfruit Authors. All rights reserved.
+ * Use of this source code is governed by a BSD-style
+ * license that can be found in the LICENSE file.
+ */
+
+package module
+
+import (
+	"errors"
+	"fmt"
+	"github.com/flatpeach/starfruit/account"
+	"github.com/flatpeach/starfruit/message"
+	"github.com/flatpeach/starfruit/server"
+	"github.com/flatpeach/starfruit/user"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// MemoServ is the service users leave messages to absent accounts with
+var memoServ = &service{
+	Name: "MemoServ",
+	Commands: map[string]*serviceCommand{
+		"SEND": {
+			Syntax: "<account> <text>",
+			Help:   "Leaves a memo to an account",
+			Handle: memoServSend,
+		},
+		"LIST": {
+			Help:   "Lists your memos",
+			Handle: memoServList,
+		},
+		"READ": {
+			Syntax: "<number> | NEW",
+			Help:   "Reads a memo, or all the unread ones",
+			Handle: memoServRead,
+		},
+		"DEL": {
+			Syntax: "<number> | ALL",
+			Help:   "Deletes a memo, or all of them",
+			Handle: memoServDel,
+		},
+	},
+}
+
+var (
+	errMemoBoxFull = errors.New("Memo box full")
+	errNoSuchMemo  = errors.New("No such memo")
+)
+
+func init() {
+	registerService(memoServ)
+}
+
+type Memo struct{}
+
+func (module *Memo) Handle(s *server.Server, u *user.User, m *message.Message) error {
+	// MEMO <command> *( <parameter> )
+
+	if len(m.Params) == 0 {
+		u.SendErrorNeedMoreParams("MEMO")
+		return nil
+	}
+
+	memoServ.handle(s, u, strings.Join(m.Params, " "))
+
+	return nil
+}
+
+// notifyMemos tells a user who logged in how many memos he didn't read
+func notifyMemos(s *server.Server, u *user.User, a *account.Account) {
+	if n := a.UnreadMemos(); n > 0 {
+		memoServ.reply(s, u, fmt.Sprintf("You have %d unread memo(s), use MEMO LIST to see them", n))
+	}
+}
+
+// memoAccount returns the account of a user managing his memos
+func memoAccount(s *server.Server, u *user.User, svc *service) (*account.Account, bool) {
+	if !u.IsLoggedIn() {
+		svc.reply(s, u, "You must be logged in to use memos")
+		return nil, false
+	}
+
+	if isExternal(u.Account()) {
+		svc.reply(s, u, "Memos are only kept for the accounts registered on this server")
+		return nil, false
+	}
+
+	a, err := s.Accounts.Get(u.Account())
+	if err != nil {
+		svc.reply(s, u, "Your account can't be found")
+		return nil, false
+	}
+
+	return a, true
+}
+
+// updateMemos changes the memos of the account a user is logged in to
+func updateMemos(s *server.Server, u *user.User, f func(a *account.Account) error) error {
+	_, err := s.Accounts.Update(u.Account(), f)
+	if err != nil && err != errNoSuchMemo {
+		log.Printf("[MEMOSERV] Failed to save the memos of %s :%s", u.Account(), err)
+	}
+
+	return err
+}
+
+func memoServSend(s *server.Server, u *user.User, svc *service, args []string) {
+	if len(args) < 2 {
+		svc.replySyntax(s, u, "SEND")
+		return
+	}
+
+	sender, ok := memoAccount(s, u, svc)
+	if !ok {
+		return
+	}
+
+	memo := &account.Memo{
+		From:   sender.Name,
+		Text:   strings.Join(args[1:], " "),
+		SentAt: time.Now().Unix(),
+	}
+
+	a, err := s.Accounts.Update(args[0], func(a *account.Account) error {
+		if !a.AddMemo(memo, s.Config.Memo.MaxMemos) {
+			return errMemoBoxFull
+		}
+
+		return nil
+	})
+
+	switch {
+	case err == account.ErrNotFound:
+		svc.reply(s, u, "Account "+args[0]+" is not registered")
+		return
+
+	case err == errMemoBoxFull:
+		svc.reply(s, u, "The memo box of "+args[0]+" is full")
+		return
+
+	case err != nil:
+		log.Printf("[MEMOSERV] Failed to save the memos of %s :%s", args[0], err)
+		svc.reply(s, u, "The memo can't be sent right now")
+		return
+	}
+
+	svc.reply(s, u, "Memo sent to "+a.Name)
+
+	// Users logged in to the account are told right away
+	for _, recipient := range s.GetAllUsers() {
+		if recipient.IsRegistered() && account.Key(recipient.Account()) == account.Key(a.Name) {
+			svc.notify(s, recipient, fmt.Sprintf(
+				"You have a new memo from %s, use MEMO READ %d to read it",
+				sender.Name,
+				len(a.Memos),
+			))
+		}
+	}
+}
+
+func memoServList(s *server.Server, u *user.User, svc *service, args []string) {
+	a, ok := memoAccount(s, u, svc)
+	if !ok {
+		return
+	}
+
+	if len(a.Memos) == 0 {
+		svc.reply(s, u, "You have no memos")
+		return
+	}
+
+	for idx, memo := range a.Memos {
+		status := ""
+		if !memo.Read {
+			status = " (unread)"
+		}
+
+		svc.reply(s, u, fmt.Sprintf("%d. From %s on %s%s", idx+1, memo.From, memoTime(memo), status))
+	}
+}
+
+func memoServRead(s *server.Server, u *user.User, svc *service, args []string) {
+	if len(args) == 0 {
+		svc.replySyntax(s, u, "READ")
+		return
+	}
+
+	a, ok := memoAccount(s, u, svc)
+	if !ok {
+		return
+	}
+
+	var numbers []int
+
+	if strings.EqualFold(args[0], "NEW") {
+		for idx, memo := range a.Memos {
+			if !memo.Read {
+				numbers = append(numbers, idx+1)
+			}
+		}
+
+		if len(numbers) == 0 {
+			svc.reply(s, u, "You have no unread memos")
+			return
+		}
+	} else {
+		n, err := strconv.Atoi(args[0])
+		if err != nil || n < 1 || n > len(a.Memos) {
+			svc.reply(s, u, "No such memo "+args[0])
+			return
+		}
+
+		numbers = append(numbers, n)
+	}
+
+	for _, n := range numbers {
+		memo := a.Memos[n-1]
+
+		svc.reply(s, u, fmt.Sprintf("Memo %d from %s on %s:", n, memo.From, memoTime(memo)))
+		svc.reply(s, u, memo.Text)
+	}
+
+	updateMemos(s, u, func(a *account.Account) error {
+		for _, n := range numbers {
+			if n <= len(a.Memos) {
+				a.Memos[n-1].Read = true
+			}
+		}
+
+		return nil
+	})
+}
+
+func memoServDel(s *server.Server, u *user.User, svc *service, args []string) {
+	if len(args) == 0 {
+		svc.replySyntax(s, u, "DEL")
+		return
+	}
+
+	if _, ok := memoAccount(s, u, svc); !ok {
+		return
+	}
+
+	if strings.EqualFold(args[0], "ALL") {
+		err := updateMemos(s, u, func(a *account.Account) error {
+			a.Memos = nil
+			return nil
+		})
+		if err != nil {
+			svc.reply(s, u, "Your memos can't be deleted right now")
+			return
+		}
+
+		svc.reply(s, u, "All your memos were deleted")
+		return
+	}
+
+	n, err := strconv.Atoi(args[0])
+	if err == nil {
+		err = updateMemos(s, u, func(a *account.Account) error {
+			if !a.DeleteMemo(n) {
+				return errNoSuchMemo
+			}
+
+			return nil
+		})
+
+		if err != nil && err != errNoSuchMemo {
+			svc.reply(s, u, "Your memos can't be deleted right now")
+			return
+		}
+	}
+
+	if err != nil {
+		svc.reply(s, u, "No such memo "+args[0])
+		return
+	}
+
+	svc.reply(s, u, "Memo "+args[0]+" deleted")
+}
+
+func memoTime(memo *account.Memo) string {
+	return time.Unix(memo.SentAt, 0).UTC().Format(time.RFC1123)
+}
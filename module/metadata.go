@@ -0,0 +1,465 @@
+/*
+ * Copyright 2014 The starfruit Authors. All rights reserved.
+ * Use of this source code is governed by a BSD-style
+ * license that can be found in the LICENSE file.
+ */
+
+package module
+
+import (
+	"github.com/flatpeach/starfruit/account"
+	"github.com/flatpeach/starfruit/channel"
+	"github.com/flatpeach/starfruit/message"
+	"github.com/flatpeach/starfruit/metadata"
+	"github.com/flatpeach/starfruit/server"
+	"github.com/flatpeach/starfruit/user"
+	"log"
+	"strings"
+)
+
+// Visibility of the keys listed as private in the config
+const visibilityPrivate = "private"
+
+// Most keys listed on a single RPL_METADATASUBS line
+const maxSubsPerLine = 20
+
+type Metadata struct{}
+
+// metadataOwner is the user or the channel some metadata belongs to
+type metadataOwner struct {
+	name    string
+	values  *metadata.Map
+	user    *user.User
+	channel *channel.Channel
+}
+
+func (module *Metadata) Handle(s *server.Server, u *user.User, m *message.Message) error {
+	// METADATA <target> GET <key> *( <key> )
+	// METADATA <target> LIST
+	// METADATA <target> SET <key> [ <value> ]
+	// METADATA <target> CLEAR
+	// METADATA <target> SYNC
+	// METADATA * SUB <key> *( <key> )
+	// METADATA * UNSUB <key> *( <key> )
+	// METADATA * SUBS
+
+	if len(m.Params) < 2 {
+		u.SendFail("METADATA", "NEED_MORE_PARAMS", nil, "Not enough parameters")
+		return nil
+	}
+
+	subcommand := strings.ToUpper(m.Params[1])
+	args := m.Params[2:]
+
+	switch subcommand {
+	case "SUB":
+		metadataSub(s, u, args)
+		return nil
+
+	case "UNSUB":
+		metadataUnsub(s, u, args)
+		return nil
+
+	case "SUBS":
+		metadataSubs(s, u)
+		return nil
+
+	case "GET", "LIST", "SET", "CLEAR", "SYNC":
+
+	default:
+		u.SendFail("METADATA", "SUBCOMMAND_INVALID", []string{m.Params[1]}, "Unknown subcommand")
+		return nil
+	}
+
+	owner := findMetadataOwner(s, u, m.Params[0])
+	if owner == nil {
+		u.SendFail("METADATA", "INVALID_TARGET", []string{m.Params[0]}, "No such nick or channel")
+		return nil
+	}
+
+	switch subcommand {
+	case "GET":
+		metadataGet(s, u, owner, args)
+
+	case "LIST":
+		var msgs []*message.Message
+
+		for _, key := range owner.values.Keys() {
+			if canSeeKey(s, u, owner, key) {
+				value, _ := owner.values.Get(key)
+				msgs = append(msgs, keyValueMsg(s, u, owner, key, value))
+			}
+		}
+
+		u.SendBatch("metadata", nil, msgs)
+
+	case "SET":
+		metadataSet(s, u, owner, args)
+
+	case "CLEAR":
+		if !canEditMetadata(u, owner) {
+			u.SendFail("METADATA", "KEY_NO_PERMISSION", []string{owner.name, "*"}, "You're not allowed to change this metadata")
+			return nil
+		}
+
+		var msgs []*message.Message
+
+		for _, key := range owner.values.Clear() {
+			msgs = append(msgs, keyNotSetMsg(s, u, owner, key))
+			notifyMetadata(s, u, owner, key, nil)
+		}
+
+		u.SendBatch("metadata", nil, msgs)
+
+		saveMetadata(s, owner)
+
+	case "SYNC":
+		u.SendBatch("metadata", nil, subscribedMetadata(s, u, owner))
+	}
+
+	return nil
+}
+
+// findMetadataOwner finds a channel or a user by name, "*" being the user
+// himself. Channels the user can't see are never found.
+func findMetadataOwner(s *server.Server, u *user.User, target string) *metadataOwner {
+	if target == "*" {
+		target = u.NickName
+	}
+
+	if target == "" {
+		return nil
+	}
+
+	if strings.ContainsAny(target[0:1], channel.NS_ALL_RAW) {
+		cnl := s.FindChannelByName(target)
+		if cnl == nil || !canSeeChannel(s, u, cnl) {
+			return nil
+		}
+
+		return &metadataOwner{name: cnl.String(), values: cnl.Metadata, channel: cnl}
+	}
+
+	targetUser := s.GetUserByNickName(target)
+	if targetUser == nil {
+		return nil
+	}
+
+	return &metadataOwner{name: targetUser.NickName, values: targetUser.Metadata, user: targetUser}
+}
+
+func metadataGet(s *server.Server, u *user.User, owner *metadataOwner, keys []string) {
+	if len(keys) == 0 {
+		u.SendFail("METADATA", "NEED_MORE_PARAMS", []string{"GET"}, "Not enough parameters")
+		return
+	}
+
+	var msgs []*message.Message
+
+	for _, key := range keys {
+		if !metadata.ValidKey(key) {
+			u.SendFail("METADATA", "KEY_INVALID", []string{key}, "Invalid key")
+			continue
+		}
+
+		if !canSeeKey(s, u, owner, key) {
+			u.SendFail("METADATA", "KEY_NO_PERMISSION", []string{owner.name, key}, "You're not allowed to see this key")
+			continue
+		}
+
+		if value, exists := owner.values.Get(key); exists {
+			msgs = append(msgs, keyValueMsg(s, u, owner, key, value))
+		} else {
+			msgs = append(msgs, keyNotSetMsg(s, u, owner, key))
+		}
+	}
+
+	u.SendBatch("metadata", nil, msgs)
+}
+
+func metadataSet(s *server.Server, u *user.User, owner *metadataOwner, args []string) {
+	if len(args) == 0 {
+		u.SendFail("METADATA", "NEED_MORE_PARAMS", []string{"SET"}, "Not enough parameters")
+		return
+	}
+
+	key := args[0]
+	cf := s.Config.Metadata
+
+	if !metadata.ValidKey(key) {
+		u.SendFail("METADATA", "KEY_INVALID", []string{key}, "Invalid key")
+		return
+	}
+
+	if !canEditMetadata(u, owner) {
+		u.SendFail("METADATA", "KEY_NO_PERMISSION", []string{owner.name, key}, "You're not allowed to change this metadata")
+		return
+	}
+
+	// Without a value the key is deleted
+	if len(args) == 1 {
+		owner.values.Delete(key)
+
+		u.SendMessage(keyNotSetMsg(s, u, owner, key))
+		notifyMetadata(s, u, owner, key, nil)
+		saveMetadata(s, owner)
+
+		return
+	}
+
+	value := args[1]
+
+	if len(value) > cf.MaxValueBytes {
+		u.SendFail("METADATA", "VALUE_INVALID", nil, "Value is too long")
+		return
+	}
+
+	if _, exists := owner.values.Get(key); !exists && owner.values.Len() >= cf.MaxKeys {
+		u.SendFail("METADATA", "LIMIT_REACHED", []string{owner.name}, "Too many keys")
+		return
+	}
+
+	owner.values.Set(key, value)
+
+	u.SendMessage(keyValueMsg(s, u, owner, key, value))
+	notifyMetadata(s, u, owner, key, value)
+	saveMetadata(s, owner)
+}
+
+func metadataSub(s *server.Server, u *user.User, keys []string) {
+	if len(keys) == 0 {
+		u.SendFail("METADATA", "NEED_MORE_PARAMS", []string{"SUB"}, "Not enough parameters")
+		return
+	}
+
+	var subscribed []string
+
+	for _, key := range keys {
+		if !metadata.ValidKey(key) {
+			u.SendFail("METADATA", "KEY_INVALID", []string{key}, "Invalid key")
+			continue
+		}
+
+		if !u.IsSubscribed(key) && len(u.Subscriptions()) >= s.Config.Metadata.MaxSubs {
+			u.SendFail("METADATA", "TOO_MANY_SUBS", []string{key}, "Too many subscriptions")
+			break
+		}
+
+		u.Subscribe(key)
+		subscribed = append(subscribed, key)
+	}
+
+	if len(subscribed) > 0 {
+		u.SendMessage(message.New(
+			s.Config.Server.Name,
+			message.RPL_METADATASUBOK,
+			append([]string{u.NickName}, subscribed...),
+			nil,
+		))
+	}
+}
+
+func metadataUnsub(s *server.Server, u *user.User, keys []string) {
+	if len(keys) == 0 {
+		u.SendFail("METADATA", "NEED_MORE_PARAMS", []string{"UNSUB"}, "Not enough parameters")
+		return
+	}
+
+	var unsubscribed []string
+
+	for _, key := range keys {
+		if !metadata.ValidKey(key) {
+			u.SendFail("METADATA", "KEY_INVALID", []string{key}, "Invalid key")
+			continue
+		}
+
+		u.Unsubscribe(key)
+		unsubscribed = append(unsubscribed, key)
+	}
+
+	if len(unsubscribed) > 0 {
+		u.SendMessage(message.New(
+			s.Config.Server.Name,
+			message.RPL_METADATAUNSUBOK,
+			append([]string{u.NickName}, unsubscribed...),
+			nil,
+		))
+	}
+}
+
+func metadataSubs(s *server.Server, u *user.User) {
+	var msgs []*message.Message
+
+	keys := u.Subscriptions()
+	for len(keys) > 0 {
+		n := len(keys)
+		if n > maxSubsPerLine {
+			n = maxSubsPerLine
+		}
+
+		msgs = append(msgs, message.New(
+			s.Config.Server.Name,
+			message.RPL_METADATASUBS,
+			append([]string{u.NickName}, keys[:n]...),
+			nil,
+		))
+
+		keys = keys[n:]
+	}
+
+	u.SendBatch("metadata-subs", nil, msgs)
+}
+
+// sendMetadataOnJoin tells a user joining a channel about the metadata of
+// the channel and its members, and the members about his, as subscribed.
+func sendMetadataOnJoin(s *server.Server, u *user.User, cnl *channel.Channel) {
+	owner := &metadataOwner{name: cnl.String(), values: cnl.Metadata, channel: cnl}
+
+	msgs := subscribedMetadata(s, u, owner)
+
+	for _, member := range s.GetJoinedUsers(cnl.Id) {
+		if member.Id == u.Id {
+			continue
+		}
+
+		memberOwner := &metadataOwner{name: member.NickName, values: member.Metadata, user: member}
+		msgs = append(msgs, subscribedMetadata(s, u, memberOwner)...)
+
+		memberMsgs := subscribedMetadata(s, member, &metadataOwner{
+			name:   u.NickName,
+			values: u.Metadata,
+			user:   u,
+		})
+		if len(memberMsgs) > 0 {
+			member.DeliverBatch("metadata", nil, nil, memberMsgs)
+		}
+	}
+
+	if len(msgs) > 0 {
+		u.SendBatch("metadata", nil, msgs)
+	}
+}
+
+// subscribedMetadata returns the METADATA messages telling a user about the
+// keys of an owner he subscribed to.
+func subscribedMetadata(s *server.Server, u *user.User, owner *metadataOwner) []*message.Message {
+	var msgs []*message.Message
+
+	for _, key := range owner.values.Keys() {
+		if !u.IsSubscribed(key) || !canSeeKey(s, u, owner, key) {
+			continue
+		}
+
+		value, _ := owner.values.Get(key)
+		msgs = append(msgs, message.New(
+			s.Config.Server.Name,
+			"METADATA",
+			[]string{owner.name, key, visibility(s, key)},
+			value,
+		))
+	}
+
+	return msgs
+}
+
+// notifyMetadata tells the users subscribed to a key about its new value,
+// or its removal if the value is nil. The user making the change is not.
+func notifyMetadata(s *server.Server, u *user.User, owner *metadataOwner, key string, value interface{}) {
+	notifyMsg := message.New(
+		u.Full(),
+		"METADATA",
+		[]string{owner.name, key, visibility(s, key)},
+		value,
+	)
+
+	var recipients []*user.User
+
+	if owner.channel != nil {
+		recipients = s.GetJoinedUsers(owner.channel.Id)
+	} else {
+		recipients = append(s.GetNeighbours(owner.user.Id), owner.user)
+	}
+
+	for _, recipient := range recipients {
+		if recipient.Id == u.Id || !recipient.IsSubscribed(key) || !canSeeKey(s, recipient, owner, key) {
+			continue
+		}
+
+		recipient.Deliver(notifyMsg)
+	}
+}
+
+// saveMetadata keeps the metadata of users logged in with their account, and
+// the one of registered channels.
+func saveMetadata(s *server.Server, owner *metadataOwner) {
+	if owner.channel != nil {
+		saveChannel(s, owner.channel)
+		return
+	}
+
+	if owner.user == nil || !owner.user.IsLoggedIn() {
+		return
+	}
+
+	name := owner.user.Account()
+
+	_, err := s.Accounts.Update(name, func(a *account.Account) error {
+		a.Metadata = owner.values.All()
+		return nil
+	})
+	if err != nil && err != account.ErrNotFound {
+		log.Printf("[METADATA] Failed to save the metadata of %s :%s", name, err)
+	}
+}
+
+// canSeeKey tells whether a user may see a key, private keys are only shown
+// to their owner and operators.
+func canSeeKey(s *server.Server, u *user.User, owner *metadataOwner, key string) bool {
+	if visibility(s, key) == metadata.Public {
+		return true
+	}
+
+	return canEditMetadata(u, owner)
+}
+
+// canEditMetadata tells whether a user may change some metadata, users may
+// change theirs, channel operators the one of their channel.
+func canEditMetadata(u *user.User, owner *metadataOwner) bool {
+	if u.Can(user.OperServices) {
+		return true
+	}
+
+	if owner.channel != nil {
+		return owner.channel.IsOperator(u.Id)
+	}
+
+	return owner.user.Id == u.Id
+}
+
+func visibility(s *server.Server, key string) string {
+	for _, private := range s.Config.Metadata.PrivateKeys {
+		if private == key {
+			return visibilityPrivate
+		}
+	}
+
+	return metadata.Public
+}
+
+func keyValueMsg(s *server.Server, u *user.User, owner *metadataOwner, key string, value string) *message.Message {
+	return message.New(
+		s.Config.Server.Name,
+		message.RPL_KEYVALUE,
+		[]string{u.NickName, owner.name, key, visibility(s, key)},
+		value,
+	)
+}
+
+func keyNotSetMsg(s *server.Server, u *user.User, owner *metadataOwner, key string) *message.Message {
+	return message.New(
+		s.Config.Server.Name,
+		message.RPL_KEYNOTSET,
+		[]string{u.NickName, owner.name, key},
+		"key not set",
+	)
+}
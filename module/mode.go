@@ -7,21 +7,28 @@
 package module
 
 import (
+	"github.com/flatpeach/starfruit/channel"
 	"github.com/flatpeach/starfruit/message"
 	"github.com/flatpeach/starfruit/server"
 	"github.com/flatpeach/starfruit/user"
+	"strings"
 )
 
 type Mode struct{}
 
 func (module *Mode) Handle(s *server.Server, u *user.User, m *message.Message) error {
 	// MODE <nickname> *( ( "+" / "-" ) *( "i" / "w" / "o" / "O" / "r" ) )
+	// MODE <channel> *( ( "-" / "+" ) *<modes> *<modeparams> )
 
-	if len(m.Params) < 1 {
+	if len(m.Params) < 1 || m.Params[0] == "" {
 		u.SendErrorNeedMoreParams("MODE")
 		return nil
 	}
 
+	if strings.ContainsAny(m.Params[0][0:1], channel.NS_ALL_RAW) {
+		return channelMode(s, u, m)
+	}
+
 	nickName := m.Params[0]
 	if u.NickName != nickName {
 		u.SendMessage(message.New(
@@ -53,7 +60,7 @@ func (module *Mode) Handle(s *server.Server, u *user.User, m *message.Message) e
 
 	modes := m.Params[1]
 
-	if modes[0] != '+' && modes[0] != '-' {
+	if modes == "" || (modes[0] != '+' && modes[0] != '-') {
 		return nil
 	}
 
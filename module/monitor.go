@@ -0,0 +1,156 @@
+/*
+ * Copyright 2014 The starfruit Authors. All rights reserved.
+ * Use of this source code is governed by a BSD-style
+ * license that can be found in the LICENSE file.
+ */
+
+package module
+
+import (
+	"github.com/flatpeach/starfruit/message"
+	"github.com/flatpeach/starfruit/server"
+	"github.com/flatpeach/starfruit/user"
+	"strconv"
+	"strings"
+)
+
+// Longest list of targets sent in a single MONITOR reply
+const maxMonitorReplyLength = 400
+
+type Monitor struct{}
+
+func (module *Monitor) Handle(s *server.Server, u *user.User, m *message.Message) error {
+	// MONITOR + <target> *( "," <target> )
+	// MONITOR - <target> *( "," <target> )
+	// MONITOR C
+	// MONITOR L
+	// MONITOR S
+
+	if len(m.Params) == 0 {
+		u.SendErrorNeedMoreParams("MONITOR")
+		return nil
+	}
+
+	switch strings.ToUpper(m.Params[0]) {
+	case "+":
+		if len(m.Params) < 2 {
+			u.SendErrorNeedMoreParams("MONITOR")
+			return nil
+		}
+
+		addMonitor(s, u, monitorTargets(m.Params[1]))
+
+	case "-":
+		if len(m.Params) < 2 {
+			u.SendErrorNeedMoreParams("MONITOR")
+			return nil
+		}
+
+		for _, target := range monitorTargets(m.Params[1]) {
+			s.Unmonitor(u.Id, target)
+		}
+
+	case "C":
+		s.ClearMonitor(u.Id)
+
+	case "L":
+		sendMonitorReplies(s, u, message.RPL_MONLIST, s.MonitoredNickNames(u.Id))
+
+		u.SendMessage(message.New(
+			s.Config.Server.Name,
+			message.RPL_ENDOFMONLIST,
+			[]string{u.NickName},
+			"End of MONITOR list",
+		))
+
+	case "S":
+		sendMonitorStatus(s, u, s.MonitoredNickNames(u.Id))
+	}
+
+	return nil
+}
+
+// monitorTargets splits a comma separated list of nicks, skipping the ones
+// which can't be nicks.
+func monitorTargets(list string) []string {
+	var targets []string
+
+	for _, target := range strings.Split(list, ",") {
+		if target == "" || len(target) > user.MaxNickNameLength {
+			continue
+		}
+
+		targets = append(targets, target)
+	}
+
+	return targets
+}
+
+// addMonitor monitors the given nicks until the limit is reached, the user
+// is told which ones are online right away.
+func addMonitor(s *server.Server, u *user.User, targets []string) {
+	limit := s.Config.Monitor.MaxTargets
+
+	var added []string
+
+	for idx, target := range targets {
+		if s.IsMonitored(u.Id, target) {
+			added = append(added, target)
+			continue
+		}
+
+		if limit > 0 && len(s.MonitoredNickNames(u.Id)) >= limit {
+			u.SendMessage(message.New(
+				s.Config.Server.Name,
+				message.ERR_MONLISTFULL,
+				[]string{u.NickName, strconv.Itoa(limit), strings.Join(targets[idx:], ",")},
+				"Monitor list is full.",
+			))
+
+			break
+		}
+
+		s.Monitor(u, target)
+		added = append(added, target)
+	}
+
+	sendMonitorStatus(s, u, added)
+}
+
+// sendMonitorStatus tells a user which of the given nicks are online
+func sendMonitorStatus(s *server.Server, u *user.User, targets []string) {
+	var online, offline []string
+
+	for _, target := range targets {
+		if targetUser := s.GetUserByNickName(target); targetUser != nil {
+			online = append(online, targetUser.Full())
+		} else {
+			offline = append(offline, target)
+		}
+	}
+
+	sendMonitorReplies(s, u, message.RPL_MONONLINE, online)
+	sendMonitorReplies(s, u, message.RPL_MONOFFLINE, offline)
+}
+
+// sendMonitorReplies sends a list of targets, split over as many replies as
+// needed to keep them short.
+func sendMonitorReplies(s *server.Server, u *user.User, code string, targets []string) {
+	for len(targets) > 0 {
+		n, length := 0, 0
+
+		for n < len(targets) && (n == 0 || length+len(targets[n])+1 <= maxMonitorReplyLength) {
+			length += len(targets[n]) + 1
+			n++
+		}
+
+		u.SendMessage(message.New(
+			s.Config.Server.Name,
+			code,
+			[]string{u.NickName},
+			strings.Join(targets[:n], ","),
+		))
+
+		targets = targets[n:]
+	}
+}
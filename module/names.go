@@ -0,0 +1,131 @@
+/*
+ * Copyright 2014 The starfruit Authors. All rights reserved.
+ * Use of this source code is governed by a BSD-style
+ * license that can be found in the LICENSE file.
+ */
+
+package module
+
+import (
+	"github.com/flatpeach/starfruit/capability"
+	"github.com/flatpeach/starfruit/channel"
+	"github.com/flatpeach/starfruit/message"
+	"github.com/flatpeach/starfruit/server"
+	"github.com/flatpeach/starfruit/user"
+	"strings"
+)
+
+type Names struct{}
+
+func (module *Names) Handle(s *server.Server, u *user.User, m *message.Message) error {
+	// NAMES [ <channel> *( "," <channel> ) ]
+
+	var channelsToList []*channel.Channel
+
+	if len(m.Params) == 0 {
+		channelsToList = s.GetAllChannels()
+	} else {
+		for _, channelName := range strings.Split(m.Params[0], ",") {
+			cnl := s.FindChannelByName(channelName)
+			if cnl != nil {
+				channelsToList = append(channelsToList, cnl)
+			}
+		}
+	}
+
+	for _, cnl := range channelsToList {
+		joined := s.IsUserJoinedChannel(u.Id, cnl.Id)
+		if !joined && (cnl.HasMode(channel.MODE_SECRET) || cnl.HasMode(channel.MODE_PRIVATE)) {
+			continue
+		}
+
+		sendNames(s, u, cnl)
+	}
+
+	if len(m.Params) == 0 || len(channelsToList) == 0 {
+		target := "*"
+		if len(m.Params) > 0 {
+			target = m.Params[0]
+		}
+
+		u.SendMessage(message.New(
+			s.Config.Server.Name,
+			message.RPL_ENDOFNAMES,
+			[]string{
+				u.NickName,
+				target,
+			},
+			"End of /NAMES list.",
+		))
+	}
+
+	return nil
+}
+
+// sendNames sends the members of a channel, with all their prefixes for
+// multi-prefix and their full nick!user@host for userhost-in-names.
+func sendNames(s *server.Server, u *user.User, cnl *channel.Channel) {
+	multiPrefix := u.HasCap(capability.MultiPrefix)
+	userhostInNames := u.HasCap(capability.UserhostInNames)
+
+	symbol := "="
+	if cnl.HasMode(channel.MODE_SECRET) {
+		symbol = "@"
+	} else if cnl.HasMode(channel.MODE_PRIVATE) {
+		symbol = "*"
+	}
+
+	// Room left for the names once the rest of the line is counted
+	size := 510 - len(":"+s.Config.Server.Name+" "+message.RPL_NAMREPLY+" "+
+		u.NickName+" "+symbol+" "+cnl.String()+" :")
+
+	var (
+		lines []string
+		line  string
+	)
+
+	for _, member := range s.GetJoinedUsers(cnl.Id) {
+		name := member.NickName
+		if userhostInNames {
+			name = member.Full()
+		}
+		name = cnl.Prefixes(member.Id, multiPrefix) + name
+
+		if line != "" && len(line)+1+len(name) > size {
+			lines = append(lines, line)
+			line = ""
+		}
+
+		if line != "" {
+			line += " "
+		}
+		line += name
+	}
+
+	if line != "" {
+		lines = append(lines, line)
+	}
+
+	for _, line := range lines {
+		u.SendMessage(message.New(
+			s.Config.Server.Name,
+			message.RPL_NAMREPLY,
+			[]string{
+				u.NickName,
+				symbol,
+				cnl.String(),
+			},
+			line,
+		))
+	}
+
+	u.SendMessage(message.New(
+		s.Config.Server.Name,
+		message.RPL_ENDOFNAMES,
+		[]string{
+			u.NickName,
+			cnl.String(),
+		},
+		"End of /NAMES list.",
+	))
+}
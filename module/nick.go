@@ -34,63 +34,82 @@ func (module *Nick) Handle(s *server.Server, u *user.User, m *message.Message) e
 
 	nickName := m.Params[0]
 
-	if u.IsRegistered() {
-		if s.IsNickNameRegistered(nickName) {
-			u.SendMessage(message.New(
-				s.Config.Server.Name,
-				message.ERR_NICKNAMEINUSE,
-				[]string{
-					"*",
-					u.NickName,
-				},
-				"Nickname is already in use",
-			))
-		}
-
-		nickChangedMsg := message.New(
-			u.Full(),
-			"NICK",
-			nil,
-			nickName,
-		)
-
-		oldNickName := u.NickName
-		u.NickName = nickName
-
-		s.RegisterNickName(u.NickName, u)
-		s.UnregisterNickName(oldNickName)
+	if len(nickName) > user.MaxNickNameLength {
+		u.SendMessage(message.New(
+			s.Config.Server.Name,
+			message.ERR_ERRONEUSNICKNAME,
+			[]string{
+				"*",
+				nickName,
+			},
+			"Erroneous nickname",
+		))
 
-		u.SendMessage(nickChangedMsg)
+		return nil
+	}
 
-		for _, c := range s.GetJoinedChannels(u.Id) {
-			s.BroadcastMessage(c.Id, nickChangedMsg, []int{u.Id})
-		}
+	if isServiceName(nickName) {
+		u.SendMessage(message.New(
+			s.Config.Server.Name,
+			message.ERR_ERRONEUSNICKNAME,
+			[]string{
+				"*",
+				nickName,
+			},
+			"Nickname is reserved for services",
+		))
 
 		return nil
 	}
 
-	u.NickName = nickName
-
-	if u.UserName != "" {
-		if s.IsNickNameRegistered(u.NickName) {
+	if u.IsRegistered() {
+		// Changing the case of his own nick is allowed
+		if other := s.GetUserByNickName(nickName); other != nil && other != u {
 			u.SendMessage(message.New(
 				s.Config.Server.Name,
 				message.ERR_NICKNAMEINUSE,
 				[]string{
 					"*",
-					u.NickName,
+					nickName,
 				},
 				"Nickname is already in use",
 			))
 
 			return nil
-		} else {
-			u.Id = s.NewUserId()
-			s.RegisterUser(u)
-			u.EnterStatus(user.StatusRegistered)
-			u.SendWelcomeMessage()
 		}
+
+		changeNickName(s, u, nickName)
+		enforceNickName(s, u)
+
+		return nil
 	}
 
+	u.SetNick(nickName)
+
+	completeRegistration(s, u)
+
 	return nil
 }
+
+// changeNickName renames a registered user, he and his channel neighbours
+// are told about it.
+func changeNickName(s *server.Server, u *user.User, nickName string) {
+	nickChangedMsg := message.New(
+		u.Full(),
+		"NICK",
+		nil,
+		nickName,
+	)
+	nickChangedMsg.Stamp()
+
+	oldNickName := u.NickName
+	u.SetNick(nickName)
+
+	s.ChangeNickName(u, oldNickName)
+
+	u.SendMessage(nickChangedMsg)
+
+	for _, c := range s.GetJoinedChannels(u.Id) {
+		s.BroadcastMessage(c.Id, nickChangedMsg, []int{u.Id})
+	}
+}
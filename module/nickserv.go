@@ -0,0 +1,305 @@
+/*
+ * Copyright 2014 The starfruit Authors. All rights reserved.
+ * Use of this source code is governed by a BSD-style
+ * license that can be found in the LICENSE file.
+ */
+
+package module
+
+import (
+	"errors"
+	"fmt"
+	"github.com/flatpeach/starfruit/account"
+	"github.com/flatpeach/starfruit/server"
+	"github.com/flatpeach/starfruit/user"
+	"log"
+	"strings"
+	"time"
+)
+
+// NickServ is the service users manage their account with
+var nickServ = &service{
+	Name: "NickServ",
+	Commands: map[string]*serviceCommand{
+		"REGISTER": {
+			Syntax: "<password> [email]",
+			Help:   "Registers your current nick as an account",
+			Handle: nickServRegister,
+		},
+		"VERIFY": {
+			Syntax: "<account> <code>",
+			Help:   "Verifies an account with the code sent to you",
+			Handle: nickServVerify,
+		},
+		"IDENTIFY": {
+			Syntax: "[account] <password>",
+			Help:   "Logs you in to an account",
+			Handle: nickServIdentify,
+		},
+		"SENDPASS": {
+			Syntax: "<account>",
+			Help:   "Emails a code resetting the password of an account",
+			Handle: nickServSendpass,
+		},
+		"RESETPASS": {
+			Syntax: "<account> <code> <password>",
+			Help:   "Sets a new password with the code emailed to you",
+			Handle: nickServResetpass,
+		},
+		"LOGOUT": {
+			Help:   "Logs you out of your account",
+			Handle: nickServLogout,
+		},
+		"GROUP": {
+			Help:   "Adds your current nick to the ones owned by your account",
+			Handle: nickServGroup,
+		},
+		"UNGROUP": {
+			Syntax: "<nick>",
+			Help:   "Releases a nick grouped with your account",
+			Handle: nickServUngroup,
+		},
+		"INFO": {
+			Syntax: "[account]",
+			Help:   "Shows information about an account",
+			Handle: nickServInfo,
+		},
+	},
+}
+
+var (
+	errTooManyNicks = errors.New("Too many grouped nicks")
+	errNotGrouped   = errors.New("Nick not grouped")
+)
+
+func init() {
+	registerService(nickServ)
+}
+
+func nickServRegister(s *server.Server, u *user.User, svc *service, args []string) {
+	if len(args) == 0 {
+		svc.replySyntax(s, u, "REGISTER")
+		return
+	}
+
+	email := "*"
+	if len(args) > 1 {
+		email = args[1]
+	}
+
+	a, err := registerAccount(s, u, "*", email, args[0])
+	if err != nil {
+		svc.reply(s, u, accountErrorText(err))
+		return
+	}
+
+	if !a.IsVerified() {
+		svc.reply(s, u, "Account "+a.Name+" created, verify it with the code sent to you: VERIFY "+a.Name+" <code>")
+		return
+	}
+
+	logIn(s, u, a.Name)
+	svc.reply(s, u, "Account "+a.Name+" registered, you're now logged in")
+}
+
+func nickServVerify(s *server.Server, u *user.User, svc *service, args []string) {
+	if len(args) < 2 {
+		svc.replySyntax(s, u, "VERIFY")
+		return
+	}
+
+	a, err := verifyAccount(s, u, args[0], args[1])
+	if err != nil {
+		svc.reply(s, u, accountErrorText(err))
+		return
+	}
+
+	logIn(s, u, a.Name)
+	svc.reply(s, u, "Account "+a.Name+" verified, you're now logged in")
+}
+
+func nickServIdentify(s *server.Server, u *user.User, svc *service, args []string) {
+	var name, password string
+
+	switch len(args) {
+	case 1:
+		name, password = u.NickName, args[0]
+	case 2:
+		name, password = args[0], args[1]
+	default:
+		svc.replySyntax(s, u, "IDENTIFY")
+		return
+	}
+
+	if u.IsLoggedIn() {
+		svc.reply(s, u, errAlreadyLoggedIn.Description)
+		return
+	}
+
+	a, err := s.Accounts.Get(name)
+	if err != nil || !a.CheckPassword(password) {
+		svc.reply(s, u, "Invalid account or password")
+		return
+	}
+
+	if !a.IsVerified() {
+		svc.reply(s, u, "Account "+a.Name+" must be verified first")
+		return
+	}
+
+	logIn(s, u, a.Name)
+}
+
+func nickServSendpass(s *server.Server, u *user.User, svc *service, args []string) {
+	if len(args) == 0 {
+		svc.replySyntax(s, u, "SENDPASS")
+		return
+	}
+
+	err := requestPasswordReset(s, args[0])
+	if err != nil {
+		svc.reply(s, u, accountErrorText(err))
+		return
+	}
+
+	svc.reply(s, u, "If the account "+args[0]+" has an email address, a code was sent to it")
+}
+
+func nickServResetpass(s *server.Server, u *user.User, svc *service, args []string) {
+	if len(args) < 3 {
+		svc.replySyntax(s, u, "RESETPASS")
+		return
+	}
+
+	err := resetPassword(s, args[0], args[1], args[2])
+	if err != nil {
+		svc.reply(s, u, accountErrorText(err))
+		return
+	}
+
+	svc.reply(s, u, "The password of the account "+args[0]+" was changed")
+}
+
+func nickServLogout(s *server.Server, u *user.User, svc *service, args []string) {
+	if !u.IsLoggedIn() {
+		svc.reply(s, u, "You're not logged in")
+		return
+	}
+
+	logOut(s, u)
+}
+
+func nickServGroup(s *server.Server, u *user.User, svc *service, args []string) {
+	if !u.IsLoggedIn() {
+		svc.reply(s, u, "You must be logged in to group nicks")
+		return
+	}
+
+	if owner := nickOwner(s, u.NickName); owner != nil {
+		svc.reply(s, u, "The nick "+u.NickName+" is already owned by an account")
+		return
+	}
+
+	if isServiceName(u.NickName) {
+		svc.reply(s, u, "The nick "+u.NickName+" can't be grouped")
+		return
+	}
+
+	a, err := s.Accounts.Update(u.Account(), func(a *account.Account) error {
+		if len(a.Nicks) >= s.Config.Nick.MaxGrouped {
+			return errTooManyNicks
+		}
+
+		a.GroupNick(u.NickName)
+
+		return nil
+	})
+
+	switch {
+	case err == errTooManyNicks:
+		svc.reply(s, u, fmt.Sprintf("Accounts may only group %d nicks", s.Config.Nick.MaxGrouped))
+		return
+
+	case err != nil:
+		log.Printf("[NICKSERV] Failed to save the account %s :%s", u.Account(), err)
+		svc.reply(s, u, errAccountUnavailable.Description)
+		return
+	}
+
+	svc.reply(s, u, "The nick "+u.NickName+" is now grouped with the account "+a.Name)
+}
+
+func nickServUngroup(s *server.Server, u *user.User, svc *service, args []string) {
+	if len(args) == 0 {
+		svc.replySyntax(s, u, "UNGROUP")
+		return
+	}
+
+	if !u.IsLoggedIn() {
+		svc.reply(s, u, "You must be logged in to ungroup nicks")
+		return
+	}
+
+	_, err := s.Accounts.Update(u.Account(), func(a *account.Account) error {
+		if !a.UngroupNick(args[0]) {
+			return errNotGrouped
+		}
+
+		return nil
+	})
+
+	switch {
+	case err == errNotGrouped:
+		svc.reply(s, u, "The nick "+args[0]+" is not grouped with your account")
+		return
+
+	case err != nil:
+		log.Printf("[NICKSERV] Failed to save the account %s :%s", u.Account(), err)
+		svc.reply(s, u, errAccountUnavailable.Description)
+		return
+	}
+
+	svc.reply(s, u, "The nick "+args[0]+" is no longer grouped with your account")
+}
+
+func nickServInfo(s *server.Server, u *user.User, svc *service, args []string) {
+	name := u.Account()
+	if len(args) > 0 {
+		name = args[0]
+	}
+
+	if name == "" {
+		svc.replySyntax(s, u, "INFO")
+		return
+	}
+
+	a, err := s.Accounts.Get(name)
+	if err != nil {
+		svc.reply(s, u, "Account "+name+" is not registered")
+		return
+	}
+
+	svc.reply(s, u, "Account "+a.Name+" registered on "+time.Unix(a.CreatedAt, 0).UTC().Format(time.RFC1123))
+
+	if len(a.Nicks) > 0 {
+		svc.reply(s, u, "Grouped nicks: "+strings.Join(a.Nicks, " "))
+	}
+
+	// The email address is only shown to the owner
+	if a.Email != "" && account.Key(a.Name) == account.Key(u.Account()) {
+		svc.reply(s, u, "Email address: "+a.Email)
+	}
+
+	if !a.IsVerified() {
+		svc.reply(s, u, "This account is not verified yet")
+	}
+}
+
+// accountErrorText describes a failure to register or verify an account
+func accountErrorText(err error) string {
+	if e, ok := err.(*accountError); ok {
+		return e.Description
+	}
+
+	return errAccountUnavailable.Description
+}
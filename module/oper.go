@@ -0,0 +1,132 @@
+/*
+ * Copyright 2014 The starfruit Authors. All rights reserved.
+ * Use of this source code is governed by a BSD-style
+ * license that can be found in the LICENSE file.
+ */
+
+package module
+
+import (
+	"github.com/flatpeach/starfruit/mask"
+	"github.com/flatpeach/starfruit/message"
+	"github.com/flatpeach/starfruit/server"
+	"github.com/flatpeach/starfruit/user"
+	"golang.org/x/crypto/bcrypt"
+	"log"
+)
+
+type Oper struct{}
+
+func (module *Oper) Handle(s *server.Server, u *user.User, m *message.Message) error {
+	// OPER <name> <password>
+
+	if len(m.Params) < 2 {
+		u.SendErrorNeedMoreParams("OPER")
+		return nil
+	}
+
+	name, password := m.Params[0], m.Params[1]
+
+	oper, exists := s.Config.Opers[name]
+	if !exists || !operHostAllowed(u, oper.Hosts) {
+		u.SendMessage(message.New(
+			s.Config.Server.Name,
+			message.ERR_NOOPERHOST,
+			[]string{u.NickName},
+			"No O-lines for your host",
+		))
+
+		return nil
+	}
+
+	err := bcrypt.CompareHashAndPassword([]byte(oper.Password), []byte(password))
+	if err != nil {
+		log.Printf("[OPER] %s failed to oper up as %s", u.Full(), name)
+
+		u.SendMessage(message.New(
+			s.Config.Server.Name,
+			message.ERR_PASSWDMISMATCH,
+			[]string{u.NickName},
+			"Password incorrect",
+		))
+
+		return nil
+	}
+
+	if _, exists := s.Config.Classes[oper.Class]; !exists {
+		log.Printf("[OPER] Operator %s has an unknown class %s", name, oper.Class)
+
+		u.SendMessage(message.New(
+			s.Config.Server.Name,
+			message.ERR_NOOPERHOST,
+			[]string{u.NickName},
+			"No O-lines for your host",
+		))
+
+		return nil
+	}
+
+	u.Oper(oper.Class)
+
+	log.Printf("[OPER] %s opered up as %s of class %s", u.Full(), name, oper.Class)
+
+	u.SendMessage(message.New(
+		s.Config.Server.Name,
+		message.RPL_YOUREOPER,
+		[]string{u.NickName},
+		"You are now an IRC operator",
+	))
+
+	u.SendMessage(message.New(
+		u.Full(),
+		"MODE",
+		[]string{u.NickName},
+		"+o",
+	))
+
+	return nil
+}
+
+// operHostAllowed tells whether a user connects from one of the hosts of an
+// operator block, any host is if it lists none.
+func operHostAllowed(u *user.User, hosts []string) bool {
+	if len(hosts) == 0 {
+		return true
+	}
+
+	for _, host := range hosts {
+		if mask.Match(mask.Normalize(host), u.Full()) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// requireCapability tells whether a user may use a privileged command, an
+// error is sent back otherwise.
+func requireCapability(s *server.Server, u *user.User, capability string) bool {
+	if u.Can(capability) {
+		return true
+	}
+
+	if !u.HasMode(user.ModeOperator) {
+		u.SendMessage(message.New(
+			s.Config.Server.Name,
+			message.ERR_NOPRIVILEGES,
+			[]string{u.NickName},
+			"Permission Denied- You're not an IRC operator",
+		))
+
+		return false
+	}
+
+	u.SendMessage(message.New(
+		s.Config.Server.Name,
+		message.ERR_NOPRIVS,
+		[]string{u.NickName, capability},
+		"Insufficient oper privileges.",
+	))
+
+	return false
+}
@@ -67,14 +67,19 @@ func (module *Part) Handle(s *server.Server, u *user.User, m *message.Message) e
 			continue
 		}
 
-		cnl.Broadcast(message.New(
+		partMsg := message.New(
 			u.Full(),
 			"PART",
 			[]string{channelName},
 			partMessage,
-		), nil)
+		)
+		partMsg.Stamp()
 
-		cnl.Quit(u.Id)
+		addHistory(s, u, cnl.String(), partMsg)
+
+		s.BroadcastReply(u, cnl.Id, partMsg)
+
+		s.QuitFromChannel(u.Id, cnl.Id)
 
 	}
 
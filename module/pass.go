@@ -7,10 +7,12 @@
 package module
 
 import (
+	"github.com/flatpeach/starfruit/auth"
 	"github.com/flatpeach/starfruit/message"
 	"github.com/flatpeach/starfruit/server"
 	"github.com/flatpeach/starfruit/user"
 	"log"
+	"strings"
 )
 
 type Pass struct{}
@@ -23,12 +25,21 @@ func (module *Pass) Handle(s *server.Server, u *user.User, m *message.Message) e
 		pwd = m.Params[0]
 	}
 
-	if s.Config.Server.Password == "" {
+	if s.PassAuth == nil {
 		log.Printf("[COMMAND] PASS :no need to verify your password, server disabled that")
 		return nil
 	}
 
-	if pwd != s.Config.Server.Password {
+	// Backends telling users apart are given "<user>:<password>"
+	var name string
+	if s.Config.Auth.Pass != auth.BackendStatic {
+		if idx := strings.Index(pwd, ":"); idx >= 0 {
+			name, pwd = pwd[:idx], pwd[idx+1:]
+		}
+	}
+
+	account, err := s.PassAuth.Authenticate(name, pwd)
+	if err != nil {
 		u.SendMessage(message.New(
 			s.Config.Server.Name,
 			message.ERR_PASSWDMISMATCH,
@@ -41,5 +52,9 @@ func (module *Pass) Handle(s *server.Server, u *user.User, m *message.Message) e
 
 	u.EnterStatus(user.StatusPasswordVerified)
 
+	if account != "" && !u.IsLoggedIn() {
+		logIn(s, u, accountName(s.Config.Auth.Pass, account))
+	}
+
 	return nil
 }
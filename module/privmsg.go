@@ -7,6 +7,8 @@
 package module
 
 import (
+	"github.com/flatpeach/starfruit/capability"
+	"github.com/flatpeach/starfruit/channel"
 	"github.com/flatpeach/starfruit/message"
 	"github.com/flatpeach/starfruit/server"
 	"github.com/flatpeach/starfruit/user"
@@ -17,71 +19,205 @@ type Privmsg struct{}
 func (module *Privmsg) Handle(s *server.Server, u *user.User, m *message.Message) error {
 	// PRIVMSG <target> <text>
 
+	return relayMessage(s, u, m)
+}
+
+// relayMessage delivers a PRIVMSG, NOTICE or TAGMSG to a user or a channel,
+// errors are never replied to a NOTICE.
+func relayMessage(s *server.Server, u *user.User, m *message.Message) error {
+	notice := m.Command == "NOTICE"
+	tagmsg := m.Command == "TAGMSG"
+
 	if len(m.Params) == 0 {
-		u.SendMessage(message.New(
-			s.Config.Server.Name,
-			message.ERR_NORECIPIENT,
-			[]string{
-				u.NickName,
-			},
-			"No recipient given",
-		))
+		if !notice {
+			u.SendMessage(message.New(
+				s.Config.Server.Name,
+				message.ERR_NORECIPIENT,
+				[]string{
+					u.NickName,
+				},
+				"No recipient given",
+			))
+		}
 
 		return nil
 	}
 
-	if len(m.Params) == 1 && m.Trailing == "" {
-		u.SendMessage(message.New(
-			s.Config.Server.Name,
-			message.ERR_NOTEXTTOSEND,
-			[]string{u.NickName},
-			"No text to send",
-		))
+	// TAGMSG carries nothing but tags
+	var msgText interface{}
+
+	if !tagmsg {
+		if len(m.Params) == 1 {
+			if !notice {
+				u.SendMessage(message.New(
+					s.Config.Server.Name,
+					message.ERR_NOTEXTTOSEND,
+					[]string{u.NickName},
+					"No text to send",
+				))
+			}
+
+			return nil
+		}
+
+		msgText = m.Params[1]
 	}
 
-	var (
-		msgText string
-	)
+	svc, targetUser, cnl := findMessageTarget(s, u, m.Command, m.Params[0])
 
-	msgText = m.Params[1]
+	switch {
+	case svc != nil:
+		// Services only take commands, and never reply to a notice
+		if m.Command == "PRIVMSG" {
+			svc.handle(s, u, m.Params[1])
+		}
 
-	targetUser := s.GetUserByNickName(m.Params[0])
-	if targetUser != nil {
+	case targetUser != nil:
 		// Send msg to specific user
-		targetUser.SendMessage(message.New(
+		msg := message.New(
 			u.Full(),
-			"PRIVMSG",
+			m.Command,
 			[]string{targetUser.NickName},
 			msgText,
-		))
+		)
+		relayClientTags(s, m, msg)
+		msg.Stamp()
 
-		return nil
-	}
+		if !tagmsg {
+			addDirectHistory(s, u, targetUser, msg)
+		}
 
-	cnl := s.FindChannelByName(m.Params[0])
-	if cnl != nil {
+		if !tagmsg || targetUser.HasCap(capability.MessageTags) {
+			targetUser.Deliver(msg)
+		}
+
+		if u.HasCap(capability.EchoMessage) && targetUser.Id != u.Id &&
+			(!tagmsg || u.HasCap(capability.MessageTags)) {
+			u.SendMessage(msg)
+		}
+
+	case cnl != nil:
 		// Send msg to specific channel
 		msg := message.New(
 			u.Full(),
-			"PRIVMSG",
+			m.Command,
 			[]string{
 				cnl.String(),
 			},
 			msgText,
 		)
-		s.BroadcastMessage(cnl.Id, msg, []int{u.Id}) // @Todo: implement the exclude ids logic
-		return nil
-	}
+		relayClientTags(s, m, msg)
+		msg.Stamp()
+
+		if !tagmsg {
+			addHistory(s, u, cnl.String(), msg)
+		}
+
+		s.BroadcastReplyFunc(u, cnl.Id, func(member *user.User) *message.Message {
+			if member.Id == u.Id && !u.HasCap(capability.EchoMessage) {
+				return nil
+			}
+
+			if tagmsg && !member.HasCap(capability.MessageTags) {
+				return nil
+			}
 
-	u.SendMessage(message.New(
-		s.Config.Server.Name,
-		message.ERR_NOSUCHNICK,
-		[]string{
-			u.NickName,
-			m.Params[0],
-		},
-		nil,
-	))
+			return msg
+		})
+
+		// Members got the echo along with the others
+		if u.HasCap(capability.EchoMessage) && !s.IsUserJoinedChannel(u.Id, cnl.Id) {
+			if !tagmsg || u.HasCap(capability.MessageTags) {
+				u.SendMessage(msg)
+			}
+		}
+	}
 
 	return nil
 }
+
+// findMessageTarget resolves the target of a message to a service, a user or
+// a channel the user may send to. When there is none, the error is replied
+// unless the message is a notice.
+func findMessageTarget(s *server.Server, u *user.User, command string, target string) (*service, *user.User, *channel.Channel) {
+	if svc := findService(target); svc != nil {
+		return svc, nil, nil
+	}
+
+	if targetUser := s.GetUserByNickName(target); targetUser != nil {
+		return nil, targetUser, nil
+	}
+
+	notice := command == "NOTICE"
+
+	cnl := s.FindChannelByName(target)
+	if cnl != nil {
+		if canSendToChannel(s, u, cnl) {
+			return nil, nil, cnl
+		}
+
+		if !notice {
+			u.SendMessage(message.New(
+				s.Config.Server.Name,
+				message.ERR_CANNOTSENDTOCHAN,
+				[]string{
+					u.NickName,
+					cnl.String(),
+				},
+				"Cannot send to channel",
+			))
+		}
+
+		return nil, nil, nil
+	}
+
+	if !notice {
+		u.SendMessage(message.New(
+			s.Config.Server.Name,
+			message.ERR_NOSUCHNICK,
+			[]string{
+				u.NickName,
+				target,
+			},
+			nil,
+		))
+	}
+
+	return nil, nil, nil
+}
+
+// canSendToChannel applies the no external messages and moderated modes
+// and the bans, voiced users and operators being allowed to talk anyway.
+func canSendToChannel(s *server.Server, u *user.User, cnl *channel.Channel) bool {
+	joined := s.IsUserJoinedChannel(u.Id, cnl.Id)
+
+	if !joined && cnl.HasMode(channel.MODE_NO_MESSAGE) {
+		return false
+	}
+
+	if cnl.IsOperator(u.Id) || cnl.HasPrivilege(u.Id, channel.MODE_VOICE) {
+		return true
+	}
+
+	return !cnl.HasMode(channel.MODE_MODERATED) && !cnl.IsBanned(u.Full())
+}
+
+// relayClientTags copies the client-only tags the server is configured to
+// relay from a message received to the message relayed.
+func relayClientTags(s *server.Server, from *message.Message, to *message.Message) {
+	for k, v := range from.ClientTags() {
+		if clientTagAllowed(s, k) {
+			to.SetTag(k, v)
+		}
+	}
+}
+
+func clientTagAllowed(s *server.Server, tag string) bool {
+	for _, allowed := range s.Config.Tags.Allowed {
+		if allowed == "*" || allowed == tag {
+			return true
+		}
+	}
+
+	return false
+}
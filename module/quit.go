@@ -36,6 +36,7 @@ func (module *Quit) Handle(s *server.Server, u *user.User, m *message.Message) e
 		nil,
 		quitMessage,
 	)
+	quitMsg.Stamp()
 
 	channels := s.GetJoinedChannels(u.Id)
 	for _, cnl := range channels {
@@ -0,0 +1,148 @@
+/*
+ * Copyright 2014 The starfruit Authors. All rights reserved.
+ * Use of this source code is governed by a BSD-style
+ * license that can be found in the LICENSE file.
+ */
+
+package module
+
+import (
+	"github.com/flatpeach/starfruit/account"
+	"github.com/flatpeach/starfruit/capability"
+	"github.com/flatpeach/starfruit/channel"
+	"github.com/flatpeach/starfruit/history"
+	"github.com/flatpeach/starfruit/message"
+	"github.com/flatpeach/starfruit/server"
+	"github.com/flatpeach/starfruit/user"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type Redact struct{}
+
+func (module *Redact) Handle(s *server.Server, u *user.User, m *message.Message) error {
+	// REDACT <target> <msgid> [ <reason> ]
+
+	if len(m.Params) < 2 || m.Params[0] == "" {
+		u.SendFail("REDACT", "NEED_MORE_PARAMS", nil, "Not enough parameters")
+		return nil
+	}
+
+	target, msgid := m.Params[0], m.Params[1]
+
+	var reason interface{}
+	if len(m.Params) > 2 {
+		reason = m.Params[2]
+	}
+
+	var (
+		cnl        *channel.Channel
+		targetUser *user.User
+	)
+
+	if strings.ContainsAny(target[0:1], channel.NS_ALL_RAW) {
+		cnl = s.FindChannelByName(target)
+		if cnl == nil || !s.IsUserJoinedChannel(u.Id, cnl.Id) {
+			u.SendFail("REDACT", "INVALID_TARGET", []string{target}, "You're not on that channel")
+			return nil
+		}
+
+		target = cnl.String()
+	} else {
+		targetUser = s.GetUserByNickName(target)
+		if targetUser != nil {
+			target = targetUser.NickName
+		}
+	}
+
+	store, name, ok := historyOf(s, u, target)
+	if !ok {
+		u.SendFail("REDACT", "INVALID_TARGET", []string{target}, "Messages of this target can't be redacted")
+		return nil
+	}
+
+	item := store.Find(name, msgid)
+	if item == nil || item.Redacted || !redactable(item) {
+		u.SendFail("REDACT", "UNKNOWN_MSGID", []string{target, msgid}, "This message does not exist or can't be redacted")
+		return nil
+	}
+
+	operator := cnl != nil && cnl.IsOperator(u.Id)
+
+	if !operator && !isSender(u, item) {
+		u.SendFail("REDACT", "REDACT_FORBIDDEN", []string{target, msgid}, "You're not allowed to redact this message")
+		return nil
+	}
+
+	window := time.Duration(s.Config.Redact.Window) * time.Second
+	if !operator && window > 0 && time.Since(item.Time) > window {
+		u.SendFail(
+			"REDACT",
+			"REDACT_WINDOW_EXPIRED",
+			[]string{target, msgid, strconv.Itoa(s.Config.Redact.Window)},
+			"This message is too old to be redacted",
+		)
+		return nil
+	}
+
+	var replacement *history.Item
+	if s.Config.Redact.Tombstone {
+		replacement = item.Tombstone()
+	}
+
+	_, err := store.Replace(name, msgid, replacement)
+	if err != nil {
+		log.Printf("[REDACT] Failed to redact %s from %s :%s", msgid, target, err)
+	}
+
+	redactMsg := message.New(
+		u.Full(),
+		"REDACT",
+		[]string{target, msgid},
+		reason,
+	)
+	redactMsg.Stamp()
+
+	// Only clients which negotiated message-redaction know what to do
+	redactMsgFor := func(member *user.User) *message.Message {
+		if member.HasCap(capability.Redaction) {
+			return redactMsg
+		}
+
+		return nil
+	}
+
+	if cnl != nil {
+		s.BroadcastReplyFunc(u, cnl.Id, redactMsgFor)
+		return nil
+	}
+
+	if msg := redactMsgFor(u); msg != nil {
+		u.SendMessage(msg)
+	}
+
+	if targetUser != nil && targetUser.Id != u.Id {
+		if msg := redactMsgFor(targetUser); msg != nil {
+			targetUser.Deliver(msg)
+		}
+	}
+
+	return nil
+}
+
+// redactable tells whether an item is a message, events can't be redacted
+func redactable(item *history.Item) bool {
+	return item.Command == "PRIVMSG" || item.Command == "NOTICE"
+}
+
+// isSender tells whether a user sent an item, by account if it was sent by a
+// logged in user, by connection otherwise as nicks are taken over.
+func isSender(u *user.User, item *history.Item) bool {
+	if item.Account != "" {
+		return account.Key(item.Account) == account.Key(u.Account())
+	}
+
+	return item.Conn != "" && item.Conn == u.ConnId
+}
@@ -0,0 +1,370 @@
+/*
+ * Copyright 2014 The starfruit Authors. All rights reserved.
+ * Use of this source code is governed by a BSD-style
+ * license that can be found in the LICENSE file.
+ */
+
+package module
+
+import (
+	"crypto/rand"
+	"encoding/base32"
+	"github.com/flatpeach/starfruit/account"
+	"github.com/flatpeach/starfruit/casemapping"
+	"github.com/flatpeach/starfruit/mailer"
+	"github.com/flatpeach/starfruit/message"
+	"github.com/flatpeach/starfruit/server"
+	"github.com/flatpeach/starfruit/user"
+	"log"
+	"net/mail"
+	"time"
+)
+
+// accountError is a failure to register or verify an account, its code is
+// the one of the FAIL reply.
+type accountError struct {
+	Code        string
+	Description string
+}
+
+func (e *accountError) Error() string {
+	return e.Description
+}
+
+var (
+	errAccountUnavailable = &accountError{"TEMPORARILY_UNAVAILABLE", "Accounts can't be registered right now"}
+	errAlreadyLoggedIn    = &accountError{"ALREADY_AUTHENTICATED", "You're already logged in"}
+	errNeedNick           = &accountError{"NEED_NICK", "You must choose a nick first"}
+	errAccountNotNick     = &accountError{"ACCOUNT_NAME_MUST_BE_NICK", "The account name must be your nick"}
+	errBadAccountName     = &accountError{"BAD_ACCOUNT_NAME", "This account name can't be registered"}
+	errAccountExists      = &accountError{"ACCOUNT_EXISTS", "This account is already registered"}
+	errInvalidEmail       = &accountError{"INVALID_EMAIL", "A valid email address is required"}
+	errWeakPassword       = &accountError{"WEAK_PASSWORD", "This password is too short"}
+	errInvalidCode        = &accountError{"INVALID_CODE", "This code is invalid or expired"}
+	errMailLimited        = &accountError{"TEMPORARILY_UNAVAILABLE", "Too many emails were sent to this address, try again later"}
+)
+
+type Register struct{}
+
+func (module *Register) Handle(s *server.Server, u *user.User, m *message.Message) error {
+	// REGISTER <account> { <email> | "*" } <password>
+
+	if len(m.Params) < 3 {
+		u.SendFail("REGISTER", "NEED_MORE_PARAMS", nil, "Not enough parameters")
+		return nil
+	}
+
+	if !u.IsRegistered() && !s.Config.Register.BeforeConnect {
+		u.SendFail("REGISTER", "COMPLETE_CONNECTION_REQUIRED", nil, "Complete the connection first")
+		return nil
+	}
+
+	a, err := registerAccount(s, u, m.Params[0], m.Params[1], m.Params[2])
+	if err != nil {
+		sendAccountFail(u, "REGISTER", m.Params[0], err)
+		return nil
+	}
+
+	if !a.IsVerified() {
+		u.SendMessage(message.New(
+			s.Config.Server.Name,
+			"REGISTER",
+			[]string{"VERIFICATION_REQUIRED", a.Name},
+			"Account created, it must be verified with the code sent to you",
+		))
+
+		return nil
+	}
+
+	logIn(s, u, a.Name)
+
+	u.SendMessage(message.New(
+		s.Config.Server.Name,
+		"REGISTER",
+		[]string{"SUCCESS", a.Name},
+		"Account successfully registered",
+	))
+
+	return nil
+}
+
+type Verify struct{}
+
+func (module *Verify) Handle(s *server.Server, u *user.User, m *message.Message) error {
+	// VERIFY <account> <code>
+
+	if len(m.Params) < 2 {
+		u.SendFail("VERIFY", "NEED_MORE_PARAMS", nil, "Not enough parameters")
+		return nil
+	}
+
+	if !u.IsRegistered() && !s.Config.Register.BeforeConnect {
+		u.SendFail("VERIFY", "COMPLETE_CONNECTION_REQUIRED", nil, "Complete the connection first")
+		return nil
+	}
+
+	a, err := verifyAccount(s, u, m.Params[0], m.Params[1])
+	if err != nil {
+		sendAccountFail(u, "VERIFY", m.Params[0], err)
+		return nil
+	}
+
+	logIn(s, u, a.Name)
+
+	u.SendMessage(message.New(
+		s.Config.Server.Name,
+		"VERIFY",
+		[]string{"SUCCESS", a.Name},
+		"Account successfully verified",
+	))
+
+	return nil
+}
+
+func sendAccountFail(u *user.User, command string, name string, err error) {
+	e, ok := err.(*accountError)
+	if !ok {
+		e = errAccountUnavailable
+	}
+
+	u.SendFail(command, e.Code, []string{name}, e.Description)
+}
+
+// registerAccount creates an account named after the nick of the user, "*"
+// standing for the current nick. The account is left unverified if codes
+// are required, otherwise the user may log in right away.
+func registerAccount(s *server.Server, u *user.User, name string, email string, password string) (*account.Account, error) {
+	cf := s.Config.Register
+
+	if !cf.Enabled || s.Accounts == nil {
+		return nil, errAccountUnavailable
+	}
+
+	if u.IsLoggedIn() {
+		return nil, errAlreadyLoggedIn
+	}
+
+	if u.NickName == "" {
+		return nil, errNeedNick
+	}
+
+	if name == "*" {
+		name = u.NickName
+	}
+
+	if !casemapping.Equal(name, u.NickName) {
+		return nil, errAccountNotNick
+	}
+
+	if isServiceName(name) || isExternal(name) {
+		return nil, errBadAccountName
+	}
+
+	// Names of accounts which weren't verified in time are free again
+	if existing, err := s.Accounts.GetByNick(name); err == nil && !existing.VerificationExpired() {
+		return nil, errAccountExists
+	}
+
+	if email == "*" {
+		email = ""
+	}
+
+	if email == "" && s.Config.EmailRequired() {
+		return nil, errInvalidEmail
+	}
+
+	if email != "" {
+		addr, err := mail.ParseAddress(email)
+		if err != nil || addr.Address != email {
+			return nil, errInvalidEmail
+		}
+	}
+
+	if len(password) < cf.MinPasswordLength {
+		return nil, errWeakPassword
+	}
+
+	a := &account.Account{
+		Name:      u.NickName,
+		Email:     email,
+		CreatedAt: time.Now().Unix(),
+	}
+
+	err := a.SetPassword(password)
+	if err != nil {
+		return nil, err
+	}
+
+	var code string
+
+	if cf.Verify {
+		code = newVerificationCode()
+
+		err = a.SetVerificationCode(code)
+		if err != nil {
+			return nil, err
+		}
+
+		a.PendingExpiry = codeExpiry(s)
+
+		if s.Mailer != nil && !s.Mailer.Allow(email) {
+			return nil, errMailLimited
+		}
+	}
+
+	// Another connection may register the name meanwhile, it's only free
+	// once the store says so.
+	err = s.Accounts.Create(a)
+	if err == account.ErrExists {
+		return nil, errAccountExists
+	}
+
+	if err != nil {
+		log.Printf("[REGISTER] Failed to save the account %s :%s", a.Name, err)
+		return nil, err
+	}
+
+	if code != "" {
+		sendCode(s, a, mailer.TemplateVerify, code)
+	}
+
+	return a, nil
+}
+
+// verifyAccount checks the code of an account which is not verified yet
+func verifyAccount(s *server.Server, u *user.User, name string, code string) (*account.Account, error) {
+	if s.Accounts == nil {
+		return nil, errAccountUnavailable
+	}
+
+	if u.IsLoggedIn() {
+		return nil, errAlreadyLoggedIn
+	}
+
+	a, err := s.Accounts.Update(name, func(a *account.Account) error {
+		if !a.Verify(code) {
+			return errInvalidCode
+		}
+
+		return nil
+	})
+	if err == account.ErrNotFound || err == errInvalidCode {
+		return nil, errInvalidCode
+	}
+
+	if err != nil {
+		log.Printf("[REGISTER] Failed to save the account %s :%s", name, err)
+		return nil, err
+	}
+
+	return a, nil
+}
+
+// newVerificationCode returns a random code, easy enough to type
+func newVerificationCode() string {
+	buf := make([]byte, 5)
+	rand.Read(buf)
+
+	return base32.StdEncoding.EncodeToString(buf)
+}
+
+// requestPasswordReset sends a code resetting the password of an account to
+// its email address.
+func requestPasswordReset(s *server.Server, name string) error {
+	if s.Accounts == nil || s.Mailer == nil {
+		return errAccountUnavailable
+	}
+
+	code := newVerificationCode()
+
+	a, err := s.Accounts.Update(name, func(a *account.Account) error {
+		if a.Email == "" {
+			return account.ErrNotFound
+		}
+
+		if !s.Mailer.Allow(a.Email) {
+			return errMailLimited
+		}
+
+		return a.SetResetCode(code, codeExpiry(s))
+	})
+	if err == account.ErrNotFound {
+		// Whether the account exists is not told
+		return nil
+	}
+
+	if err == errMailLimited {
+		return err
+	}
+
+	if err != nil {
+		log.Printf("[REGISTER] Failed to save the account %s :%s", name, err)
+		return err
+	}
+
+	sendCode(s, a, mailer.TemplateReset, code)
+
+	return nil
+}
+
+// resetPassword sets a new password with the code sent to the account
+func resetPassword(s *server.Server, name string, code string, password string) error {
+	if s.Accounts == nil {
+		return errAccountUnavailable
+	}
+
+	// A weak password must not use up the code
+	if len(password) < s.Config.Register.MinPasswordLength {
+		return errWeakPassword
+	}
+
+	// The code is used up and the password set at once, so the code can't
+	// be used twice meanwhile.
+	_, err := s.Accounts.Update(name, func(a *account.Account) error {
+		if !a.UseResetCode(code) {
+			return errInvalidCode
+		}
+
+		return a.SetPassword(password)
+	})
+	if err == account.ErrNotFound || err == errInvalidCode {
+		return errInvalidCode
+	}
+
+	if err != nil {
+		log.Printf("[REGISTER] Failed to save the account %s :%s", name, err)
+		return err
+	}
+
+	return nil
+}
+
+// codeExpiry returns when a code sent now expires
+func codeExpiry(s *server.Server) int64 {
+	return time.Now().Add(time.Duration(s.Config.Register.CodeExpiry) * time.Second).Unix()
+}
+
+// sendCode emails a code to the address of an account in the background,
+// codes are only written to the log when no SMTP relay is configured.
+func sendCode(s *server.Server, a *account.Account, template string, code string) {
+	// A rehash may replace the mailer while the code is being sent
+	m := s.Mailer
+	if m == nil || a.Email == "" {
+		log.Printf("[REGISTER] Code of the account %s :%s", a.Name, code)
+		return
+	}
+
+	data := &mailer.Data{
+		Network: s.Config.Server.Network,
+		Account: a.Name,
+		Code:    code,
+		Minutes: s.Config.Register.CodeExpiry / 60,
+	}
+
+	go func(to string) {
+		err := m.Send(to, template, data)
+		if err != nil {
+			log.Printf("[REGISTER] Failed to email the code of the account %s :%s", data.Account, err)
+		}
+	}(a.Email)
+}
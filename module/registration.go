@@ -0,0 +1,79 @@
+/*
+ * Copyright 2014 The starfruit Authors. All rights reserved.
+ * Use of this source code is governed by a BSD-style
+ * license that can be found in the LICENSE file.
+ */
+
+package module
+
+import (
+	"github.com/flatpeach/starfruit/message"
+	"github.com/flatpeach/starfruit/server"
+	"github.com/flatpeach/starfruit/user"
+	"log"
+)
+
+// completeRegistration registers the user to the server once both NICK and
+// USER were received and no capability negotiation is in progress.
+func completeRegistration(s *server.Server, u *user.User) {
+	if u.IsRegistered() || u.NickName == "" || u.UserName == "" {
+		return
+	}
+
+	if u.IsNegotiatingCaps() {
+		return
+	}
+
+	if s.IsNickNameRegistered(u.NickName) {
+		u.SendMessage(message.New(
+			s.Config.Server.Name,
+			message.ERR_NICKNAMEINUSE,
+			[]string{
+				"*",
+				u.NickName,
+			},
+			"Nickname is already in use",
+		))
+
+		return
+	}
+
+	if s.Config.Sasl.Required && !u.IsLoggedIn() {
+		u.SendMessage(message.New(
+			nil,
+			"ERROR",
+			nil,
+			"Closing Link: SASL authentication is required to connect",
+		))
+
+		u.SendMessage(nil)
+		u.EnterStatus(user.StatusDisconnecting)
+
+		return
+	}
+
+	if b := findUserBan(s, u); b != nil {
+		log.Printf("[BAN] Refused %s!%s@%s, %s", u.NickName, u.UserName, u.HostName, banReason(b))
+
+		sendBanned(s, u, b)
+
+		u.SendMessage(message.New(
+			nil,
+			"ERROR",
+			nil,
+			"Closing Link: "+u.HostName+" ("+banReason(b)+")",
+		))
+
+		u.SendMessage(nil)
+		u.EnterStatus(user.StatusDisconnecting)
+
+		return
+	}
+
+	u.Id = s.NewUserId()
+	s.RegisterUser(u)
+	u.EnterStatus(user.StatusRegistered)
+	u.SendWelcomeMessage(s.ISupport)
+
+	enforceNickName(s, u)
+}
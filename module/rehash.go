@@ -0,0 +1,42 @@
+/*
+ * Copyright 2014 The starfruit Authors. All rights reserved.
+ * Use of this source code is governed by a BSD-style
+ * license that can be found in the LICENSE file.
+ */
+
+package module
+
+import (
+	"github.com/flatpeach/starfruit/message"
+	"github.com/flatpeach/starfruit/server"
+	"github.com/flatpeach/starfruit/user"
+	"log"
+)
+
+type Rehash struct{}
+
+func (module *Rehash) Handle(s *server.Server, u *user.User, m *message.Message) error {
+	// REHASH
+
+	if !requireCapability(s, u, user.OperRehash) {
+		return nil
+	}
+
+	u.SendMessage(message.New(
+		s.Config.Server.Name,
+		message.RPL_REHASHING,
+		[]string{u.NickName, "configuration"},
+		"Rehashing",
+	))
+
+	log.Printf("[REHASH] %s is reloading the configuration", u.Full())
+
+	err := s.Rehash()
+	if err != nil {
+		log.Printf("[REHASH] Failed to reload the configuration :%s", err)
+
+		u.SendFail("REHASH", "FAILED", nil, "Failed to reload the configuration: "+err.Error())
+	}
+
+	return nil
+}
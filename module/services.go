@@ -0,0 +1,111 @@
+/*
+ * Copyright 2014 The starfruit Authors. All rights reserved.
+ * Use of this source code is governed by a BSD-style
+ * license that can be found in the LICENSE file.
+ */
+
+package module
+
+import (
+	"github.com/flatpeach/starfruit/casemapping"
+	"github.com/flatpeach/starfruit/message"
+	"github.com/flatpeach/starfruit/server"
+	"github.com/flatpeach/starfruit/user"
+	"sort"
+	"strings"
+)
+
+// service is a pseudo-user taking commands from the messages users send it,
+// it always replies with notices.
+type service struct {
+	Name     string
+	Commands map[string]*serviceCommand
+}
+
+type serviceCommand struct {
+	Syntax string // Parameters of the command, shown by HELP
+	Help   string
+	Handle func(s *server.Server, u *user.User, svc *service, args []string)
+}
+
+// All services, by folded name
+var services = make(map[string]*service)
+
+func registerService(svc *service) {
+	services[casemapping.Fold(svc.Name)] = svc
+}
+
+func findService(name string) *service {
+	return services[casemapping.Fold(name)]
+}
+
+// isServiceName tells whether a nick belongs to a service, nobody may use it
+func isServiceName(nick string) bool {
+	return findService(nick) != nil
+}
+
+// handle runs the command found in the text a user sent to the service
+func (svc *service) handle(s *server.Server, u *user.User, text string) {
+	args := strings.Fields(text)
+	if len(args) == 0 {
+		return
+	}
+
+	name := strings.ToUpper(args[0])
+
+	if name == "HELP" {
+		svc.sendHelp(s, u)
+		return
+	}
+
+	cmd, exists := svc.Commands[name]
+	if !exists {
+		svc.reply(s, u, "Unknown command "+name+", try HELP")
+		return
+	}
+
+	cmd.Handle(s, u, svc, args[1:])
+}
+
+func (svc *service) sendHelp(s *server.Server, u *user.User) {
+	var names []string
+	for name := range svc.Commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	svc.reply(s, u, svc.Name+" understands the following commands:")
+
+	for _, name := range names {
+		cmd := svc.Commands[name]
+		svc.reply(s, u, strings.TrimSpace(name+" "+cmd.Syntax)+" - "+cmd.Help)
+	}
+}
+
+// source is how the service appears to users
+func (svc *service) source(s *server.Server) string {
+	return svc.Name + "!" + svc.Name + "@" + s.Config.Server.Name
+}
+
+func (svc *service) reply(s *server.Server, u *user.User, text string) {
+	u.SendMessage(svc.notice(s, u, text))
+}
+
+// notify sends a notice the user didn't ask for, see user.Deliver
+func (svc *service) notify(s *server.Server, u *user.User, text string) {
+	u.Deliver(svc.notice(s, u, text))
+}
+
+func (svc *service) notice(s *server.Server, u *user.User, text string) *message.Message {
+	return message.New(
+		svc.source(s),
+		"NOTICE",
+		[]string{u.NickName},
+		text,
+	)
+}
+
+// replySyntax tells the user how a command is used
+func (svc *service) replySyntax(s *server.Server, u *user.User, name string) {
+	svc.reply(s, u, "Syntax: "+name+" "+svc.Commands[name].Syntax)
+}
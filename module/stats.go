@@ -0,0 +1,74 @@
+/*
+ * Copyright 2014 The starfruit Authors. All rights reserved.
+ * Use of this source code is governed by a BSD-style
+ * license that can be found in the LICENSE file.
+ */
+
+package module
+
+import (
+	"github.com/flatpeach/starfruit/ban"
+	"github.com/flatpeach/starfruit/message"
+	"github.com/flatpeach/starfruit/server"
+	"github.com/flatpeach/starfruit/user"
+	"strconv"
+	"strings"
+)
+
+// Numerics the server bans are listed with, by query letter
+var statsBans = map[string]struct {
+	kind    string
+	numeric string
+}{
+	"k": {ban.KindK, message.RPL_STATSKLINE},
+	"d": {ban.KindD, message.RPL_STATSDLINE},
+	"g": {ban.KindG, message.RPL_STATSGLINE},
+}
+
+type Stats struct{}
+
+func (module *Stats) Handle(s *server.Server, u *user.User, m *message.Message) error {
+	// STATS <query>
+
+	if len(m.Params) == 0 || m.Params[0] == "" {
+		u.SendErrorNeedMoreParams("STATS")
+		return nil
+	}
+
+	query := m.Params[0][:1]
+
+	if list, exists := statsBans[strings.ToLower(query)]; exists {
+		if !requireCapability(s, u, user.OperManageBans) {
+			return nil
+		}
+
+		for _, b := range s.Bans.All() {
+			if b.Kind != list.kind {
+				continue
+			}
+
+			// <kind> <mask> <expiry> <set by> :<reason>, 0 expires never
+			u.SendMessage(message.New(
+				s.Config.Server.Name,
+				list.numeric,
+				[]string{
+					u.NickName,
+					b.Kind,
+					b.Mask,
+					strconv.FormatInt(b.Expires, 10),
+					b.SetBy,
+				},
+				b.Reason,
+			))
+		}
+	}
+
+	u.SendMessage(message.New(
+		s.Config.Server.Name,
+		message.RPL_ENDOFSTATS,
+		[]string{u.NickName, query},
+		"End of /STATS report",
+	))
+
+	return nil
+}
@@ -0,0 +1,21 @@
+/*
+ * Copyright 2014 The starfruit Authors. All rights reserved.
+ * Use of this source code is governed by a BSD-style
+ * license that can be found in the LICENSE file.
+ */
+
+package module
+
+import (
+	"github.com/flatpeach/starfruit/message"
+	"github.com/flatpeach/starfruit/server"
+	"github.com/flatpeach/starfruit/user"
+)
+
+type TagMsg struct{}
+
+func (module *TagMsg) Handle(s *server.Server, u *user.User, m *message.Message) error {
+	// TAGMSG <target>
+
+	return relayMessage(s, u, m)
+}
@@ -8,6 +8,7 @@ package module
 
 import (
 	"fmt"
+	"github.com/flatpeach/starfruit/channel"
 	"github.com/flatpeach/starfruit/message"
 	"github.com/flatpeach/starfruit/server"
 	"github.com/flatpeach/starfruit/user"
@@ -50,17 +51,34 @@ func (module *Topic) Handle(s *server.Server, u *user.User, m *message.Message)
 	}
 
 	if len(m.Params) > 1 {
+		if cnl.HasMode(channel.MODE_TOPIC) && !cnl.IsOperator(u.Id) && !u.Can(user.OperOverrideChannelModes) {
+			u.SendMessage(message.New(
+				s.Config.Server.Name,
+				message.ERR_CHANOPRIVSNEEDED,
+				[]string{u.NickName, channelName},
+				"You're not channel operator",
+			))
+
+			return nil
+		}
+
 		var newTopic = m.Params[1]
 		cnl.SetTopic(newTopic, u.Full())
+		saveChannel(s, cnl)
 
-		s.BroadcastMessage(cnl.Id, message.New(
+		topicMsg := message.New(
 			u.Full(),
 			"TOPIC",
 			[]string{
 				channelName,
 			},
 			newTopic,
-		), nil)
+		)
+		topicMsg.Stamp()
+
+		addHistory(s, u, cnl.String(), topicMsg)
+
+		s.BroadcastReply(u, cnl.Id, topicMsg)
 
 		return nil
 	}
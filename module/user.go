@@ -54,13 +54,8 @@ func (module *User) Handle(s *server.Server, u *user.User, m *message.Message) e
 		u.RealName = m.Params[3]
 	}
 
-	if u.NickName != "" {
-		// Everything is ok, register this user to the server user list
-		u.Id = s.NewUserId()
-		s.RegisterUser(u)
-		u.EnterStatus(user.StatusRegistered)
-		u.SendWelcomeMessage()
-	}
+	// Everything is ok, register this user to the server user list
+	completeRegistration(s, u)
 
 	return nil
 }
@@ -30,5 +30,7 @@ func (module *Version) Handle(s *server.Server, u *user.User, m *message.Message
 		version.MagicCode,
 	))
 
+	u.SendISupport(s.ISupport)
+
 	return nil
 }
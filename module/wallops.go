@@ -0,0 +1,51 @@
+/*
+ * Copyright 2014 The starfruit Authors. All rights reserved.
+ * Use of this source code is governed by a BSD-style
+ * license that can be found in the LICENSE file.
+ */
+
+package module
+
+import (
+	"github.com/flatpeach/starfruit/message"
+	"github.com/flatpeach/starfruit/server"
+	"github.com/flatpeach/starfruit/user"
+)
+
+type Wallops struct{}
+
+func (module *Wallops) Handle(s *server.Server, u *user.User, m *message.Message) error {
+	// WALLOPS <text>
+
+	if !requireCapability(s, u, user.OperWallops) {
+		return nil
+	}
+
+	if len(m.Params) == 0 || m.Params[0] == "" {
+		u.SendErrorNeedMoreParams("WALLOPS")
+		return nil
+	}
+
+	wallopsMsg := message.New(
+		u.Full(),
+		"WALLOPS",
+		nil,
+		m.Params[0],
+	)
+	wallopsMsg.Stamp()
+
+	// Sent to the users with +w, the sender included
+	for _, recipient := range s.GetAllUsers() {
+		if !recipient.IsRegistered() || !recipient.HasMode(user.ModeReceiveWallops) {
+			continue
+		}
+
+		if recipient.Id == u.Id {
+			u.SendMessage(wallopsMsg)
+		} else {
+			recipient.Deliver(wallopsMsg)
+		}
+	}
+
+	return nil
+}
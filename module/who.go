@@ -8,10 +8,10 @@ package module
 
 import (
 	"fmt"
+	"github.com/flatpeach/starfruit/capability"
 	"github.com/flatpeach/starfruit/message"
 	"github.com/flatpeach/starfruit/server"
 	"github.com/flatpeach/starfruit/user"
-	"log"
 )
 
 type Who struct{}
@@ -37,32 +37,41 @@ func (module *Who) Handle(s *server.Server, u *user.User, m *message.Message) er
 
 	channelName = m.Params[0]
 
+	// Unknown channels and channels the user is not on give an empty list
 	cnl := s.FindChannelByName(channelName)
-	if cnl == nil {
-		log.Printf("[COMMAND] WHO Malformed channel :%s", channelName)
-		return nil
-	}
-
-	if !s.IsUserJoinedChannel(u.Id, cnl.Id) {
-		log.Printf("User not joined!")
+	if cnl == nil || !s.IsUserJoinedChannel(u.Id, cnl.Id) {
 		goto endofwho
 	}
 
 	users = s.GetJoinedUsers(cnl.Id)
-	for _, user := range users {
+	for _, member := range users {
+		// H(ere) or G(one), then * for operators and the channel prefixes
+		flags := "H"
+		if member.IsAway() {
+			flags = "G"
+		}
+
+		if member.HasMode(user.ModeOperator) {
+			flags += "*"
+		}
+
+		flags += cnl.Prefixes(member.Id, u.HasCap(capability.MultiPrefix))
+
+		userName, hostName := member.UserHost()
+
 		u.SendMessage(message.New(
 			s.Config.Server.Name,
 			message.RPL_WHOREPLY,
 			[]string{
 				u.NickName,
 				channelName,
-				"~" + user.UserName,
-				user.HostName,
+				user.Ident(userName),
+				hostName,
 				s.Config.Server.Name,
-				user.NickName,
-				"H@",
+				member.Nick(),
+				flags,
 			},
-			fmt.Sprintf("0 %s", user.RealName),
+			fmt.Sprintf("0 %s", member.RealName),
 		))
 	}
 
@@ -7,6 +7,7 @@
 package module
 
 import (
+	"github.com/flatpeach/starfruit/channel"
 	"github.com/flatpeach/starfruit/message"
 	"github.com/flatpeach/starfruit/server"
 	"github.com/flatpeach/starfruit/user"
@@ -32,22 +33,24 @@ func (module *Whois) Handle(s *server.Server, u *user.User, m *message.Message)
 	nicks := strings.Split(m.Params[0], ",")
 	for _, nick := range nicks {
 		target := s.GetUserByNickName(nick)
-		if u == nil {
+		if target == nil {
 			// @Todo: fulfill the errors here
 			continue
 		}
 
+		userName, hostName := target.UserHost()
+
 		u.SendMessage(message.New(
 			s.Config.Server.Name,
 			message.RPL_WHOISUSER,
 			[]string{
 				u.NickName,
 				target.NickName,
-				target.UserName,
-				target.HostName,
+				userName,
+				hostName,
 				"*",
 			},
-			u.RealName,
+			target.RealName,
 		))
 
 		u.SendMessage(message.New(
@@ -61,7 +64,13 @@ func (module *Whois) Handle(s *server.Server, u *user.User, m *message.Message)
 			s.Config.Server.Name,
 		))
 
-		joinedChannels := s.GetJoinedChannels(u.Id)
+		var joinedChannels []*channel.Channel
+		for _, cnl := range s.GetJoinedChannels(target.Id) {
+			if isChannelVisible(s, u, cnl) {
+				joinedChannels = append(joinedChannels, cnl)
+			}
+		}
+
 		if len(joinedChannels) > 0 {
 			u.SendMessage(message.New(
 				s.Config.Server.Name,
@@ -80,6 +89,37 @@ func (module *Whois) Handle(s *server.Server, u *user.User, m *message.Message)
 			))
 		}
 
+		if class := target.OperClass(); class != "" {
+			// Only operators are told the class of other operators
+			text := "is an IRC operator"
+			if u.HasMode(user.ModeOperator) {
+				text += " (" + class + ")"
+			}
+
+			u.SendMessage(message.New(
+				s.Config.Server.Name,
+				message.RPL_WHOISOPERATOR,
+				[]string{
+					u.NickName,
+					target.NickName,
+				},
+				text,
+			))
+		}
+
+		if target.IsLoggedIn() {
+			u.SendMessage(message.New(
+				s.Config.Server.Name,
+				message.RPL_WHOISACCOUNT,
+				[]string{
+					u.NickName,
+					target.NickName,
+					target.Account(),
+				},
+				"is logged in as",
+			))
+		}
+
 		u.SendMessage(message.New(
 			s.Config.Server.Name,
 			message.RPL_ENDOFWHOIS,
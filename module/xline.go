@@ -0,0 +1,279 @@
+/*
+ * Copyright 2014 The starfruit Authors. All rights reserved.
+ * Use of this source code is governed by a BSD-style
+ * license that can be found in the LICENSE file.
+ */
+
+package module
+
+import (
+	"fmt"
+	"github.com/flatpeach/starfruit/ban"
+	"github.com/flatpeach/starfruit/message"
+	"github.com/flatpeach/starfruit/server"
+	"github.com/flatpeach/starfruit/user"
+	"log"
+	"net"
+	"time"
+)
+
+// K-lines ban user@host masks, D-lines IP addresses or networks and G-lines
+// realname patterns.
+
+type Kline struct{}
+
+func (module *Kline) Handle(s *server.Server, u *user.User, m *message.Message) error {
+	// KLINE [ <duration> ] <user@host> [ <reason> ]
+
+	addBan(s, u, m, ban.KindK)
+	return nil
+}
+
+type Unkline struct{}
+
+func (module *Unkline) Handle(s *server.Server, u *user.User, m *message.Message) error {
+	// UNKLINE <user@host>
+
+	removeBan(s, u, m, ban.KindK)
+	return nil
+}
+
+type Dline struct{}
+
+func (module *Dline) Handle(s *server.Server, u *user.User, m *message.Message) error {
+	// DLINE [ <duration> ] <ip | cidr> [ <reason> ]
+
+	addBan(s, u, m, ban.KindD)
+	return nil
+}
+
+type Undline struct{}
+
+func (module *Undline) Handle(s *server.Server, u *user.User, m *message.Message) error {
+	// UNDLINE <ip | cidr>
+
+	removeBan(s, u, m, ban.KindD)
+	return nil
+}
+
+type Gline struct{}
+
+func (module *Gline) Handle(s *server.Server, u *user.User, m *message.Message) error {
+	// GLINE [ <duration> ] <realname> [ <reason> ]
+
+	addBan(s, u, m, ban.KindG)
+	return nil
+}
+
+type Ungline struct{}
+
+func (module *Ungline) Handle(s *server.Server, u *user.User, m *message.Message) error {
+	// UNGLINE <realname>
+
+	removeBan(s, u, m, ban.KindG)
+	return nil
+}
+
+func addBan(s *server.Server, u *user.User, m *message.Message, kind string) {
+	command := kind + "LINE"
+
+	if !requireCapability(s, u, user.OperManageBans) {
+		return
+	}
+
+	params := m.Params
+
+	var duration time.Duration
+
+	if len(params) > 1 {
+		if d, ok := ban.ParseDuration(params[0]); ok {
+			duration, params = d, params[1:]
+		}
+	}
+
+	if len(params) == 0 {
+		u.SendErrorNeedMoreParams(command)
+		return
+	}
+
+	mask, err := ban.NormalizeMask(kind, params[0])
+	if err != nil {
+		u.SendFail(command, "INVALID_MASK", []string{params[0]}, "Invalid ban mask")
+		return
+	}
+
+	if ban.TooBroad(kind, mask) {
+		u.SendFail(command, "INVALID_MASK", []string{params[0]}, "Ban mask too broad")
+		return
+	}
+
+	reason := "No reason given"
+	if len(params) > 1 && params[1] != "" {
+		reason = params[1]
+	}
+
+	b := &ban.Ban{
+		Kind:   kind,
+		Mask:   mask,
+		Reason: reason,
+		SetBy:  u.NickName,
+		SetAt:  time.Now().Unix(),
+	}
+
+	if duration > 0 {
+		b.Expires = time.Now().Add(duration).Unix()
+	}
+
+	err = s.Bans.Add(b)
+	if err != nil {
+		log.Printf("[BAN] Failed to save the %s-line on %s :%s", kind, mask, err)
+		u.SendFail(command, "TEMPORARILY_UNAVAILABLE", []string{mask}, "The ban can't be saved right now")
+		return
+	}
+
+	log.Printf("[BAN] %s added a %s-line on %s (%s)", u.Full(), kind, mask, reason)
+
+	text := fmt.Sprintf("Added a %s-line on %s", kind, mask)
+	if duration > 0 {
+		text += fmt.Sprintf(" for %s", duration)
+	}
+
+	sendServerNotice(s, u, text)
+
+	if n := enforceBan(s, u, b); n > 0 {
+		sendServerNotice(s, u, fmt.Sprintf("%d user(s) matching the %s-line were disconnected", n, kind))
+	}
+
+	if banMatches(b, u) {
+		sendServerNotice(s, u, fmt.Sprintf("The %s-line matches you, you will be refused once disconnected", kind))
+	}
+}
+
+func removeBan(s *server.Server, u *user.User, m *message.Message, kind string) {
+	command := "UN" + kind + "LINE"
+
+	if !requireCapability(s, u, user.OperManageBans) {
+		return
+	}
+
+	if len(m.Params) == 0 {
+		u.SendErrorNeedMoreParams(command)
+		return
+	}
+
+	mask, err := ban.NormalizeMask(kind, m.Params[0])
+	if err == nil {
+		err = s.Bans.Remove(kind, mask)
+	}
+
+	if err != nil {
+		u.SendFail(command, "INVALID_MASK", []string{m.Params[0]}, fmt.Sprintf("No such %s-line", kind))
+		return
+	}
+
+	log.Printf("[BAN] %s removed the %s-line on %s", u.Full(), kind, mask)
+
+	sendServerNotice(s, u, fmt.Sprintf("Removed the %s-line on %s", kind, mask))
+}
+
+// enforceBan disconnects the users a new ban matches but the operator who
+// set it, and returns how many.
+func enforceBan(s *server.Server, setBy *user.User, b *ban.Ban) int {
+	var n int
+
+	for _, target := range s.GetAllUsers() {
+		if target.Id == setBy.Id || !target.IsRegistered() || !banMatches(b, target) {
+			continue
+		}
+
+		sendBanned(s, target, b)
+		disconnectUser(s, target, banReason(b))
+		n++
+	}
+
+	return n
+}
+
+// FindDline returns the D-line banning the address a connection comes from,
+// nil if it may connect.
+func FindDline(s *server.Server, addr net.Addr) *ban.Ban {
+	if s.Bans == nil {
+		return nil
+	}
+
+	ip := addrIP(addr)
+
+	for _, b := range s.Bans.All() {
+		if b.MatchesAddr(ip) {
+			return b
+		}
+	}
+
+	return nil
+}
+
+// findUserBan returns the ban matching a user, nil if he isn't banned
+func findUserBan(s *server.Server, u *user.User) *ban.Ban {
+	if s.Bans == nil {
+		return nil
+	}
+
+	for _, b := range s.Bans.All() {
+		if banMatches(b, u) {
+			return b
+		}
+	}
+
+	return nil
+}
+
+func banMatches(b *ban.Ban, u *user.User) bool {
+	ip := addrIP(u.Conn.RemoteAddr())
+
+	if b.MatchesAddr(ip) {
+		return true
+	}
+
+	return b.MatchesUser(u.UserName, u.HostName, ip.String(), u.RealName)
+}
+
+// banReason is the reason given to users thrown out by a ban
+func banReason(b *ban.Ban) string {
+	return b.Kind + "-lined: " + b.Reason
+}
+
+func sendBanned(s *server.Server, u *user.User, b *ban.Ban) {
+	nickName := u.NickName
+	if nickName == "" {
+		nickName = "*"
+	}
+
+	u.Deliver(message.New(
+		s.Config.Server.Name,
+		message.ERR_YOUREBANNEDCREEP,
+		[]string{nickName},
+		"You are banned from this server- "+b.Reason,
+	))
+}
+
+func sendServerNotice(s *server.Server, u *user.User, text string) {
+	u.SendMessage(message.New(
+		s.Config.Server.Name,
+		"NOTICE",
+		[]string{u.NickName},
+		text,
+	))
+}
+
+func addrIP(addr net.Addr) net.IP {
+	if tcp, ok := addr.(*net.TCPAddr); ok {
+		return tcp.IP
+	}
+
+	host, _, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		return nil
+	}
+
+	return net.ParseIP(host)
+}
@@ -0,0 +1,103 @@
+/*
+ * Copyright 2014 The starfruit Authors. All rights reserved.
+ * Use of this source code is governed by a BSD-style
+ * license that can be found in the LICENSE file.
+ */
+
+package registry
+
+import (
+	"github.com/flatpeach/starfruit/jsonfile"
+	"sort"
+	"sync"
+)
+
+// FileStore keeps all registered channels in memory and writes them to a
+// JSON file on every change, channels are only kept in memory without a
+// file name.
+type FileStore struct {
+	file     string
+	channels map[string]*Channel
+	mutex    sync.Mutex
+}
+
+func NewFileStore(file string) (*FileStore, error) {
+	fs := &FileStore{
+		file:     file,
+		channels: make(map[string]*Channel),
+	}
+
+	var channels []*Channel
+
+	err := jsonfile.Load(file, &channels)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, c := range channels {
+		fs.channels[Key(c.Name)] = c
+	}
+
+	return fs, nil
+}
+
+func (fs *FileStore) Get(name string) (*Channel, error) {
+	fs.mutex.Lock()
+	defer fs.mutex.Unlock()
+
+	c, exists := fs.channels[Key(name)]
+	if !exists {
+		return nil, ErrNotFound
+	}
+
+	return c, nil
+}
+
+// All returns the registered channels, sorted by name
+func (fs *FileStore) All() []*Channel {
+	fs.mutex.Lock()
+	defer fs.mutex.Unlock()
+
+	return fs.all()
+}
+
+func (fs *FileStore) all() []*Channel {
+	var channels []*Channel
+
+	for _, c := range fs.channels {
+		channels = append(channels, c)
+	}
+
+	sort.Slice(channels, func(i, j int) bool {
+		return Key(channels[i].Name) < Key(channels[j].Name)
+	})
+
+	return channels
+}
+
+func (fs *FileStore) Save(c *Channel) error {
+	fs.mutex.Lock()
+	defer fs.mutex.Unlock()
+
+	fs.channels[Key(c.Name)] = c
+
+	return fs.flush()
+}
+
+func (fs *FileStore) Delete(name string) error {
+	fs.mutex.Lock()
+	defer fs.mutex.Unlock()
+
+	_, exists := fs.channels[Key(name)]
+	if !exists {
+		return ErrNotFound
+	}
+
+	delete(fs.channels, Key(name))
+
+	return fs.flush()
+}
+
+func (fs *FileStore) flush() error {
+	return jsonfile.Save(fs.file, fs.all())
+}
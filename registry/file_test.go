@@ -0,0 +1,52 @@
+package registry
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestFileStore(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "channels.json")
+
+	fs, err := NewFileStore(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c := &Channel{Name: "#Konoha", Founder: "Naruto", Modes: "nt", Bans: []string{"*!*@sound"}}
+	c.SetLevel("Sakura", LevelVoice)
+
+	if err := fs.Save(c); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := fs.Save(&Channel{Name: "#akatsuki", Founder: "Pain"}); err != nil {
+		t.Fatal(err)
+	}
+
+	fs, err = NewFileStore(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c, err = fs.Get("#konoha")
+	if err != nil {
+		t.Fatal("channel names should be case insensitive")
+	}
+
+	if c.Modes != "nt" || len(c.Bans) != 1 || c.Level("sakura") != LevelVoice {
+		t.Error("channel settings should be kept")
+	}
+
+	if all := fs.All(); len(all) != 2 || all[0].Name != "#akatsuki" {
+		t.Error("all channels should be returned sorted by name")
+	}
+
+	if err := fs.Delete("#Konoha"); err != nil {
+		t.Error("failed to delete the channel")
+	}
+
+	if _, err := fs.Get("#Konoha"); err != ErrNotFound {
+		t.Error("channel should be deleted")
+	}
+}
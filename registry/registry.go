@@ -0,0 +1,83 @@
+/*
+ * Copyright 2014 The starfruit Authors. All rights reserved.
+ * Use of this source code is governed by a BSD-style
+ * license that can be found in the LICENSE file.
+ */
+
+package registry
+
+import (
+	"errors"
+	"github.com/flatpeach/starfruit/casemapping"
+)
+
+var ErrNotFound = errors.New("Channel not registered")
+
+// Access levels of accounts on a registered channel
+const (
+	LevelOp    = "op"
+	LevelVoice = "voice"
+)
+
+// Channel is a channel registered to an account, whose settings survive
+// restarts and the last member leaving.
+type Channel struct {
+	Name       string            `json:"name"`
+	Founder    string            `json:"founder"` // Account which registered the channel
+	CreatedAt  int64             `json:"created"`
+	Topic      string            `json:"topic,omitempty"`
+	TopicSetBy string            `json:"topic_set_by,omitempty"`
+	TopicSetAt int64             `json:"topic_set_at,omitempty"`
+	Modes      string            `json:"modes,omitempty"` // Flag modes, without parameter
+	Key        string            `json:"key,omitempty"`
+	Limit      int               `json:"limit,omitempty"`
+	Bans       []string          `json:"bans,omitempty"`
+	Access     map[string]string `json:"access,omitempty"` // Access levels, by account key
+	Metadata   map[string]string `json:"metadata,omitempty"`
+}
+
+// Store keeps the registered channels, channel names are case insensitive
+type Store interface {
+	Get(name string) (*Channel, error)
+	All() []*Channel
+	Save(c *Channel) error
+	Delete(name string) error
+}
+
+// Level returns the access level of an account, the founder is an operator
+func (c *Channel) Level(account string) string {
+	if account == "" {
+		return ""
+	}
+
+	if Key(account) == Key(c.Founder) {
+		return LevelOp
+	}
+
+	return c.Access[Key(account)]
+}
+
+func (c *Channel) SetLevel(account string, level string) {
+	if c.Access == nil {
+		c.Access = make(map[string]string)
+	}
+
+	c.Access[Key(account)] = level
+}
+
+func (c *Channel) RemoveLevel(account string) bool {
+	_, exists := c.Access[Key(account)]
+	delete(c.Access, Key(account))
+
+	return exists
+}
+
+// ValidLevel tells whether a level may be given to an account
+func ValidLevel(level string) bool {
+	return level == LevelOp || level == LevelVoice
+}
+
+// Key returns the name channels and accounts are indexed with
+func Key(name string) string {
+	return casemapping.Fold(name)
+}
@@ -0,0 +1,30 @@
+package registry
+
+import (
+	"testing"
+)
+
+func TestLevel(t *testing.T) {
+	c := &Channel{Name: "#konoha", Founder: "Naruto"}
+
+	if c.Level("naruto") != LevelOp {
+		t.Error("founder should be an operator")
+	}
+
+	if c.Level("") != "" || c.Level("Sasuke") != "" {
+		t.Error("accounts without access should have no level")
+	}
+
+	c.SetLevel("Sakura", LevelVoice)
+	if c.Level("SAKURA") != LevelVoice {
+		t.Error("account names should be case insensitive")
+	}
+
+	if !c.RemoveLevel("sakura") || c.RemoveLevel("sakura") || c.Level("Sakura") != "" {
+		t.Error("access should be removed once")
+	}
+
+	if !ValidLevel(LevelOp) || ValidLevel("owner") {
+		t.Error("only known levels should be valid")
+	}
+}
@@ -0,0 +1,113 @@
+/*
+ * Copyright 2014 The starfruit Authors. All rights reserved.
+ * Use of this source code is governed by a BSD-style
+ * license that can be found in the LICENSE file.
+ */
+
+package server
+
+import (
+	"github.com/flatpeach/starfruit/casemapping"
+	"github.com/flatpeach/starfruit/message"
+	"github.com/flatpeach/starfruit/user"
+	"sort"
+)
+
+// Monitor adds a nick to the ones a user wants to be told about when it
+// comes online or goes offline.
+func (s *Server) Monitor(u *user.User, nick string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	key := casemapping.Fold(nick)
+
+	if s.monitored[u.Id] == nil {
+		s.monitored[u.Id] = make(map[string]string)
+	}
+	s.monitored[u.Id][key] = nick
+
+	if s.watchers[key] == nil {
+		s.watchers[key] = make(map[int]*user.User)
+	}
+	s.watchers[key][u.Id] = u
+}
+
+func (s *Server) Unmonitor(uid int, nick string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.unmonitor(uid, casemapping.Fold(nick))
+}
+
+// ClearMonitor removes all nicks monitored by a user
+func (s *Server) ClearMonitor(uid int) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.clearMonitor(uid)
+}
+
+func (s *Server) unmonitor(uid int, key string) {
+	delete(s.monitored[uid], key)
+	if len(s.monitored[uid]) == 0 {
+		delete(s.monitored, uid)
+	}
+
+	delete(s.watchers[key], uid)
+	if len(s.watchers[key]) == 0 {
+		delete(s.watchers, key)
+	}
+}
+
+func (s *Server) clearMonitor(uid int) {
+	for key := range s.monitored[uid] {
+		s.unmonitor(uid, key)
+	}
+}
+
+// IsMonitored tells whether a user monitors a nick
+func (s *Server) IsMonitored(uid int, nick string) bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	_, exists := s.monitored[uid][casemapping.Fold(nick)]
+
+	return exists
+}
+
+// MonitoredNickNames returns the nicks monitored by a user, sorted
+func (s *Server) MonitoredNickNames(uid int) []string {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	var nicks []string
+	for _, nick := range s.monitored[uid] {
+		nicks = append(nicks, nick)
+	}
+	sort.Strings(nicks)
+
+	return nicks
+}
+
+// notifyWatchers tells the users monitoring a nick that it came online, as
+// the given user, or went offline if the user is nil.
+func (s *Server) notifyWatchers(nick string, u *user.User) {
+	watchers := s.watchers[casemapping.Fold(nick)]
+	if len(watchers) == 0 {
+		return
+	}
+
+	code, target := message.RPL_MONOFFLINE, nick
+	if u != nil {
+		code, target = message.RPL_MONONLINE, u.Full()
+	}
+
+	for _, watcher := range watchers {
+		watcher.Deliver(message.New(
+			s.Config.Server.Name,
+			code,
+			[]string{watcher.NickName},
+			target,
+		))
+	}
+}
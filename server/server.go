@@ -8,39 +8,69 @@ package server
 
 import (
 	"fmt"
+	"github.com/flatpeach/starfruit/account"
+	"github.com/flatpeach/starfruit/auth"
+	"github.com/flatpeach/starfruit/ban"
+	"github.com/flatpeach/starfruit/capability"
+	"github.com/flatpeach/starfruit/casemapping"
 	"github.com/flatpeach/starfruit/channel"
 	"github.com/flatpeach/starfruit/config"
+	"github.com/flatpeach/starfruit/history"
+	"github.com/flatpeach/starfruit/isupport"
+	"github.com/flatpeach/starfruit/mailer"
 	"github.com/flatpeach/starfruit/message"
+	"github.com/flatpeach/starfruit/registry"
 	"github.com/flatpeach/starfruit/user"
+	"sort"
 	"sync"
 	"time"
 )
 
 type Server struct {
-	Config    *config.Config // Config for current IRC Server
+	Config    *config.Config       // Config for current IRC Server
+	ISupport  *isupport.Registry   // Tokens advertised with RPL_ISUPPORT
+	Caps      *capability.Registry // Capabilities offered with CAP LS
+	Accounts  account.Store        // Accounts users authenticate against
+	Registry  registry.Store       // Channels registered to accounts
+	Bans      ban.Store            // K-lines, D-lines and G-lines
+	PassAuth  auth.Authenticator   // Checks PASS, nil if no password is required
+	SaslAuth  auth.Authenticator   // Checks SASL PLAIN
+	Tokens    *auth.JWT            // Checks SASL OAUTHBEARER, nil if not configured
+	Mailer    *mailer.Mailer       // Sends codes by email, nil if no SMTP relay is configured
+	Rehash    func() error         // Reloads the configuration file
+	History   history.Store        // History of channels, nil if disabled
+	Direct    history.Store        // History of private conversations, nil if disabled
 	StartedAt time.Time
 
 	channels map[int]*channel.Channel // All channels in this server
 	users    map[int]*user.User       // All users existed in this server
 
-	nicknames map[string]*user.User
+	nicknames map[string]*user.User // Registered users, by folded nick
+
+	watchers  map[string]map[int]*user.User // Users monitoring a nick, by folded nick
+	monitored map[int]map[string]string     // Nicks monitored by a user, by folded nick
 
 	userToChannels map[int][]int // User to channels list
 
 	maxUserId    int // Current the max user id
 	maxChannelId int // current the max channel id
 
-	mutex sync.Mutex
+	mutex       sync.Mutex
+	configMutex sync.RWMutex // Held for writing while the config is replaced
 }
 
 func New() *Server {
 	s := &Server{
-		Config: nil,
+		Config:   nil,
+		ISupport: isupport.New(),
+		Caps:     capability.New(),
 
 		channels:       make(map[int]*channel.Channel),
 		nicknames:      make(map[string]*user.User),
 		users:          make(map[int]*user.User),
 		userToChannels: make(map[int][]int),
+		watchers:       make(map[string]map[int]*user.User),
+		monitored:      make(map[int]map[string]string),
 
 		maxUserId:    0,
 		maxChannelId: 0,
@@ -91,8 +121,14 @@ func (s *Server) FindChannelByName(c string) *channel.Channel {
 	s.mutex.Lock()
 	defer s.mutex.Unlock()
 
+	return s.findChannelByName(c)
+}
+
+// findChannelByName looks a channel up whatever the case of its name, as
+// advertised by CASEMAPPING.
+func (s *Server) findChannelByName(c string) *channel.Channel {
 	for _, v := range s.channels {
-		if v.String() == c {
+		if casemapping.Equal(v.String(), c) {
 			return v
 		}
 	}
@@ -136,10 +172,8 @@ func (s *Server) FindOrCreateChannel(name string) (*channel.Channel, error) {
 	s.mutex.Lock()
 	defer s.mutex.Unlock()
 
-	for _, v := range s.channels {
-		if v.String() == name {
-			return v, nil
-		}
+	if c := s.findChannelByName(name); c != nil {
+		return c, nil
 	}
 
 	return s.createChannel(name)
@@ -149,7 +183,7 @@ func (s *Server) RemoveChannel(name string) error {
 	s.mutex.Lock()
 	defer s.mutex.Unlock()
 
-	c := s.FindChannelByName(name)
+	c := s.findChannelByName(name)
 	if c != nil {
 		delete(s.channels, c.Id)
 	}
@@ -192,35 +226,38 @@ func (s *Server) RegisterUser(u *user.User) (bool, error) {
 	defer s.mutex.Unlock()
 
 	s.users[u.Id] = u
-	s.nicknames[u.NickName] = u
+	s.nicknames[casemapping.Fold(u.NickName)] = u
+	s.notifyWatchers(u.NickName, u)
+
 	return true, nil
 }
 
-func (s *Server) RegisterNickName(nick string, u *user.User) {
+// ChangeNickName moves a user renamed from oldNickName to his new nick
+func (s *Server) ChangeNickName(u *user.User, oldNickName string) {
 	s.mutex.Lock()
 	defer s.mutex.Unlock()
 
-	s.nicknames[u.NickName] = u
-}
+	oldKey, newKey := casemapping.Fold(oldNickName), casemapping.Fold(u.NickName)
 
-func (s *Server) UnregisterNickName(nick string) {
-	s.mutex.Lock()
-	defer s.mutex.Unlock()
+	s.nicknames[newKey] = u
+
+	// A nick changed to another case of itself is still online
+	if oldKey == newKey {
+		return
+	}
 
-	delete(s.nicknames, nick)
+	delete(s.nicknames, oldKey)
+	s.notifyWatchers(oldNickName, nil)
+	s.notifyWatchers(u.NickName, u)
 }
 
+// GetUserByNickName looks a user up by nick whatever its case, as
+// advertised by CASEMAPPING.
 func (s *Server) GetUserByNickName(name string) *user.User {
 	s.mutex.Lock()
 	defer s.mutex.Unlock()
 
-	for _, user := range s.users {
-		if user.NickName == name {
-			return user
-		}
-	}
-
-	return nil
+	return s.nicknames[casemapping.Fold(name)]
 }
 
 func (s *Server) RemoveUser(uid int) {
@@ -237,10 +274,12 @@ func (s *Server) RemoveUser(uid int) {
 
 	u := s.users[uid]
 	if u != nil {
-		delete(s.nicknames, u.NickName)
+		delete(s.nicknames, casemapping.Fold(u.NickName))
+		s.notifyWatchers(u.NickName, nil)
 	}
 	delete(s.users, uid)
 	delete(s.userToChannels, uid)
+	s.clearMonitor(uid)
 }
 
 func (s *Server) ExistsUser(uid int) bool {
@@ -301,7 +340,7 @@ func (s *Server) IsNickNameRegistered(nick string) bool {
 	s.mutex.Lock()
 	defer s.mutex.Unlock()
 
-	_, exists := s.nicknames[nick]
+	_, exists := s.nicknames[casemapping.Fold(nick)]
 
 	return exists
 }
@@ -316,6 +355,82 @@ func (s *Server) BroadcastMessage(cid int, m *message.Message, excludeIds []int)
 	}
 }
 
+func (s *Server) BroadcastMessageFunc(cid int, f func(u *user.User) *message.Message, excludeIds []int) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	cnl := s.channels[cid]
+	if cnl != nil {
+		cnl.BroadcastFunc(f, excludeIds)
+	}
+}
+
+// BroadcastReply is BroadcastMessage for a message the command of a member
+// causes, his own copy is sent as a reply to his command.
+func (s *Server) BroadcastReply(u *user.User, cid int, m *message.Message) {
+	s.BroadcastReplyFunc(u, cid, func(member *user.User) *message.Message {
+		return m
+	})
+}
+
+// BroadcastReplyFunc is BroadcastReply with a message built for each member
+func (s *Server) BroadcastReplyFunc(u *user.User, cid int, f func(u *user.User) *message.Message) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	cnl := s.channels[cid]
+	if cnl == nil {
+		return
+	}
+
+	if cnl.Exists(u.Id) {
+		if m := f(u); m != nil {
+			u.SendMessage(m)
+		}
+	}
+
+	cnl.BroadcastFunc(f, []int{u.Id})
+}
+
+// GetNeighbours returns all users sharing at least one channel with the
+// given user, the user himself excluded.
+func (s *Server) GetNeighbours(uid int) []*user.User {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	var (
+		neighbours []*user.User
+		seen       = map[int]bool{uid: true}
+	)
+
+	for _, cnl := range s.getJoinedChannels(uid) {
+		if cnl == nil {
+			continue
+		}
+
+		for _, u := range cnl.JoinedUsers() {
+			if seen[u.Id] {
+				continue
+			}
+
+			seen[u.Id] = true
+			neighbours = append(neighbours, u)
+		}
+	}
+
+	return neighbours
+}
+
+// NotifyNeighbours sends a message to the users sharing a channel with the
+// given user, only to the ones who negotiated the capability.
+func (s *Server) NotifyNeighbours(uid int, capName string, m *message.Message) {
+	for _, u := range s.GetNeighbours(uid) {
+		if u.HasCap(capName) {
+			u.Deliver(m)
+		}
+	}
+}
+
 func (s *Server) JoinChannel(uid int, cid int) {
 	s.mutex.Lock()
 	defer s.mutex.Unlock()
@@ -349,4 +464,123 @@ func (s *Server) QuitFromChannel(uid int, cid int) {
 	if cnl != nil {
 		cnl.Quit(uid)
 	}
+
+	cids := s.userToChannels[uid]
+	for idx, channelId := range cids {
+		if channelId == cid {
+			s.userToChannels[uid] = append(cids[:idx], cids[idx+1:]...)
+			break
+		}
+	}
+}
+
+// SetCapabilities replaces the capabilities offered by the given ones, by
+// name, the changes are announced as AddCapability and RemoveCapability do.
+func (s *Server) SetCapabilities(caps map[string]string) {
+	for _, name := range s.Caps.Names() {
+		if _, kept := caps[name]; !kept {
+			s.RemoveCapability(name)
+		}
+	}
+
+	var names []string
+	for name := range caps {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		s.AddCapability(name, caps[name])
+	}
+}
+
+// SetISupport replaces the tokens advertised with RPL_ISUPPORT, registered
+// users are sent the ones which changed.
+func (s *Server) SetISupport(tokens map[string]string) {
+	changed := s.ISupport.Replace(tokens)
+	if len(changed) == 0 {
+		return
+	}
+
+	for _, u := range s.GetAllUsers() {
+		if u.IsRegistered() {
+			u.DeliverISupport(changed)
+		}
+	}
+}
+
+// AddCapability offers a new capability at runtime, or changes its value,
+// users who negotiated cap-notify are told about it with CAP NEW.
+func (s *Server) AddCapability(name string, value string) {
+	if old, exists := s.Caps.Value(name); exists && old == value {
+		return
+	}
+
+	s.Caps.Register(name, value)
+
+	for _, u := range s.GetAllUsers() {
+		if !u.HasCap(capability.CapNotify) {
+			continue
+		}
+
+		u.Deliver(message.New(
+			s.Config.Server.Name,
+			"CAP",
+			[]string{capNickName(u), "NEW"},
+			s.Caps.Token(name, u.CapVersion()),
+		))
+	}
+}
+
+// capNickName returns the nick CAP messages are sent to, "*" while the user
+// has none yet.
+func capNickName(u *user.User) string {
+	if u.NickName == "" {
+		return "*"
+	}
+
+	return u.NickName
+}
+
+// RemoveCapability withdraws a capability at runtime, users who negotiated
+// cap-notify are told about it with CAP DEL.
+func (s *Server) RemoveCapability(name string) {
+	if !s.Caps.Exists(name) {
+		return
+	}
+
+	s.Caps.Unregister(name)
+
+	for _, u := range s.GetAllUsers() {
+		u.DisableCap(name)
+
+		if !u.HasCap(capability.CapNotify) {
+			continue
+		}
+
+		u.Deliver(message.New(
+			s.Config.Server.Name,
+			"CAP",
+			[]string{capNickName(u), "DEL"},
+			name,
+		))
+	}
+}
+
+// ReadConfig runs f while the configuration can't be replaced, requests are
+// handled through it as is anything else reading the configuration.
+func (s *Server) ReadConfig(f func()) {
+	s.configMutex.RLock()
+	defer s.configMutex.RUnlock()
+
+	f()
+}
+
+// ReplaceConfig runs f, which replaces the configuration, once no request is
+// being handled.
+func (s *Server) ReplaceConfig(f func()) {
+	s.configMutex.Lock()
+	defer s.configMutex.Unlock()
+
+	f()
 }
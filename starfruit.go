@@ -12,14 +12,24 @@ import (
 	"crypto/tls"
 	"flag"
 	"fmt"
+	"github.com/flatpeach/starfruit/account"
+	"github.com/flatpeach/starfruit/auth"
+	"github.com/flatpeach/starfruit/ban"
+	"github.com/flatpeach/starfruit/capability"
+	"github.com/flatpeach/starfruit/casemapping"
+	"github.com/flatpeach/starfruit/channel"
 	"github.com/flatpeach/starfruit/command"
 	"github.com/flatpeach/starfruit/config"
+	"github.com/flatpeach/starfruit/history"
+	"github.com/flatpeach/starfruit/mailer"
 	"github.com/flatpeach/starfruit/message"
 	"github.com/flatpeach/starfruit/module"
+	"github.com/flatpeach/starfruit/registry"
 	"github.com/flatpeach/starfruit/server"
 	"github.com/flatpeach/starfruit/user"
 	"log"
 	"net"
+	"path/filepath"
 	"strconv"
 	"strings"
 	"time"
@@ -45,57 +55,68 @@ var (
 
 func doUserChecking() {
 	for {
-		time.Sleep(time.Duration(s.Config.Recycle.PingInterval) * time.Second)
-		ts := time.Now().Unix()
-		log.Printf("[starfruit] Ready to scan the status of all users: %d", ts)
-
-		users := s.GetAllUsers()
-		for _, u := range users {
-			if u.IsDisconnecting() {
-				continue
-			}
+		var interval time.Duration
+		s.ReadConfig(func() {
+			interval = time.Duration(s.Config.Recycle.PingInterval) * time.Second
+		})
 
-			if u.LastPongTime != 0 && ts-u.LastPongTime > int64(s.Config.Recycle.UserTimeout) {
-				timeoutMsg := message.New(
-					u.Full(),
-					"QUIT",
-					nil,
-					fmt.Sprintf("ping timeout after %d seconds.", int64(s.Config.Recycle.UserTimeout)),
-				)
+		time.Sleep(interval)
+		s.ReadConfig(checkUsers)
+	}
+}
 
-				s.RemoveUser(u.Id)
+// checkUsers pings all users, the ones which didn't answer the last ping in
+// time are disconnected.
+func checkUsers() {
+	ts := time.Now().Unix()
+	log.Printf("[starfruit] Ready to scan the status of all users: %d", ts)
 
-				channels := s.GetJoinedChannels(u.Id)
-				for _, cnl := range channels {
-					s.BroadcastMessage(cnl.Id, timeoutMsg, nil)
-				}
+	users := s.GetAllUsers()
+	for _, u := range users {
+		if u.IsDisconnecting() {
+			continue
+		}
 
-				u.SendMessage(message.New(
-					nil,
-					"ERROR",
-					nil,
-					fmt.Sprintf("Closing Link: %s (Ping timeout: %d seconds)", u.HostName, s.Config.Recycle.UserTimeout),
-				))
+		if u.LastPongTime != 0 && ts-u.LastPongTime > int64(s.Config.Recycle.UserTimeout) {
+			timeoutMsg := message.New(
+				u.Full(),
+				"QUIT",
+				nil,
+				fmt.Sprintf("ping timeout after %d seconds.", int64(s.Config.Recycle.UserTimeout)),
+			)
+			timeoutMsg.Stamp()
 
-				u.SendMessage(nil)
-				u.EnterStatus(user.StatusDisconnecting)
+			s.RemoveUser(u.Id)
 
-				continue
+			channels := s.GetJoinedChannels(u.Id)
+			for _, cnl := range channels {
+				s.BroadcastMessage(cnl.Id, timeoutMsg, nil)
 			}
 
-			u.SendMessage(message.New(
-				s.Config.Server.Name,
-				"PING",
-				[]string{
-					fmt.Sprintf("%d", ts),
-				},
+			u.Deliver(message.New(
 				nil,
+				"ERROR",
+				nil,
+				fmt.Sprintf("Closing Link: %s (Ping timeout: %d seconds)", u.HostName, s.Config.Recycle.UserTimeout),
 			))
-		}
 
-		log.Printf("[starfruit] Done to scan the status of all users for this time")
+			u.Deliver(nil)
+			u.EnterStatus(user.StatusDisconnecting)
+
+			continue
+		}
 
+		u.Deliver(message.New(
+			s.Config.Server.Name,
+			"PING",
+			[]string{
+				fmt.Sprintf("%d", ts),
+			},
+			nil,
+		))
 	}
+
+	log.Printf("[starfruit] Done to scan the status of all users for this time")
 }
 
 func doResponse(u *user.User) {
@@ -107,7 +128,7 @@ func doResponse(u *user.User) {
 		log.Printf("[Client:%s] Reply %s", u.Conn.RemoteAddr(), string(buf))
 		_, err := u.Conn.Write(buf)
 		if err != nil {
-			log.Printf("[Client:%s] Failed to send reply message")
+			log.Printf("[Client:%s] Failed to send reply message", u.Conn.RemoteAddr())
 			u.SendMessage(nil)
 		}
 	}
@@ -120,7 +141,12 @@ func doRequest(u *user.User) {
 			return
 		}
 
-		m, err := message.Parse(string(buf))
+		m, err := message.ParseClient(string(buf))
+		if err == message.ErrInputTooLong {
+			sendInputTooLong(u)
+			continue
+		}
+
 		if err != nil {
 			log.Printf("[Client:%s] Malformed message %s", u.Conn.RemoteAddr(), err)
 			continue
@@ -128,82 +154,126 @@ func doRequest(u *user.User) {
 
 		log.Printf("[Client:%s] Request %s", u.Conn.RemoteAddr(), m)
 
-		cmd, ok := commands[m.Command]
-		if !ok {
-			log.Printf("[Client:%s] Unknown command %s", u.Conn.RemoteAddr(), m.Command)
-			if u.IsRegistered() {
-				u.SendMessage(message.New(
-					u.Config.Server.Name,
-					message.ERR_UNKNOWNCOMMAND,
-					[]string{u.NickName, m.Command},
-					"Unknown command",
-				))
-			}
+		u.Serialize(func() {
+			s.ReadConfig(func() {
+				label, labeled := m.Tag("label")
+				if labeled && u.HasCap(capability.LabeledResponse) && !module.OpensBatch(m) {
+					// Collect all replies to send them back tagged with the label
+					u.StartCollecting()
+					handleRequest(u, m)
+					u.SendLabeledResponse(label, u.StopCollecting())
+				} else {
+					handleRequest(u, m)
+				}
+			})
+		})
+	}
+}
 
-			continue
+func handleRequest(u *user.User, m *message.Message) {
+	// Messages of a batch the client is sending are held until it ends
+	if u.IsRegistered() && module.AddToBatch(s, u, m) {
+		return
+	}
+
+	cmd, ok := commands[m.Command]
+	if !ok {
+		log.Printf("[Client:%s] Unknown command %s", u.Conn.RemoteAddr(), m.Command)
+		if u.IsRegistered() {
+			u.SendMessage(message.New(
+				u.Config.Server.Name,
+				message.ERR_UNKNOWNCOMMAND,
+				[]string{u.NickName, m.Command},
+				"Unknown command",
+			))
 		}
 
-		if !u.IsRegistered() {
-			// We only allow limited commands before user registered successfully
-			if m.Command != "PASS" && m.Command != "USER" && m.Command != "NICK" {
-				u.SendMessage(message.New(
-					u.Config.Server.Name,
-					message.ERR_NOTREGISTERED,
-					[]string{"*"},
-					"You have not registered",
-				))
-
-				continue
-			}
-		} else {
-			if m.Command == "PASS" || m.Command == "USER" || m.Command == "SERVICE" {
-				u.SendMessage(message.New(
-					u.Config.Server.Name,
-					message.ERR_ALREADYREGISTRED,
-					[]string{u.NickName},
-					"Already registered",
-				))
-
-				continue
-			}
+		return
+	}
+
+	if !u.IsRegistered() {
+		// We only allow limited commands before user registered successfully
+		if m.Command != "PASS" && m.Command != "USER" && m.Command != "NICK" &&
+			m.Command != "CAP" && m.Command != "AUTHENTICATE" &&
+			m.Command != "REGISTER" && m.Command != "VERIFY" {
+			u.SendMessage(message.New(
+				u.Config.Server.Name,
+				message.ERR_NOTREGISTERED,
+				[]string{"*"},
+				"You have not registered",
+			))
+
+			return
 		}
+	} else {
+		if m.Command == "PASS" || m.Command == "USER" || m.Command == "SERVICE" {
+			u.SendMessage(message.New(
+				u.Config.Server.Name,
+				message.ERR_ALREADYREGISTRED,
+				[]string{u.NickName},
+				"Already registered",
+			))
+
+			return
+		}
+	}
+
+	if len(s.Config.Server.DisabledCommands) > 0 {
+		for _, command := range s.Config.Server.DisabledCommands {
+			if command == m.Command {
+				switch m.Command {
+				case "USERS":
+					u.SendMessage(message.New(
+						u.Config.Server.Name,
+						message.ERR_USERSDISABLED,
+						[]string{u.NickName},
+						"USERS has been disabled",
+					))
 
-		if len(s.Config.Server.DisabledCommands) > 0 {
-			for _, command := range s.Config.Server.DisabledCommands {
-				if command == m.Command {
-					switch m.Command {
-					case "USERS":
-						u.SendMessage(message.New(
-							u.Config.Server.Name,
-							message.ERR_USERSDISABLED,
-							[]string{u.NickName},
-							"USERS has been disabled",
-						))
-
-					}
-
-					continue
 				}
+
+				return
 			}
 		}
+	}
 
-		err = cmd.(command.Command).Handle(s, u, m)
+	err := cmd.(command.Command).Handle(s, u, m)
 
-		if err != nil {
-			log.Printf("Client:%s] Error %s", err)
-			continue
-		}
+	if err != nil {
+		log.Printf("[Client:%s] Error %s", u.Conn.RemoteAddr(), err)
 	}
 }
 
+func sendInputTooLong(u *user.User) {
+	nickName := u.NickName
+	if nickName == "" {
+		nickName = "*"
+	}
+
+	u.SendMessage(message.New(
+		u.Config.Server.Name,
+		message.ERR_INPUTTOOLONG,
+		[]string{nickName},
+		"Input line was too long",
+	))
+}
+
 func doConn(u *user.User) {
-	reader := bufio.NewReader(u.Conn)
+	// Room for the longest tags a client may send plus the message itself
+	reader := bufio.NewReaderSize(u.Conn, message.MaxClientTagsLength+512)
 
 	go doRequest(u)
 	go doResponse(u)
 
 	for {
-		buf, _, err := reader.ReadLine()
+		buf, isPrefix, err := reader.ReadLine()
+
+		// Drop the rest of a line which doesn't fit in the buffer
+		tooLong := isPrefix
+		for isPrefix && err == nil {
+			_, isPrefix, err = reader.ReadLine()
+		}
+
 		if err != nil {
 			log.Printf("[Client:%s] Remote connection already closed!", u.Conn.RemoteAddr())
 			s.RemoveUser(u.Id)
@@ -212,8 +282,16 @@ func doConn(u *user.User) {
 			break
 		}
 
+		if tooLong {
+			sendInputTooLong(u)
+			continue
+		}
+
 		if len(buf) > 0 {
-			u.In <- buf
+			// The buffer is reused by the reader for the next line
+			line := make([]byte, len(buf))
+			copy(line, buf)
+			u.In <- line
 		}
 	}
 }
@@ -228,7 +306,354 @@ func registerCmd(cmd string, v interface{}) {
 	commands[cmd] = v
 }
 
-func doListen(listener net.Listener) {
+// registerISupport advertises the tokens the configuration gives, the users
+// are told about the ones a rehash changes.
+func registerISupport() {
+	tokens := map[string]string{
+		"NETWORK":     s.Config.Server.Network,
+		"CASEMAPPING": casemapping.Name,
+		"CHANTYPES":   channel.NS_ALL_RAW,
+		"PREFIX":      "(ov)@+",
+		"CHANMODES":   "b,k,l,imnpst",
+		"NICKLEN":     strconv.Itoa(user.MaxNickNameLength),
+		"CHANNELLEN":  strconv.Itoa(channel.MAX_NAME_LENGTH),
+		"MONITOR":     "",
+	}
+
+	if limit := s.Config.Monitor.MaxTargets; limit > 0 {
+		tokens["MONITOR"] = strconv.Itoa(limit)
+	}
+
+	if deny := clientTagDeny(); deny != "" {
+		tokens["CLIENTTAGDENY"] = deny
+	}
+
+	if s.History != nil {
+		tokens["CHATHISTORY"] = strconv.Itoa(module.MaxChatHistoryItems)
+	}
+
+	s.SetISupport(tokens)
+}
+
+// clientTagDeny tells clients which client-only tags are never relayed, as
+// every tag but the allowed ones.
+func clientTagDeny() string {
+	deny := []string{"*"}
+
+	for _, tag := range s.Config.Tags.Allowed {
+		if tag == "*" {
+			return ""
+		}
+
+		if tag != "" {
+			deny = append(deny, "-"+strings.TrimPrefix(tag, "+"))
+		}
+	}
+
+	return strings.Join(deny, ",")
+}
+
+// registerCaps offers the capabilities the configuration enables, the users
+// are told about the ones a rehash adds or withdraws.
+func registerCaps() {
+	caps := map[string]string{
+		capability.CapNotify:       "",
+		capability.MessageTags:     "",
+		capability.Sasl:            strings.Join(module.SaslMechanisms(s), ","),
+		capability.ServerTime:      "",
+		capability.EchoMessage:     "",
+		capability.AwayNotify:      "",
+		capability.AccountNotify:   "",
+		capability.ExtendedJoin:    "",
+		capability.ChgHost:         "",
+		capability.MultiPrefix:     "",
+		capability.UserhostInNames: "",
+		capability.Batch:           "",
+		capability.LabeledResponse: "",
+		capability.Metadata: fmt.Sprintf(
+			"max-subs=%d,max-keys=%d,max-value-bytes=%d",
+			s.Config.Metadata.MaxSubs,
+			s.Config.Metadata.MaxKeys,
+			s.Config.Metadata.MaxValueBytes,
+		),
+		capability.Multiline: fmt.Sprintf(
+			"max-bytes=%d,max-lines=%d",
+			s.Config.Multiline.MaxBytes,
+			s.Config.Multiline.MaxLines,
+		),
+	}
+
+	if s.Config.Register.Enabled {
+		caps[capability.Registration] = registrationFlags()
+	}
+
+	if s.History != nil {
+		caps[capability.ChatHistory] = ""
+		caps[capability.EventPlayback] = ""
+		caps[capability.Redaction] = ""
+	}
+
+	s.SetCapabilities(caps)
+}
+
+// loadConfig reads the configuration file, the parameters given on the command
+// line take precedence.
+func loadConfig() (*config.Config, error) {
+	cf := config.New()
+
+	if configFile != "" {
+		err := cf.LoadFromFile(configFile)
+		if err != nil {
+			return nil, err
+		}
+		log.Printf("[starfruit] Load the configuration file :%s", configFile)
+	}
+
+	/* Handle overrided parameters from command line */
+	if configIp != "" {
+		cf.Server.Ip = configIp
+	}
+
+	if configPorts != "" {
+		ports := strings.Split(configPorts, ",")
+		for _, port := range ports {
+			cf.Server.Ports = nil
+			port, err := strconv.Atoi(port)
+			if err != nil {
+				return nil, fmt.Errorf("port specified error, %s", err)
+			}
+			cf.Server.Ports = append(cf.Server.Ports, port)
+		}
+	}
+
+	if configServerName != "" {
+		cf.Server.Name = configServerName
+	}
+
+	if configEnableAuth && configPassword != "" {
+		cf.Server.Password = configPassword
+	}
+
+	if configMotdFile != "" {
+		cf.Motd.File = configMotdFile
+	}
+
+	if configSSL {
+		cf.Server.SSL = configSSL
+	}
+
+	if configCertFile != "" {
+		cf.Server.CertFile = configCertFile
+	}
+
+	if configKeyFile != "" {
+		cf.Server.KeyFile = configKeyFile
+	}
+
+	if configPingUserInterval > -1 {
+		cf.Recycle.PingInterval = configPingUserInterval
+	}
+
+	if configUserTimeout > -1 {
+		cf.Recycle.UserTimeout = configUserTimeout
+	}
+
+	if configDisabledCommands != "" {
+		commands := strings.Split(configDisabledCommands, ",")
+		cf.Server.DisabledCommands = commands
+	}
+
+	if !module.ValidGuestPrefix(cf.Nick.GuestPrefix) {
+		return nil, fmt.Errorf("guest-prefix %s is too long for nicks", cf.Nick.GuestPrefix)
+	}
+
+	return cf, nil
+}
+
+// rehash reloads the configuration file, what was opened or bound at start is
+// kept as it was. Nothing changes unless the whole configuration is valid.
+func rehash() error {
+	cf, err := loadConfig()
+	if err != nil {
+		return err
+	}
+
+	backends, err := loadAuth(cf)
+	if err != nil {
+		return err
+	}
+
+	m, err := loadMailer(cf.Smtp)
+	if err != nil {
+		return err
+	}
+
+	// The rehash is a request itself, the configuration is replaced once it
+	// and the others being handled are done
+	go s.ReplaceConfig(func() {
+		// Users share the configuration of the server, it is updated in place
+		*s.Config = *cf
+
+		backends.install()
+		s.Mailer = m
+
+		registerISupport()
+		registerCaps()
+	})
+
+	return nil
+}
+
+// authenticators are the backends checking PASS, SASL PLAIN and OAUTHBEARER
+type authenticators struct {
+	pass   auth.Authenticator
+	sasl   auth.Authenticator
+	tokens *auth.JWT
+}
+
+// loadAuth sets the backends a configuration asks for up
+func loadAuth(cf *config.Config) (*authenticators, error) {
+	var (
+		a   = &authenticators{}
+		err error
+	)
+
+	if cf.PasswordRequired() {
+		a.pass, err = newAuthenticator(cf, cf.Auth.Pass)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// Static only checks a password, SASL must tell the account
+	if cf.Auth.Sasl == auth.BackendStatic {
+		return nil, fmt.Errorf("the %s backend can't check SASL", auth.BackendStatic)
+	}
+
+	a.sasl, err = newAuthenticator(cf, cf.Auth.Sasl)
+	if err != nil {
+		return nil, err
+	}
+
+	if oauth := cf.OAuth; oauth.JwksFile != "" {
+		a.tokens, err = auth.NewJWT(oauth.JwksFile, oauth.Issuer, oauth.Audience, oauth.Claim)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return a, nil
+}
+
+// install makes the server use the backends, the disabled ones are nil
+func (a *authenticators) install() {
+	s.PassAuth = a.pass
+	s.SaslAuth = a.sasl
+	s.Tokens = a.tokens
+}
+
+func newAuthenticator(cf *config.Config, backend string) (auth.Authenticator, error) {
+	switch backend {
+	case auth.BackendStatic:
+		return &auth.Static{Password: cf.Server.Password}, nil
+
+	case auth.BackendAccounts:
+		return &auth.Accounts{Store: s.Accounts}, nil
+
+	case auth.BackendHtpasswd:
+		return auth.NewHtpasswd(cf.Auth.HtpasswdFile)
+
+	case auth.BackendExec:
+		if cf.Auth.ExecCommand == "" {
+			return nil, fmt.Errorf("no command given to the %s backend", backend)
+		}
+
+		return auth.NewExec(cf.Auth.ExecCommand, time.Duration(cf.Auth.ExecTimeout)*time.Second), nil
+	}
+
+	return nil, fmt.Errorf("unknown authentication backend %s", backend)
+}
+
+// loadMailer sets the SMTP relay codes are sent through up, nil if none is
+// configured.
+func loadMailer(cf config.Smtp) (*mailer.Mailer, error) {
+	if cf.Server == "" {
+		return nil, nil
+	}
+
+	m, err := mailer.New(cf.Server, cf.From, cf.Username, cf.Password, time.Duration(cf.Interval)*time.Second)
+	if err != nil {
+		return nil, err
+	}
+
+	if cf.VerifyTemplate != "" {
+		err = m.LoadTemplate(mailer.TemplateVerify, cf.VerifyTemplate)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if cf.ResetTemplate != "" {
+		err = m.LoadTemplate(mailer.TemplateReset, cf.ResetTemplate)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return m, nil
+}
+
+// registrationFlags tells clients how accounts may be registered
+func registrationFlags() string {
+	var flags []string
+
+	if s.Config.Register.BeforeConnect {
+		flags = append(flags, "before-connect")
+	}
+
+	if s.Config.EmailRequired() {
+		flags = append(flags, "email-required")
+	}
+
+	return strings.Join(flags, ",")
+}
+
+// loadHistory opens the history of channels and the one of private
+// conversations, which lives in a sub directory.
+func loadHistory() (err error) {
+	cf := s.Config.History
+	if !cf.Enabled {
+		return nil
+	}
+
+	s.History, err = openHistory(cf.Dir, cf.MaxItems, cf.MaxAge)
+	if err != nil || !cf.DirectMessages {
+		return err
+	}
+
+	dir := cf.Dir
+	if dir != "" {
+		dir = filepath.Join(dir, "direct")
+	}
+
+	s.Direct, err = openHistory(dir, cf.DirectMaxItems, cf.DirectMaxAge)
+
+	return err
+}
+
+func openHistory(dir string, maxItems int, maxAge int) (history.Store, error) {
+	age := time.Duration(maxAge) * time.Second
+
+	if dir == "" {
+		return history.NewMemoryStore(maxItems, age), nil
+	}
+
+	return history.NewFileStore(dir, maxItems, age)
+}
+
+// doListen accepts the connections of a port, D-lines are checked before the
+// TLS handshake when tlsConfig is set.
+func doListen(listener net.Listener, tlsConfig *tls.Config) {
 	for {
 		conn, err := listener.Accept()
 		if err != nil {
@@ -236,6 +661,22 @@ func doListen(listener net.Listener) {
 			break
 		}
 
+		if b := module.FindDline(s, conn.RemoteAddr()); b != nil {
+			log.Printf("[BAN] Refused connection from %s, D-lined: %s", conn.RemoteAddr(), b.Reason)
+
+			if tlsConfig == nil {
+				host, _, _ := net.SplitHostPort(conn.RemoteAddr().String())
+				fmt.Fprintf(conn, "ERROR :Closing Link: %s (D-lined: %s)\r\n", host, b.Reason)
+			}
+
+			conn.Close()
+			continue
+		}
+
+		if tlsConfig != nil {
+			conn = tls.Server(conn, tlsConfig)
+		}
+
 		log.Printf("[starfruit] Accepted connection from: %s", conn.RemoteAddr())
 
 		u := user.New(s.Config, conn)
@@ -266,26 +707,52 @@ func init() {
 
 	commands = make(map[string]interface{})
 
+	registerCmd("AUTHENTICATE", &module.Authenticate{})
 	registerCmd("AWAY", &module.Away{})
+	registerCmd("BATCH", &module.Batch{})
+	registerCmd("CAP", &module.Cap{})
+	registerCmd("CHATHISTORY", &module.ChatHistory{})
+	registerCmd("CHGHOST", &module.ChgHost{})
+	registerCmd("DIE", &module.Die{})
+	registerCmd("DLINE", &module.Dline{})
+	registerCmd("GLINE", &module.Gline{})
 	registerCmd("INFO", &module.Info{})
 	registerCmd("INVITE", &module.Invite{})
 	registerCmd("ISON", &module.Ison{})
 	registerCmd("JOIN", &module.Join{})
+	registerCmd("KILL", &module.Kill{})
+	registerCmd("KLINE", &module.Kline{})
 	registerCmd("LIST", &module.List{})
+	registerCmd("MEMO", &module.Memo{})
+	registerCmd("METADATA", &module.Metadata{})
 	registerCmd("MODE", &module.Mode{})
+	registerCmd("MONITOR", &module.Monitor{})
 	registerCmd("MOTD", &module.Motd{})
+	registerCmd("NAMES", &module.Names{})
 	registerCmd("NICK", &module.Nick{})
+	registerCmd("NOTICE", &module.Notice{})
+	registerCmd("OPER", &module.Oper{})
 	registerCmd("PART", &module.Part{})
 	registerCmd("PASS", &module.Pass{})
 	registerCmd("PING", &module.Ping{})
 	registerCmd("PONG", &module.Pong{})
 	registerCmd("PRIVMSG", &module.Privmsg{})
 	registerCmd("QUIT", &module.Quit{})
+	registerCmd("REDACT", &module.Redact{})
+	registerCmd("REGISTER", &module.Register{})
+	registerCmd("REHASH", &module.Rehash{})
+	registerCmd("STATS", &module.Stats{})
+	registerCmd("TAGMSG", &module.TagMsg{})
 	registerCmd("TIME", &module.Time{})
 	registerCmd("TOPIC", &module.Topic{})
+	registerCmd("UNDLINE", &module.Undline{})
+	registerCmd("UNGLINE", &module.Ungline{})
+	registerCmd("UNKLINE", &module.Unkline{})
 	registerCmd("USER", &module.User{})
 	//registerCmd("USERS", &module.Users{})
+	registerCmd("VERIFY", &module.Verify{})
 	registerCmd("VERSION", &module.Version{})
+	registerCmd("WALLOPS", &module.Wallops{})
 	registerCmd("WHO", &module.Who{})
 	registerCmd("WHOIS", &module.Whois{})
 }
@@ -298,72 +765,61 @@ func main() {
 
 	flag.Parse()
 
-	if configFile != "" {
-		err := s.Config.LoadFromFile(configFile)
-		if err != nil {
-			log.Fatalf("[starfruit] Failed to load the configuration file :%s", err)
-			return
-		}
-		log.Printf("[starfruit] Load the configuration file :%s", configFile)
-	}
-
-	/* Handle overrided parameters from command line */
-	if configIp != "" {
-		s.Config.Server.Ip = configIp
-	}
-
-	if configPorts != "" {
-		ports := strings.Split(configPorts, ",")
-		for _, port := range ports {
-			s.Config.Server.Ports = nil
-			port, err := strconv.Atoi(port)
-			if err != nil {
-				log.Fatalf("[starfruit] Port specified error, %s", err)
-				return
-			}
-			s.Config.Server.Ports = append(s.Config.Server.Ports, port)
-		}
+	cf, err := loadConfig()
+	if err != nil {
+		log.Fatalf("[starfruit] Failed to load the configuration file :%s", err)
+		return
 	}
+	*s.Config = *cf
 
-	if configServerName != "" {
-		s.Config.Server.Name = configServerName
-	}
+	s.Rehash = rehash
 
-	if configEnableAuth && configPassword != "" {
-		s.Config.Server.Password = configPassword
+	s.Accounts, err = account.NewFileStore(s.Config.Account.File)
+	if err != nil {
+		log.Fatalf("[starfruit] Failed to load the accounts :%s", err)
+		return
 	}
 
-	if configMotdFile != "" {
-		s.Config.Motd.File = configMotdFile
+	backends, err := loadAuth(s.Config)
+	if err != nil {
+		log.Fatalf("[starfruit] Failed to set the authentication up :%s", err)
+		return
 	}
+	backends.install()
 
-	if configSSL {
-		s.Config.Server.SSL = configSSL
+	s.Mailer, err = loadMailer(s.Config.Smtp)
+	if err != nil {
+		log.Fatalf("[starfruit] Failed to set the SMTP relay up :%s", err)
+		return
 	}
 
-	if configCertFile != "" {
-		s.Config.Server.CertFile = configCertFile
+	s.Registry, err = registry.NewFileStore(s.Config.ChanServ.File)
+	if err != nil {
+		log.Fatalf("[starfruit] Failed to load the registered channels :%s", err)
+		return
 	}
 
-	if configKeyFile != "" {
-		s.Config.Server.KeyFile = configKeyFile
-	}
+	module.RestoreChannels(s)
 
-	if configPingUserInterval > -1 {
-		s.Config.Recycle.PingInterval = configPingUserInterval
+	s.Bans, err = ban.NewFileStore(s.Config.Ban.File)
+	if err != nil {
+		log.Fatalf("[starfruit] Failed to load the server bans :%s", err)
+		return
 	}
 
-	if configUserTimeout > -1 {
-		s.Config.Recycle.UserTimeout = configUserTimeout
+	err = loadHistory()
+	if err != nil {
+		log.Fatalf("[starfruit] Failed to load the history :%s", err)
+		return
 	}
 
-	if configDisabledCommands != "" {
-		commands := strings.Split(configDisabledCommands, ",")
-		s.Config.Server.DisabledCommands = commands
-	}
+	registerISupport()
+	registerCaps()
 
 	/* Listen on all ports */
 	for _, port := range s.Config.Server.Ports {
+		var config *tls.Config
+
 		if s.Config.Server.SSL {
 			cert, err := tls.LoadX509KeyPair(s.Config.Server.CertFile, s.Config.Server.KeyFile)
 			if err != nil {
@@ -371,22 +827,22 @@ func main() {
 				return
 			}
 
-			config := tls.Config{Certificates: []tls.Certificate{cert}}
+			config = &tls.Config{Certificates: []tls.Certificate{cert}}
 			config.Rand = rand.Reader
 
-			listener, err = tls.Listen("tcp", fmt.Sprintf("%s:%d", s.Config.Server.Ip, port), &config)
-			if err != nil {
-				log.Fatalf("[starfruit] Failed to start the SERVER(SSL), %s", err)
-				return
-			}
-		} else {
-			listener, err = net.Listen("tcp", fmt.Sprintf("%s:%d", s.Config.Server.Ip, port))
-			if err != nil {
-				log.Fatalf("[starfruit] Failed to start the SERVER, %s", err)
-			}
+			// Client certificates are optional, used by SASL EXTERNAL
+			config.ClientAuth = tls.RequestClientCert
+		}
+
+		// The TLS handshake is left to doListen so D-lined addresses are
+		// refused before it
+		listener, err = net.Listen("tcp", fmt.Sprintf("%s:%d", s.Config.Server.Ip, port))
+		if err != nil {
+			log.Fatalf("[starfruit] Failed to start the SERVER, %s", err)
+			return
 		}
 
-		go doListen(listener)
+		go doListen(listener, config)
 	}
 
 	log.Printf("[starfruit] Server started at %s",
@@ -0,0 +1,142 @@
+/*
+ * Copyright 2014 The starfruit Authors. All rights reserved.
+ * Use of this source code is governed by a BSD-style
+ * license that can be found in the LICENSE file.
+ */
+
+package user
+
+import (
+	"github.com/flatpeach/starfruit/capability"
+	"github.com/flatpeach/starfruit/message"
+)
+
+// ClientBatch is a batch a client is sending, its messages are held back
+// until the batch ends.
+type ClientBatch struct {
+	Ref    string
+	Type   string
+	Params []string
+	Label  string // Label of the opening BATCH, replied to once the batch ends
+	Msgs   []*message.Message
+	Bytes  int  // Length of all the messages text
+	Failed bool // Messages are dropped until the batch ends
+}
+
+// StartCollecting holds back the replies sent to this user with SendMessage
+// from now on, until StopCollecting returns them, so the replies to a command
+// can be handled as a whole. Messages delivered with Deliver are never held
+// back, as they don't answer the command.
+func (u *User) StartCollecting() {
+	u.mutex.Lock()
+	defer u.mutex.Unlock()
+
+	u.collected = make([]*message.Message, 0)
+}
+
+func (u *User) StopCollecting() []*message.Message {
+	u.mutex.Lock()
+	defer u.mutex.Unlock()
+
+	collected := u.collected
+	u.collected = nil
+
+	return collected
+}
+
+// collect keeps a message back if collecting, tells whether it did
+func (u *User) collect(m *message.Message) bool {
+	u.mutex.Lock()
+	defer u.mutex.Unlock()
+
+	if u.collected == nil {
+		return false
+	}
+
+	u.collected = append(u.collected, m)
+
+	return true
+}
+
+// SendBatch sends messages wrapped in a BATCH for users who negotiated
+// batch, or as they are for the others.
+func (u *User) SendBatch(batchType string, params []string, msgs []*message.Message) {
+	u.sendBatch(u.SendMessage, batchType, params, msgs, nil)
+}
+
+// SendBatchWithTags is SendBatch with tags on the opening BATCH
+func (u *User) SendBatchWithTags(batchType string, params []string, tags map[string]string, msgs []*message.Message) {
+	u.sendBatch(u.SendMessage, batchType, params, msgs, tags)
+}
+
+// DeliverBatch is SendBatchWithTags for messages the user didn't ask for,
+// see Deliver.
+func (u *User) DeliverBatch(batchType string, params []string, tags map[string]string, msgs []*message.Message) {
+	u.sendBatch(u.Deliver, batchType, params, msgs, tags)
+}
+
+// SendLabeledResponse sends the replies to a command carrying a label, an
+// ACK if there is none, the reply tagged if there is only one, a batch
+// otherwise.
+func (u *User) SendLabeledResponse(label string, msgs []*message.Message) {
+	switch len(msgs) {
+	case 0:
+		ack := message.New(
+			u.Config.Server.Name,
+			"ACK",
+			nil,
+			nil,
+		)
+		ack.SetTag("label", label)
+
+		u.SendMessage(ack)
+
+	case 1:
+		u.SendMessage(msgs[0].WithTag("label", label))
+
+	default:
+		u.sendBatch(u.SendMessage, "labeled-response", nil, msgs, map[string]string{"label": label})
+	}
+}
+
+// sendBatch wraps messages in a BATCH, each line is sent with send
+func (u *User) sendBatch(send func(m *message.Message), batchType string, params []string, msgs []*message.Message, tags map[string]string) {
+	if !u.HasCap(capability.Batch) {
+		for _, m := range msgs {
+			send(m)
+		}
+
+		return
+	}
+
+	id := message.NewMsgId()
+
+	start := message.New(
+		u.Config.Server.Name,
+		"BATCH",
+		append([]string{"+" + id, batchType}, params...),
+		nil,
+	)
+	for k, v := range tags {
+		start.SetTag(k, v)
+	}
+
+	send(start)
+
+	for _, m := range msgs {
+		if _, exists := m.Tag("batch"); exists {
+			// Already part of a nested batch, whose BATCH lines are ours
+			send(m)
+			continue
+		}
+
+		send(m.WithTag("batch", id))
+	}
+
+	send(message.New(
+		u.Config.Server.Name,
+		"BATCH",
+		[]string{"-" + id},
+		nil,
+	))
+}
@@ -0,0 +1,48 @@
+/*
+ * Copyright 2014 The starfruit Authors. All rights reserved.
+ * Use of this source code is governed by a BSD-style
+ * license that can be found in the LICENSE file.
+ */
+
+package user
+
+import (
+	"sort"
+)
+
+// Subscribe adds a metadata key this user wants to be told about when its
+// value changes.
+func (u *User) Subscribe(key string) {
+	u.mutex.Lock()
+	defer u.mutex.Unlock()
+
+	u.subs[key] = true
+}
+
+func (u *User) Unsubscribe(key string) {
+	u.mutex.Lock()
+	defer u.mutex.Unlock()
+
+	delete(u.subs, key)
+}
+
+func (u *User) IsSubscribed(key string) bool {
+	u.mutex.Lock()
+	defer u.mutex.Unlock()
+
+	return u.subs[key]
+}
+
+// Subscriptions returns the keys this user subscribed to, sorted
+func (u *User) Subscriptions() []string {
+	u.mutex.Lock()
+	defer u.mutex.Unlock()
+
+	var keys []string
+	for key := range u.subs {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	return keys
+}
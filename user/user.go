@@ -8,13 +8,20 @@ package user
 
 import (
 	"bufio"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/hex"
 	"fmt"
+	"github.com/flatpeach/starfruit/capability"
 	"github.com/flatpeach/starfruit/config"
+	"github.com/flatpeach/starfruit/isupport"
 	"github.com/flatpeach/starfruit/message"
+	"github.com/flatpeach/starfruit/metadata"
 	"github.com/flatpeach/starfruit/version"
 	"log"
 	"net"
 	"os"
+	"sort"
 	"sync"
 	"time"
 )
@@ -39,6 +46,24 @@ const (
 	ModeReceiveServiceNotice     Mode = 1 << 6
 )
 
+// Capabilities operator classes may give
+const (
+	OperKill                 = "kill"                   // KILL users
+	OperRehash               = "rehash"                 // Reload the configuration
+	OperDie                  = "die"                    // Shut the server down
+	OperWallops              = "wallops"                // Send WALLOPS
+	OperSeeSecret            = "see-secret"             // See secret and private channels
+	OperOverrideChannelModes = "override-channel-modes" // Join, set modes and topics of any channel
+	OperManageBans           = "manage-bans"            // Manage server bans
+	OperChgHost              = "chghost"                // Change the host of users
+	OperServices             = "services"               // Act on any account, nick and registered channel
+)
+
+// All user modes, as shown in RPL_MYINFO
+const AvailableModes = "aiwroOs"
+
+const MaxNickNameLength = 30
+
 func (m Mode) String() string {
 	switch m {
 	case ModeAway:
@@ -66,10 +91,17 @@ func (m Mode) String() string {
 	return "Unknown"
 }
 
+// State of an ongoing SASL authentication
+type SaslSession struct {
+	Mechanism string
+	Buffer    string // Data received so far, still base64 encoded
+}
+
 type User struct {
 	Config *config.Config // Global Server Config
 
-	Conn net.Conn // Original TCP connection
+	Conn   net.Conn // Original TCP connection
+	ConnId string   // Random id of the connection, never reused
 
 	Id           int
 	UserName     string
@@ -78,25 +110,43 @@ type User struct {
 	HostName     string // Hostname this user try to connect
 	LastPongTime int64  // Last time this user reply a PONG message
 
+	Sasl     *SaslSession  // SASL authentication in progress
+	Batch    *ClientBatch  // Batch the client is sending
+	Metadata *metadata.Map // Key/value pairs shown with METADATA
+
+	NickTimer *time.Timer // Renames the user unless he logs in to the owner of his nick
+
 	In  chan []byte
 	Out chan []byte
 
-	awayMsg string // Away message for this user
-	status  int    // @Todo: Replace this with real FSM
-	modes   Mode
-	mutex   sync.Mutex
+	account        string // Account this user is logged in as
+	operClass      string // Class of operator this user opered up as
+	awayMsg        string // Away message for this user
+	status         int    // @Todo: Replace this with real FSM
+	modes          Mode
+	caps           map[string]bool    // IRCv3 capabilities enabled for this user
+	capVersion     int                // CAP version announced by the client
+	capNegotiating bool               // Registration suspended until CAP END
+	collected      []*message.Message // Messages held back, see StartCollecting
+	subs           map[string]bool    // Metadata keys this user subscribed to
+	mutex          sync.Mutex
+	requests       sync.Mutex // Held while a request is handled, see Serialize
 }
 
 func New(cf *config.Config, conn net.Conn) *User {
 	u := &User{
 		Config:       cf,
 		Conn:         conn,
+		ConnId:       message.NewMsgId(),
 		status:       StatusPasswordNotVerified,
 		LastPongTime: time.Now().Unix(),
 		Id:           0,
+		caps:         make(map[string]bool),
+		subs:         make(map[string]bool),
+		Metadata:     metadata.New(),
 	}
 
-	if cf.Server.Password == "" {
+	if !cf.PasswordRequired() {
 		u.EnterStatus(StatusPasswordVerified)
 	} else {
 		u.EnterStatus(StatusPasswordNotVerified)
@@ -108,6 +158,71 @@ func New(cf *config.Config, conn net.Conn) *User {
 	return u
 }
 
+func (u *User) Account() string {
+	u.mutex.Lock()
+	defer u.mutex.Unlock()
+
+	return u.account
+}
+
+func (u *User) SetAccount(name string) {
+	u.mutex.Lock()
+	defer u.mutex.Unlock()
+
+	u.account = name
+}
+
+// Oper makes the user an operator of a class
+func (u *User) Oper(class string) {
+	u.mutex.Lock()
+	defer u.mutex.Unlock()
+
+	u.operClass = class
+	u.modes |= ModeOperator
+}
+
+// OperClass returns the class of an operator, empty for other users
+func (u *User) OperClass() string {
+	u.mutex.Lock()
+	defer u.mutex.Unlock()
+
+	if u.modes&ModeOperator == 0 {
+		return ""
+	}
+
+	return u.operClass
+}
+
+// Can tells whether the user is an operator whose class has a capability,
+// classes are looked up every time so that a rehash applies right away.
+func (u *User) Can(capability string) bool {
+	class := u.OperClass()
+
+	return class != "" && u.Config.ClassCan(class, capability)
+}
+
+func (u *User) IsLoggedIn() bool {
+	return u.Account() != ""
+}
+
+// CertFingerprint returns the SHA-256 fingerprint of the certificate this
+// user presented over TLS, empty if there is none.
+func (u *User) CertFingerprint() string {
+	conn, ok := u.Conn.(*tls.Conn)
+	if !ok {
+		return ""
+	}
+
+	state := conn.ConnectionState()
+	if len(state.PeerCertificates) == 0 {
+		return ""
+	}
+
+	sum := sha256.Sum256(state.PeerCertificates[0].Raw)
+
+	return hex.EncodeToString(sum[:])
+}
+
 func (u *User) AwayMsg() string {
 	u.mutex.Lock()
 	defer u.mutex.Unlock()
@@ -151,6 +266,13 @@ func (u *User) MarkMode(m Mode) {
 	u.modes |= m
 }
 
+func (u *User) HasMode(m Mode) bool {
+	u.mutex.Lock()
+	defer u.mutex.Unlock()
+
+	return u.modes&m > 0
+}
+
 func (u *User) ClearMode(m Mode) {
 	u.mutex.Lock()
 	defer u.mutex.Unlock()
@@ -195,6 +317,71 @@ func (u *User) Modes() string {
 	return s
 }
 
+func (u *User) HasCap(name string) bool {
+	u.mutex.Lock()
+	defer u.mutex.Unlock()
+
+	return u.caps[name]
+}
+
+func (u *User) EnableCap(name string) {
+	u.mutex.Lock()
+	defer u.mutex.Unlock()
+
+	u.caps[name] = true
+}
+
+func (u *User) DisableCap(name string) {
+	u.mutex.Lock()
+	defer u.mutex.Unlock()
+
+	delete(u.caps, name)
+}
+
+// Caps returns the name of all capabilities enabled, sorted
+func (u *User) Caps() []string {
+	u.mutex.Lock()
+	defer u.mutex.Unlock()
+
+	var names []string
+	for name := range u.caps {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+func (u *User) CapVersion() int {
+	u.mutex.Lock()
+	defer u.mutex.Unlock()
+
+	return u.capVersion
+}
+
+func (u *User) SetCapVersion(v int) {
+	u.mutex.Lock()
+	defer u.mutex.Unlock()
+
+	if v > u.capVersion {
+		u.capVersion = v
+	}
+}
+
+func (u *User) IsNegotiatingCaps() bool {
+	u.mutex.Lock()
+	defer u.mutex.Unlock()
+
+	return u.capNegotiating
+}
+
+func (u *User) SetNegotiatingCaps(b bool) {
+	u.mutex.Lock()
+	defer u.mutex.Unlock()
+
+	u.capNegotiating = b
+}
+
 func (u *User) IsRegistered() bool {
 	u.mutex.Lock()
 	defer u.mutex.Unlock()
@@ -221,7 +408,49 @@ func (u *User) Full() string {
 	u.mutex.Lock()
 	defer u.mutex.Unlock()
 
-	return u.NickName + "!~" + u.UserName + "@" + u.HostName
+	return u.NickName + "!" + Ident(u.UserName) + "@" + u.HostName
+}
+
+// Ident returns a user name as shown in masks, "~" tells it wasn't checked
+// with an ident server.
+func Ident(userName string) string {
+	return "~" + userName
+}
+
+// UserHost returns the user name and host of the user, which CHGHOST may
+// change from another goroutine.
+func (u *User) UserHost() (string, string) {
+	u.mutex.Lock()
+	defer u.mutex.Unlock()
+
+	return u.UserName, u.HostName
+}
+
+// SetHost changes the user name and host of the user, safe to call while
+// other goroutines read them through Full or UserHost.
+func (u *User) SetHost(userName string, hostName string) {
+	u.mutex.Lock()
+	defer u.mutex.Unlock()
+
+	u.UserName = userName
+	u.HostName = hostName
+}
+
+// Nick returns the nick of the user, safe to call while he is renamed
+func (u *User) Nick() string {
+	u.mutex.Lock()
+	defer u.mutex.Unlock()
+
+	return u.NickName
+}
+
+// SetNick renames the user, safe to call while other goroutines read his
+// nick through Full or Nick.
+func (u *User) SetNick(nick string) {
+	u.mutex.Lock()
+	defer u.mutex.Unlock()
+
+	u.NickName = nick
 }
 
 func (u *User) Close() {
@@ -248,6 +477,16 @@ func (u *User) Close() {
 	close(u.Out)
 }
 
+// Serialize runs f while none of the requests of the user is handled, work
+// done outside of his request loop, by a timer for instance, must go through
+// it so it never races with them.
+func (u *User) Serialize(f func()) {
+	u.requests.Lock()
+	defer u.requests.Unlock()
+
+	f()
+}
+
 func (u *User) IsPasswordVerified() bool {
 	u.mutex.Lock()
 	defer u.mutex.Unlock()
@@ -259,11 +498,30 @@ func (u *User) IsPasswordVerified() bool {
 	return false
 }
 
+// SendMessage sends a reply to the command the user is sending, held back
+// while collecting, see StartCollecting.
 func (u *User) SendMessage(m *message.Message) {
+	if m != nil && u.collect(m) {
+		return
+	}
+
+	u.Deliver(m)
+}
+
+// Deliver sends a message the user didn't ask for, relayed from another user
+// or sent by the server on its own, it is never held back as a reply.
+func (u *User) Deliver(m *message.Message) {
 	if u.IsDisconnecting() {
 		return
 	}
 	if m != nil {
+		if len(m.Tags) > 0 {
+			// Only send the tags this user negotiated
+			m = m.FilterTags(func(tag string) bool {
+				return capability.TagAllowed(tag, u.HasCap)
+			})
+		}
+
 		data := m.String() + "\r\n"
 		u.Out <- []byte(data)
 	} else {
@@ -289,6 +547,32 @@ func (u *User) SendErrorNeedMoreParams(c string) {
 	u.SendMessage(m)
 }
 
+// SendFail, SendWarn and SendNote send the standard replies, made of the
+// command concerned or "*", a machine-readable code, some context and a
+// description for humans.
+func (u *User) SendFail(command string, code string, context []string, description string) {
+	u.sendStandardReply("FAIL", command, code, context, description)
+}
+
+func (u *User) SendWarn(command string, code string, context []string, description string) {
+	u.sendStandardReply("WARN", command, code, context, description)
+}
+
+func (u *User) SendNote(command string, code string, context []string, description string) {
+	u.sendStandardReply("NOTE", command, code, context, description)
+}
+
+func (u *User) sendStandardReply(kind string, command string, code string, context []string, description string) {
+	params := append([]string{command, code}, context...)
+
+	u.SendMessage(message.New(
+		u.Config.Server.Name,
+		kind,
+		params,
+		description,
+	))
+}
+
 func (u *User) EnterStatus(s int) {
 	u.mutex.Lock()
 	defer u.mutex.Unlock()
@@ -349,7 +633,40 @@ func (u *User) SendMotd() {
 	))
 }
 
-func (u *User) SendWelcomeMessage() {
+func (u *User) SendISupport(r *isupport.Registry) {
+	u.sendISupport(u.SendMessage, r.Tokens())
+}
+
+// DeliverISupport tells a registered user about the tokens which changed,
+// removed tokens are given as -<name>.
+func (u *User) DeliverISupport(tokens []string) {
+	u.sendISupport(u.Deliver, tokens)
+}
+
+func (u *User) sendISupport(send func(m *message.Message), tokens []string) {
+	nickName := u.NickName
+	if nickName == "" {
+		nickName = "*"
+	}
+
+	// Room left for the tokens once prefix, numeric, nick and trailing are counted
+	size := 510 - len(fmt.Sprintf(":%s %s %s  :are supported by this server",
+		u.Config.Server.Name,
+		message.RPL_ISUPPORT,
+		nickName,
+	))
+
+	for _, line := range isupport.Split(tokens, size) {
+		send(message.New(
+			u.Config.Server.Name,
+			message.RPL_ISUPPORT,
+			append([]string{nickName}, line...),
+			"are supported by this server",
+		))
+	}
+}
+
+func (u *User) SendWelcomeMessage(r *isupport.Registry) {
 	u.SendMessage(message.New(
 		u.Config.Server.Name,
 		message.RPL_WELCOME,
@@ -379,16 +696,17 @@ func (u *User) SendWelcomeMessage() {
 	u.SendMessage(message.New(
 		u.Config.Server.Name,
 		message.RPL_MYINFO,
-		[]string{u.NickName},
-		fmt.Sprintf("%s %s", u.Config.Server.Name, version.Version()),
+		[]string{
+			u.NickName,
+			u.Config.Server.Name,
+			version.Version(),
+			AvailableModes,
+			r.ChannelModes(),
+		},
+		nil,
 	))
 
-	// u.SendMessage(message.New(
-	// 	u.Config.ServerName,
-	// 	message.RPL_BOUNCE,
-	// 	[]string{u.NickName},
-	// 	nil,
-	// ))
+	u.SendISupport(r)
 
 	u.SendMotd()
 